@@ -0,0 +1,274 @@
+package accounts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/opencost/opencost-plugins/common/costerror"
+	"github.com/opencost/opencost-plugins/common/filter"
+	"github.com/opencost/opencost/core/pkg/model/pb"
+	ocplugin "github.com/opencost/opencost/core/pkg/plugin"
+)
+
+// MetadataKey is the cost Metadata key holding the name of the configured account a cost was reported
+// for, so label rules can match it as metadata.account
+const MetadataKey = "account"
+
+// Account is one of the named accounts a plugin reports costs for, with the cost source built from its
+// credentials.
+type Account struct {
+	Name   string
+	Source ocplugin.CustomCostSource
+}
+
+// Field is a credential a plugin config takes at the top level for a single account
+type Field struct {
+	Key   string
+	Value string
+}
+
+// ConfigProblems validates a plugin config that takes either a single account's credentials at the top
+// level, or a list of named accounts with their own. Exactly one of the two must be given, and account
+// names must be unique. It returns the problems found, for a config's Validate method.
+func ConfigProblems(names []string, credentials ...Field) []string {
+	var problems []string
+	if len(names) == 0 {
+		for _, f := range credentials {
+			if f.Value == "" {
+				problems = append(problems, fmt.Sprintf("%s is required", f.Key))
+			}
+		}
+		return problems
+	}
+
+	for _, f := range credentials {
+		if f.Value != "" {
+			problems = append(problems, fmt.Sprintf("%s can't be set along with accounts, set it on each account instead", f.Key))
+		}
+	}
+
+	seen := map[string]bool{}
+	for i, name := range names {
+		if name != "" && seen[name] {
+			problems = append(problems, fmt.Sprintf("accounts[%d].name %q is already used by another account", i, name))
+		}
+		seen[name] = true
+	}
+	return problems
+}
+
+// Source returns a cost source that queries every account at once and merges their responses by window.
+// Each cost is tagged with its account's name under MetadataKey, and each error is prefixed with it. An
+// account that fails doesn't hide the costs of the others: an error it reports outside of any window,
+// such as a rejected credential, is added to every window the account returned no response for instead.
+//
+// A single account without a name is returned as is, for configs with their credentials at the top level.
+func Source(accounts []Account) ocplugin.CustomCostSource {
+	if len(accounts) == 1 && accounts[0].Name == "" {
+		return accounts[0].Source
+	}
+	return multiSource{accounts: accounts}
+}
+
+// multiSource serves the costs of several accounts as one source
+type multiSource struct {
+	accounts []Account
+}
+
+func (s multiSource) GetCustomCosts(req *pb.CustomCostRequest) []*pb.CustomCostResponse {
+	results := make([][]*pb.CustomCostResponse, len(s.accounts))
+
+	// each account has its own credentials and rate limits, so they are queried at the same time
+	var wg sync.WaitGroup
+	for i, account := range s.accounts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = account.Source.GetCustomCosts(req)
+		}()
+	}
+	wg.Wait()
+
+	return s.merge(results)
+}
+
+// windowKey identifies a response window
+type windowKey struct {
+	start, end int64
+}
+
+// keyOf returns the key of a response's window
+func keyOf(resp *pb.CustomCostResponse) windowKey {
+	return windowKey{resp.Start.AsTime().UnixNano(), resp.End.AsTime().UnixNano()}
+}
+
+// merge combines each account's responses into one response per window, in window order
+func (s multiSource) merge(results [][]*pb.CustomCostResponse) []*pb.CustomCostResponse {
+	byWindow := map[windowKey]*pb.CustomCostResponse{}
+	excluded := map[windowKey]*filter.Excluded{}
+	var windows []*pb.CustomCostResponse
+	// failures are the errors each account reported outside of any window, and served the windows it returned
+	failures := make([][]error, len(results))
+	served := make([]map[windowKey]bool, len(results))
+
+	for i, responses := range results {
+		name := s.accounts[i].Name
+		served[i] = map[windowKey]bool{}
+		for _, resp := range responses {
+			if resp == nil {
+				continue
+			}
+
+			if resp.Start == nil || resp.End == nil {
+				for _, err := range costerror.Errors(resp) {
+					failures[i] = append(failures[i], fmt.Errorf("account %s: %w", name, err))
+				}
+				continue
+			}
+
+			key := keyOf(resp)
+			served[i][key] = true
+			merged, found := byWindow[key]
+			if !found {
+				merged = emptyWindow(resp)
+				byWindow[key] = merged
+				excluded[key] = &filter.Excluded{}
+				windows = append(windows, merged)
+			}
+			excluded[key].Merge(filter.Recorded(resp))
+
+			for _, cost := range resp.Costs {
+				if cost == nil {
+					continue
+				}
+				if cost.Metadata == nil {
+					cost.Metadata = map[string]string{}
+				}
+				cost.Metadata[MetadataKey] = name
+				merged.Costs = append(merged.Costs, cost)
+			}
+			for _, err := range costerror.Errors(resp) {
+				costerror.Add(merged, fmt.Errorf("account %s: %w", name, err))
+			}
+		}
+	}
+
+	if len(windows) == 0 {
+		resp := &pb.CustomCostResponse{}
+		for _, accountFailures := range failures {
+			for _, err := range accountFailures {
+				costerror.Add(resp, err)
+			}
+		}
+		if len(resp.Errors) == 0 {
+			return []*pb.CustomCostResponse{}
+		}
+		return []*pb.CustomCostResponse{resp}
+	}
+
+	sort.SliceStable(windows, func(i, j int) bool {
+		return windows[i].Start.AsTime().Before(windows[j].Start.AsTime())
+	})
+
+	for key, totals := range excluded {
+		totals.Record(byWindow[key])
+	}
+
+	// an account that failed outright is missing from every window it didn't return
+	for _, window := range windows {
+		key := keyOf(window)
+		for i, accountFailures := range failures {
+			if served[i][key] {
+				continue
+			}
+			for _, err := range accountFailures {
+				costerror.Add(window, err)
+			}
+		}
+	}
+
+	return windows
+}
+
+// windowTotals are the response Metadata keys that total every account's response for a window
+var windowTotals = map[string]bool{
+	costerror.MetadataKey:        true,
+	filter.ExcludedCostsKey:      true,
+	filter.ExcludedBilledCostKey: true,
+}
+
+// emptyWindow returns a response for resp's window without its costs, errors and totals, which are added per account
+func emptyWindow(resp *pb.CustomCostResponse) *pb.CustomCostResponse {
+	metadata := map[string]string{}
+	for k, v := range resp.Metadata {
+		if !windowTotals[k] {
+			metadata[k] = v
+		}
+	}
+
+	return &pb.CustomCostResponse{
+		Metadata:   metadata,
+		CostSource: resp.CostSource,
+		Domain:     resp.Domain,
+		Version:    resp.Version,
+		Currency:   resp.Currency,
+		Start:      resp.Start,
+		End:        resp.End,
+		Errors:     []string{},
+		Costs:      []*pb.CustomCost{},
+	}
+}
+
+// CheckError is returned by the preflight check of a multi-account source when accounts fail it. Each
+// problem is prefixed with its account's name.
+type CheckError struct {
+	// Failed is how many accounts failed the check, out of Total
+	Failed int
+	Total  int
+	Err    error
+}
+
+func (e *CheckError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CheckError) Unwrap() error {
+	return e.Err
+}
+
+// Partial reports whether some accounts passed the check, so the plugin can still serve them.
+func (e *CheckError) Partial() bool {
+	return e.Failed < e.Total
+}
+
+// Check runs the preflight check of every account that has one, reporting each problem on its own line
+// prefixed with the account's name. A failed check returns a *CheckError.
+func (s multiSource) Check(ctx context.Context) error {
+	var errs []error
+	failed := 0
+	for _, account := range s.accounts {
+		checker, ok := account.Source.(interface{ Check(context.Context) error })
+		if !ok {
+			continue
+		}
+
+		err := checker.Check(ctx)
+		if err == nil {
+			continue
+		}
+		failed++
+		// problems joined with errors.Join are reported a line each, so every line names the account
+		for _, line := range strings.Split(err.Error(), "\n") {
+			errs = append(errs, fmt.Errorf("account %s: %s", account.Name, line))
+		}
+	}
+
+	if failed == 0 {
+		return nil
+	}
+	return &CheckError{Failed: failed, Total: len(s.accounts), Err: errors.Join(errs...)}
+}
@@ -0,0 +1,208 @@
+package accounts
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencost/opencost-plugins/common/costerror"
+	"github.com/opencost/opencost-plugins/common/decimal"
+	"github.com/opencost/opencost-plugins/common/filter"
+	"github.com/opencost/opencost/core/pkg/model/pb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var windowStart = time.Date(2024, 10, 9, 0, 0, 0, 0, time.UTC)
+
+// fakeSource returns fixed responses and check result
+type fakeSource struct {
+	responses []*pb.CustomCostResponse
+	checkErr  error
+}
+
+func (f fakeSource) GetCustomCosts(req *pb.CustomCostRequest) []*pb.CustomCostResponse {
+	return f.responses
+}
+
+func (f fakeSource) Check(ctx context.Context) error {
+	return f.checkErr
+}
+
+func window(day int, costs ...string) *pb.CustomCostResponse {
+	start := windowStart.AddDate(0, 0, day)
+	resp := &pb.CustomCostResponse{
+		Metadata: map[string]string{"api_client_version": "v1"},
+		Domain:   "openai",
+		Currency: "USD",
+		Start:    timestamppb.New(start),
+		End:      timestamppb.New(start.AddDate(0, 0, 1)),
+		Errors:   []string{},
+	}
+	for _, id := range costs {
+		resp.Costs = append(resp.Costs, &pb.CustomCost{Id: id})
+	}
+	return resp
+}
+
+func TestSourceMergesWindows(t *testing.T) {
+	partial := window(1, "dev-2")
+	costerror.Add(partial, costerror.New(costerror.PartialData, "window not fetched"))
+
+	src := Source([]Account{
+		{Name: "prod", Source: fakeSource{responses: []*pb.CustomCostResponse{window(0, "prod-1"), window(1, "prod-2")}}},
+		{Name: "dev", Source: fakeSource{responses: []*pb.CustomCostResponse{partial, window(0, "dev-1"), nil}}},
+		{Name: "staging", Source: fakeSource{responses: []*pb.CustomCostResponse{costerror.Response(costerror.New(costerror.Auth, "invalid key"))}}},
+	})
+	responses := src.GetCustomCosts(&pb.CustomCostRequest{})
+
+	if len(responses) != 2 {
+		t.Fatalf("expected one response per window, got %d", len(responses))
+	}
+	for day, resp := range responses {
+		if !resp.Start.AsTime().Equal(windowStart.AddDate(0, 0, day)) {
+			t.Errorf("expected window %d to start on day %d, got %s", day, day, resp.Start.AsTime())
+		}
+		if resp.Metadata["api_client_version"] != "v1" || resp.Domain != "openai" {
+			t.Errorf("expected the window to keep its metadata, got %v", resp)
+		}
+	}
+
+	var accounts []string
+	for _, cost := range responses[0].Costs {
+		accounts = append(accounts, cost.Id+"="+cost.Metadata[MetadataKey])
+	}
+	if !reflect.DeepEqual(accounts, []string{"prod-1=prod", "dev-1=dev"}) {
+		t.Errorf("expected costs tagged with their account, got %v", accounts)
+	}
+
+	// the staging account's bad key is reported in every window, without hiding the other accounts
+	wantFirst := []string{"auth: account staging: invalid key"}
+	if !reflect.DeepEqual(responses[0].Errors, wantFirst) {
+		t.Errorf("unexpected errors in the first window: %v", responses[0].Errors)
+	}
+	wantSecond := []string{"partial_data: account dev: window not fetched", "auth: account staging: invalid key"}
+	if !reflect.DeepEqual(responses[1].Errors, wantSecond) {
+		t.Errorf("unexpected errors in the second window: %v", responses[1].Errors)
+	}
+	if responses[1].Metadata[costerror.MetadataKey] != "partial_data,auth" {
+		t.Errorf("unexpected error kinds: %s", responses[1].Metadata[costerror.MetadataKey])
+	}
+}
+
+func TestSourceTotalsExcludedCosts(t *testing.T) {
+	prod, dev := window(0, "prod-1"), window(0, "dev-1")
+	excluded := filter.Excluded{}
+	excluded.Add("USD", decimal.New(5, 0))
+	excluded.Record(prod)
+	excluded.Record(dev)
+
+	src := Source([]Account{
+		{Name: "prod", Source: fakeSource{responses: []*pb.CustomCostResponse{prod}}},
+		{Name: "staging", Source: fakeSource{responses: []*pb.CustomCostResponse{window(0, "staging-1")}}},
+		{Name: "dev", Source: fakeSource{responses: []*pb.CustomCostResponse{dev}}},
+	})
+	responses := src.GetCustomCosts(&pb.CustomCostRequest{})
+
+	if len(responses) != 1 {
+		t.Fatalf("expected one response, got %d", len(responses))
+	}
+	if responses[0].Metadata[filter.ExcludedCostsKey] != "2" || responses[0].Metadata[filter.ExcludedBilledCostKey] != "USD=10" {
+		t.Errorf("expected the excluded costs of every account, got %v", responses[0].Metadata)
+	}
+}
+
+func TestSourceReportsEveryFailedAccount(t *testing.T) {
+	src := Source([]Account{
+		{Name: "prod", Source: fakeSource{responses: []*pb.CustomCostResponse{costerror.Response(costerror.New(costerror.Auth, "invalid key"))}}},
+		{Name: "dev", Source: fakeSource{responses: []*pb.CustomCostResponse{costerror.Response(costerror.New(costerror.Permission, "missing role"))}}},
+	})
+	responses := src.GetCustomCosts(&pb.CustomCostRequest{})
+
+	if len(responses) != 1 {
+		t.Fatalf("expected a single error response, got %d", len(responses))
+	}
+	want := []string{"auth: account prod: invalid key", "permission: account dev: missing role"}
+	if !reflect.DeepEqual(responses[0].Errors, want) {
+		t.Errorf("unexpected errors: %v", responses[0].Errors)
+	}
+}
+
+func TestSourceReportsFailuresOnlyInMissingWindows(t *testing.T) {
+	// the dev account served the first window, then failed before fetching the second
+	src := Source([]Account{
+		{Name: "prod", Source: fakeSource{responses: []*pb.CustomCostResponse{window(0, "prod-1"), window(1, "prod-2")}}},
+		{Name: "dev", Source: fakeSource{responses: []*pb.CustomCostResponse{window(0, "dev-1"), costerror.Response(costerror.New(costerror.RateLimited, "too many requests"))}}},
+	})
+	responses := src.GetCustomCosts(&pb.CustomCostRequest{})
+
+	if len(responses) != 2 {
+		t.Fatalf("expected one response per window, got %d", len(responses))
+	}
+	if len(responses[0].Errors) != 0 {
+		t.Errorf("expected no errors in the window dev served, got %v", responses[0].Errors)
+	}
+	want := []string{"rate_limited: account dev: too many requests"}
+	if !reflect.DeepEqual(responses[1].Errors, want) {
+		t.Errorf("unexpected errors in the window dev didn't serve: %v", responses[1].Errors)
+	}
+}
+
+func TestSourceServesUnnamedAccountAsIs(t *testing.T) {
+	single := fakeSource{responses: []*pb.CustomCostResponse{window(0, "a")}}
+	if src := Source([]Account{{Source: single}}); !reflect.DeepEqual(src, single) {
+		t.Errorf("expected the account's own source, got %T", src)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	src := Source([]Account{
+		{Name: "prod", Source: fakeSource{}},
+		{Name: "dev", Source: fakeSource{checkErr: errors.Join(errors.New("key is invalid"), errors.New("role is missing"))}},
+	})
+
+	err := src.(interface{ Check(context.Context) error }).Check(context.Background())
+	var checkErr *CheckError
+	if !errors.As(err, &checkErr) || !checkErr.Partial() {
+		t.Fatalf("expected a partial check failure, got %v", err)
+	}
+	if err.Error() != "account dev: key is invalid\naccount dev: role is missing" {
+		t.Errorf("unexpected check error: %v", err)
+	}
+
+	src = Source([]Account{{Name: "prod", Source: fakeSource{}}, {Name: "dev", Source: fakeSource{}}})
+	if err := src.(interface{ Check(context.Context) error }).Check(context.Background()); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestConfigProblems(t *testing.T) {
+	tests := []struct {
+		name        string
+		names       []string
+		credentials []Field
+		want        []string
+	}{
+		{"single account", nil, []Field{{"api_key", "abc"}}, nil},
+		{"missing credentials", nil, []Field{{"api_key", ""}, {"org_id", "org"}}, []string{"api_key is required"}},
+		{"accounts", []string{"prod", "dev"}, []Field{{"api_key", ""}}, nil},
+		{"both", []string{"prod"}, []Field{{"api_key", "abc"}}, []string{"api_key can't be set along with accounts"}},
+		{"duplicate names", []string{"prod", "dev", "prod"}, nil, []string{`accounts[2].name "prod" is already used`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ConfigProblems(tt.names, tt.credentials...)
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d problems, got %v", len(tt.want), got)
+			}
+			for i := range got {
+				if !strings.HasPrefix(got[i], tt.want[i]) {
+					t.Errorf("expected a problem starting %q, got %q", tt.want[i], got[i])
+				}
+			}
+		})
+	}
+}
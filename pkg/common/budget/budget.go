@@ -0,0 +1,37 @@
+package budget
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/opencost/opencost-plugins/common/costerror"
+)
+
+// BudgetConfig is embedded in plugin configs to bound how long a single GetCustomCosts call may take.
+type BudgetConfig struct {
+	// RequestBudget is the time allowed for a GetCustomCosts call, across every upstream request
+	// and retry it makes. Windows not fetched within the budget are returned with a timeout error.
+	// Like other durations in plugin configs, it is given as a duration string such as "10m".
+	RequestBudget time.Duration `json:"request_budget" default:"10m"`
+}
+
+// Start returns the context for a single GetCustomCosts call, which is done when the budget runs out.
+// parent carries any values upstream clients need, such as API keys. A zero budget means no limit.
+func (c BudgetConfig) Start(parent context.Context) (context.Context, context.CancelFunc) {
+	if c.RequestBudget <= 0 {
+		return context.WithCancel(parent)
+	}
+
+	return context.WithTimeoutCause(parent, c.RequestBudget, fmt.Errorf("request budget of %v exhausted", c.RequestBudget))
+}
+
+// Exhausted reports whether the call's budget has run out, or the call was cancelled.
+func Exhausted(ctx context.Context) bool {
+	return ctx.Err() != nil
+}
+
+// WindowError is the error reported for a window that was not fetched because the budget ran out.
+func WindowError(ctx context.Context) error {
+	return costerror.New(costerror.PartialData, "window not fetched: %v. results for earlier windows are complete", context.Cause(ctx))
+}
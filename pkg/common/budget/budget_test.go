@@ -0,0 +1,54 @@
+package budget
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencost/opencost-plugins/common/config"
+)
+
+func TestStartBoundsTheCall(t *testing.T) {
+	ctx, cancel := BudgetConfig{RequestBudget: 10 * time.Millisecond}.Start(context.Background())
+	defer cancel()
+
+	if Exhausted(ctx) {
+		t.Fatalf("expected budget to remain at the start of the call")
+	}
+
+	<-ctx.Done()
+	if !Exhausted(ctx) {
+		t.Errorf("expected budget to be exhausted")
+	}
+	if msg := WindowError(ctx).Error(); !strings.Contains(msg, "request budget of 10ms exhausted") {
+		t.Errorf("unexpected window error: %s", msg)
+	}
+}
+
+func TestStartKeepsParentValues(t *testing.T) {
+	type key struct{}
+	parent := context.WithValue(context.Background(), key{}, "api keys")
+
+	ctx, cancel := BudgetConfig{}.Start(parent)
+	defer cancel()
+
+	if ctx.Value(key{}) != "api keys" {
+		t.Errorf("expected the call context to carry the parent's values")
+	}
+	if _, hasDeadline := ctx.Deadline(); hasDeadline {
+		t.Errorf("expected a zero budget to set no deadline")
+	}
+}
+
+func TestDecodeRequestBudget(t *testing.T) {
+	var cfg BudgetConfig
+	if err := config.Decode([]byte(`{"request_budget": "90s"}`), &cfg); err != nil || cfg.RequestBudget != 90*time.Second {
+		t.Errorf("expected a budget of 90s, got %v, %v", cfg.RequestBudget, err)
+	}
+
+	cfg = BudgetConfig{}
+	if err := config.Decode([]byte(`{}`), &cfg); err != nil || cfg.RequestBudget != 10*time.Minute {
+		t.Errorf("expected the budget to default to 10m, got %v, %v", cfg.RequestBudget, err)
+	}
+}
@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/opencost/opencost/core/pkg/log"
+	"github.com/opencost/opencost/core/pkg/model/pb"
+	"github.com/opencost/opencost/core/pkg/opencost"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// formatVersion is part of every cache path, so changing how responses are stored
+// only orphans old entries rather than misreading them
+const formatVersion = "v1"
+
+// CacheConfig is embedded in the config of plugins that cache finalized windows. Shared config types
+// are named after their package, so that a plugin config can embed several of them.
+type CacheConfig struct {
+	// CacheDir is the directory finalized windows are cached under. Caching is disabled when unset.
+	CacheDir string `json:"cache_dir"`
+}
+
+// WindowCache stores the responses for windows that can no longer change on disk, so that
+// repeated requests for historical windows are served without calling the upstream API.
+// Entries are keyed by plugin, account and window. A nil *WindowCache is valid, and caches nothing.
+type WindowCache struct {
+	dir       string
+	settlesAt func(end time.Time) time.Time
+	now       func() time.Time
+}
+
+// New returns a cache for the given plugin under the configured directory, or nil if caching is disabled.
+// horizon is how long after a window ends the upstream may still revise it. Only windows that ended
+// longer ago than the horizon are cached.
+func New(config CacheConfig, plugin string, horizon time.Duration) (*WindowCache, error) {
+	return NewSettling(config, plugin, func(end time.Time) time.Time {
+		return end.Add(horizon)
+	})
+}
+
+// NewSettling returns a cache like New, for upstreams whose windows don't settle a fixed time after they
+// end, such as costs that are revised until the month is billed. settlesAt returns when the costs of a
+// window ending at end can no longer change. Only windows that have settled are cached.
+func NewSettling(config CacheConfig, plugin string, settlesAt func(end time.Time) time.Time) (*WindowCache, error) {
+	if config.CacheDir == "" {
+		return nil, nil
+	}
+
+	dir := filepath.Join(config.CacheDir, plugin, formatVersion)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating cache directory %s: %v", dir, err)
+	}
+
+	return &WindowCache{
+		dir:       dir,
+		settlesAt: settlesAt,
+		now:       time.Now,
+	}, nil
+}
+
+// IsFinal reports whether a window has settled, so that its costs can no longer change.
+func (c *WindowCache) IsFinal(win opencost.Window) bool {
+	if c == nil || win.Start() == nil || win.End() == nil {
+		return false
+	}
+	return !c.now().Before(c.settlesAt(*win.End()))
+}
+
+// Get returns the cached response for an account's window, if there is one.
+func (c *WindowCache) Get(account string, win opencost.Window) (*pb.CustomCostResponse, bool) {
+	if c == nil || !c.IsFinal(win) {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(c.path(account, win))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("error reading cached window %v: %v", win, err)
+		}
+		return nil, false
+	}
+
+	var resp pb.CustomCostResponse
+	if err := protojson.Unmarshal(data, &resp); err != nil {
+		log.Warnf("ignoring unreadable cached window %v: %v", win, err)
+		return nil, false
+	}
+
+	log.Debugf("serving window %v from cache", win)
+	return &resp, true
+}
+
+// Put caches the response for an account's window. Responses for windows that are not yet final,
+// and responses with errors, are not cached.
+func (c *WindowCache) Put(account string, win opencost.Window, resp *pb.CustomCostResponse) {
+	if c == nil || resp == nil || len(resp.Errors) > 0 || !c.IsFinal(win) {
+		return
+	}
+
+	data, err := protojson.Marshal(resp)
+	if err != nil {
+		log.Warnf("error encoding window %v for the cache: %v", win, err)
+		return
+	}
+
+	path := c.path(account, win)
+	if err := writeFileAtomic(path, data); err != nil {
+		log.Warnf("error caching window %v: %v", win, err)
+	}
+}
+
+// Fetch returns the cached response for an account's window, or calls fetch and caches its response.
+func (c *WindowCache) Fetch(account string, win opencost.Window, fetch func() *pb.CustomCostResponse) *pb.CustomCostResponse {
+	if resp, found := c.Get(account, win); found {
+		return resp
+	}
+
+	resp := fetch()
+	c.Put(account, win, resp)
+	return resp
+}
+
+// path returns the file for an account's window. Accounts are hashed, as plugins without an
+// account ID key the cache by credentials, which must never be written to disk.
+func (c *WindowCache) path(account string, win opencost.Window) string {
+	accountHash := sha256.Sum256([]byte(account))
+	name := fmt.Sprintf("%d-%d.json", win.Start().Unix(), win.End().Unix())
+	return filepath.Join(c.dir, hex.EncodeToString(accountHash[:8]), name)
+}
+
+// writeFileAtomic writes through a temporary file, so concurrent readers never see a partial entry
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencost/opencost/core/pkg/model/pb"
+	"github.com/opencost/opencost/core/pkg/opencost"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var testNow = time.Date(2024, 10, 20, 12, 0, 0, 0, time.UTC)
+
+func newTestCache(t *testing.T, dir string) *WindowCache {
+	c, err := New(CacheConfig{CacheDir: dir}, "datadog", 72*time.Hour)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+	c.now = func() time.Time { return testNow }
+	return c
+}
+
+func testResponse(win opencost.Window, cost float32) *pb.CustomCostResponse {
+	return &pb.CustomCostResponse{
+		Domain: "datadog",
+		Start:  timestamppb.New(*win.Start()),
+		End:    timestamppb.New(*win.End()),
+		Costs:  []*pb.CustomCost{{ResourceName: "logs", BilledCost: cost}},
+	}
+}
+
+func TestFetchServesFinalWindowsFromCache(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestCache(t, dir)
+	win := opencost.NewClosedWindow(time.Date(2024, 10, 16, 0, 0, 0, 0, time.UTC), time.Date(2024, 10, 17, 0, 0, 0, 0, time.UTC))
+
+	calls := 0
+	fetch := func() *pb.CustomCostResponse {
+		calls++
+		return testResponse(win, 1.5)
+	}
+
+	c.Fetch("org", win, fetch)
+	// a new cache over the same directory, as after a plugin restart
+	resp := newTestCache(t, dir).Fetch("org", win, fetch)
+	if calls != 1 {
+		t.Errorf("expected a single upstream fetch, got %d", calls)
+	}
+	if len(resp.Costs) != 1 || resp.Costs[0].BilledCost != 1.5 {
+		t.Errorf("unexpected cached response: %v", resp)
+	}
+
+	// other accounts are cached separately
+	c.Fetch("other org", win, fetch)
+	if calls != 2 {
+		t.Errorf("expected a fetch for another account, got %d fetches", calls)
+	}
+}
+
+func TestDoesNotCacheRecentWindows(t *testing.T) {
+	c := newTestCache(t, t.TempDir())
+	// ends 60 hours before now, inside the 72 hour horizon
+	win := opencost.NewClosedWindow(time.Date(2024, 10, 17, 0, 0, 0, 0, time.UTC), time.Date(2024, 10, 18, 0, 0, 0, 0, time.UTC))
+
+	calls := 0
+	fetch := func() *pb.CustomCostResponse {
+		calls++
+		return testResponse(win, 1)
+	}
+	c.Fetch("org", win, fetch)
+	c.Fetch("org", win, fetch)
+	if calls != 2 {
+		t.Errorf("expected every fetch of a recent window to reach upstream, got %d fetches", calls)
+	}
+}
+
+func TestCachesSettledWindows(t *testing.T) {
+	c, err := NewSettling(CacheConfig{CacheDir: t.TempDir()}, "datadog", func(end time.Time) time.Time {
+		// settles once the month containing the window is billed
+		return time.Date(end.Year(), end.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	})
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+	c.now = func() time.Time { return testNow }
+
+	september := opencost.NewClosedWindow(time.Date(2024, 9, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, 9, 11, 0, 0, 0, 0, time.UTC))
+	october := opencost.NewClosedWindow(time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 10, 2, 0, 0, 0, 0, time.UTC))
+	if !c.IsFinal(september) || c.IsFinal(october) {
+		t.Errorf("expected only the window of the billed month to be final")
+	}
+}
+
+func TestDoesNotCacheErrors(t *testing.T) {
+	c := newTestCache(t, t.TempDir())
+	win := opencost.NewClosedWindow(time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 10, 2, 0, 0, 0, 0, time.UTC))
+
+	resp := testResponse(win, 1)
+	resp.Errors = []string{"rate limited"}
+	c.Put("org", win, resp)
+
+	if _, found := c.Get("org", win); found {
+		t.Errorf("expected a response with errors not to be cached")
+	}
+}
+
+func TestDoesNotWriteAccountsToDisk(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestCache(t, dir)
+	win := opencost.NewClosedWindow(time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 10, 2, 0, 0, 0, 0, time.UTC))
+	c.Put("secret-api-key", win, testResponse(win, 1))
+
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if strings.Contains(path, "secret-api-key") {
+			t.Errorf("account written into cache path %s", path)
+		}
+		return nil
+	})
+}
+
+func TestDisabledCache(t *testing.T) {
+	c, err := New(CacheConfig{}, "datadog", time.Hour)
+	if err != nil || c != nil {
+		t.Fatalf("expected no cache and no error when disabled, got %v, %v", c, err)
+	}
+
+	win := opencost.NewClosedWindow(time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 10, 2, 0, 0, 0, 0, time.UTC))
+	calls := 0
+	for i := 0; i < 2; i++ {
+		c.Fetch("org", win, func() *pb.CustomCostResponse {
+			calls++
+			return testResponse(win, 1)
+		})
+	}
+	if calls != 2 {
+		t.Errorf("expected a disabled cache to always fetch, got %d fetches", calls)
+	}
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// a config value of "env:NAME" is replaced with the value of the environment variable NAME
+const envRefPrefix = "env:"
+
+// a config value of "file:/path" is replaced with the contents of the file at /path
+const fileRefPrefix = "file:"
+
+// a config key of "<key>_file" is replaced with "<key>", holding the contents of the file it points to.
+// this matches the convention used for secrets mounted by kubernetes and vault agent
+const fileKeySuffix = "_file"
+
+// ReadConfigFile reads the plugin config file at configFilePath and resolves any secret references in it.
+func ReadConfigFile(configFilePath string) ([]byte, error) {
+	configBytes, err := os.ReadFile(configFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file @ %s: %v", configFilePath, err)
+	}
+
+	return ResolveSecrets(configBytes)
+}
+
+// ResolveSecrets replaces secret references in a JSON plugin config with the values they point to,
+// so that plugins can decode their config structs without knowing where the secrets came from.
+// References can appear at any depth of the document:
+//   - "datadog_api_key": "env:DD_API_KEY" reads the DD_API_KEY environment variable
+//   - "datadog_api_key": "file:/var/run/secrets/dd" reads the file at /var/run/secrets/dd
+//   - "datadog_api_key_file": "/var/run/secrets/dd" reads the file into datadog_api_key
+//
+// File contents have surrounding whitespace trimmed. Every unresolvable reference is reported in the
+// returned error. Secret values are never included in errors.
+func ResolveSecrets(configBytes []byte) ([]byte, error) {
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(configBytes))
+	// keep numbers as written, so large integers such as durations survive the round trip
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("error parsing config json: %v", err)
+	}
+
+	resolved, err := resolveValue("", doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(resolved)
+}
+
+func resolveValue(path string, value interface{}) (interface{}, error) {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		return resolveObject(path, typed)
+	case []interface{}:
+		var errs []error
+		for i := range typed {
+			resolved, err := resolveValue(fmt.Sprintf("%s[%d]", path, i), typed[i])
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			typed[i] = resolved
+		}
+		return typed, errors.Join(errs...)
+	case string:
+		return resolveString(path, typed)
+	default:
+		return value, nil
+	}
+}
+
+func resolveObject(path string, obj map[string]interface{}) (interface{}, error) {
+	// snapshot the keys, as file references add and remove keys as they are resolved
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		value := obj[key]
+		keyPath := joinPath(path, key)
+
+		fileRef, isString := value.(string)
+		if strings.HasSuffix(key, fileKeySuffix) && isString {
+			target := strings.TrimSuffix(key, fileKeySuffix)
+			if existing, found := obj[target]; found && existing != "" {
+				errs = append(errs, fmt.Errorf("%s: both %s and %s are set, only one may be used", keyPath, target, key))
+				continue
+			}
+
+			contents, err := readSecretFile(fileRef)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", keyPath, err))
+				continue
+			}
+
+			delete(obj, key)
+			obj[target] = contents
+			continue
+		}
+
+		resolved, err := resolveValue(keyPath, value)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		obj[key] = resolved
+	}
+
+	return obj, errors.Join(errs...)
+}
+
+func resolveString(path, value string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(value, envRefPrefix):
+		name := strings.TrimPrefix(value, envRefPrefix)
+		envValue, found := os.LookupEnv(name)
+		if !found {
+			return nil, fmt.Errorf("%s: environment variable %s is not set", path, name)
+		}
+		return envValue, nil
+	case strings.HasPrefix(value, fileRefPrefix):
+		contents, err := readSecretFile(strings.TrimPrefix(value, fileRefPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return contents, nil
+	default:
+		return value, nil
+	}
+}
+
+func readSecretFile(filePath string) (string, error) {
+	if filePath == "" {
+		return "", fmt.Errorf("secret file path is empty")
+	}
+
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", fmt.Errorf("error reading secret file: %v", err)
+	}
+
+	return strings.TrimSpace(string(contents)), nil
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	secretDir := t.TempDir()
+	secretFile := filepath.Join(secretDir, "openai")
+	if err := os.WriteFile(secretFile, []byte("sk-from-file\n"), 0600); err != nil {
+		t.Fatalf("error writing secret file: %v", err)
+	}
+	t.Setenv("TEST_DD_API_KEY", "dd-from-env")
+
+	config := `{
+		"datadog_api_key": "env:TEST_DD_API_KEY",
+		"datadog_app_key": "file:` + secretFile + `",
+		"openai_api_key_file": "` + secretFile + `",
+		"prometheus_timeout": 30000000000,
+		"accounts": [{"name": "prod", "api_key": "env:TEST_DD_API_KEY"}],
+		"log_level": "debug"
+	}`
+
+	resolvedBytes, err := ResolveSecrets([]byte(config))
+	if err != nil {
+		t.Fatalf("unexpected error resolving secrets: %v", err)
+	}
+
+	var resolved struct {
+		DDAPIKey          string `json:"datadog_api_key"`
+		DDAppKey          string `json:"datadog_app_key"`
+		OpenAIKey         string `json:"openai_api_key"`
+		OpenAIKeyFile     string `json:"openai_api_key_file"`
+		PrometheusTimeout int64  `json:"prometheus_timeout"`
+		Accounts          []struct {
+			APIKey string `json:"api_key"`
+		} `json:"accounts"`
+		LogLevel string `json:"log_level"`
+	}
+	if err := json.Unmarshal(resolvedBytes, &resolved); err != nil {
+		t.Fatalf("error decoding resolved config: %v", err)
+	}
+
+	if resolved.DDAPIKey != "dd-from-env" {
+		t.Errorf("expected env reference to resolve, got %q", resolved.DDAPIKey)
+	}
+	if resolved.DDAppKey != "sk-from-file" {
+		t.Errorf("expected file reference to resolve, got %q", resolved.DDAppKey)
+	}
+	if resolved.OpenAIKey != "sk-from-file" {
+		t.Errorf("expected _file key to resolve, got %q", resolved.OpenAIKey)
+	}
+	if resolved.OpenAIKeyFile != "" {
+		t.Errorf("expected _file key to be removed, got %q", resolved.OpenAIKeyFile)
+	}
+	if resolved.PrometheusTimeout != 30000000000 {
+		t.Errorf("expected numbers to be preserved, got %d", resolved.PrometheusTimeout)
+	}
+	if len(resolved.Accounts) != 1 || resolved.Accounts[0].APIKey != "dd-from-env" {
+		t.Errorf("expected nested references to resolve, got %v", resolved.Accounts)
+	}
+	if resolved.LogLevel != "debug" {
+		t.Errorf("expected plain values to be left alone, got %q", resolved.LogLevel)
+	}
+}
+
+func TestResolveSecretsReportsEveryProblem(t *testing.T) {
+	t.Setenv("TEST_SECRET", "super-secret-value")
+	config := `{
+		"datadog_api_key": "env:TEST_UNSET_VARIABLE",
+		"datadog_app_key_file": "/does/not/exist",
+		"openai_api_key": "env:TEST_SECRET",
+		"openai_api_key_file": "/does/not/exist/either"
+	}`
+
+	_, err := ResolveSecrets([]byte(config))
+	if err == nil {
+		t.Fatalf("expected an error, but got none")
+	}
+
+	msg := err.Error()
+	for _, expected := range []string{
+		"datadog_api_key: environment variable TEST_UNSET_VARIABLE is not set",
+		"datadog_app_key_file: error reading secret file",
+		"both openai_api_key and openai_api_key_file are set",
+	} {
+		if !strings.Contains(msg, expected) {
+			t.Errorf("expected error to contain %q, got: %s", expected, msg)
+		}
+	}
+
+	if strings.Contains(msg, "super-secret-value") {
+		t.Errorf("secret value leaked into error: %s", msg)
+	}
+}
+
+func TestResolveSecretsInvalidJSON(t *testing.T) {
+	_, err := ResolveSecrets([]byte(`{"log_level": "debug"`))
+	if err == nil {
+		t.Errorf("expected an error, but got none")
+	}
+}
@@ -0,0 +1,361 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// ValidationError lists every problem found in a plugin config.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("config has %d problem(s):\n\t- %s", len(e.Problems), strings.Join(e.Problems, "\n\t- "))
+}
+
+// Validator is implemented by configs with rules that struct tags can't express, such as keys that are
+// required unless another key is set. Decode adds the problems Validate returns to those it found itself.
+type Validator interface {
+	Validate() []string
+}
+
+// Decode strictly decodes a JSON plugin config into target, which must be a pointer to a struct.
+// Alongside their json tag, config fields declare how they are validated with struct tags:
+//
+//	required:"true"   the field must be set to a non-zero value
+//	default:"info"    the value used when the field is unset
+//	oneof:"a b c"     the field must be one of the space separated values
+//	min:"1" max:"31"  inclusive bounds for numeric fields
+//
+// time.Duration fields, and their defaults, take a duration string such as "30s". A number is read as
+// nanoseconds, as encoding/json does. Nested structs, pointers to structs and slices of structs are
+// validated the same way.
+// Unknown fields are rejected. Configs implementing Validator are validated further once decoded.
+// Every problem in the document is reported in the returned *ValidationError.
+func Decode(configBytes []byte, target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config target must be a pointer to a struct, got %T", target)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(configBytes, &raw); err != nil {
+		return fmt.Errorf("error parsing config json: %v", err)
+	}
+
+	problems := decodeStruct("", raw, v.Elem())
+	if validator, ok := target.(Validator); ok {
+		problems = append(problems, validator.Validate()...)
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+// configField is a struct field that is populated from a config key
+type configField struct {
+	name  string
+	value reflect.Value
+	field reflect.StructField
+}
+
+// configFields lists the config keys of a struct, flattening embedded structs the way encoding/json does
+func configFields(v reflect.Value) []configField {
+	var fields []configField
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			fields = append(fields, configFields(v.Field(i))...)
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, configField{name: name, value: v.Field(i), field: field})
+	}
+	return fields
+}
+
+func decodeStruct(path string, raw map[string]json.RawMessage, v reflect.Value) []string {
+	var problems []string
+
+	fields := configFields(v)
+	known := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		known[f.name] = true
+	}
+
+	// report unknown keys first, in a stable order
+	var unknown []string
+	for key := range raw {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		msg := fmt.Sprintf("%s is not a known config field", joinPath(path, key))
+		if suggestion := closestName(key, known); suggestion != "" {
+			msg += fmt.Sprintf(", did you mean %s?", joinPath(path, suggestion))
+		}
+		problems = append(problems, msg)
+	}
+
+	for _, f := range fields {
+		fieldPath := joinPath(path, f.name)
+		fieldRaw, isSet := raw[f.name]
+		if isSet && !isJSONNull(fieldRaw) {
+			fieldProblems := decodeValue(fieldPath, fieldRaw, f.value)
+			if len(fieldProblems) > 0 {
+				problems = append(problems, fieldProblems...)
+				continue
+			}
+		}
+
+		problems = append(problems, checkField(fieldPath, f)...)
+	}
+
+	return problems
+}
+
+func decodeValue(path string, raw json.RawMessage, v reflect.Value) []string {
+	// durations are given as strings, or else as nanoseconds like any other number
+	var s string
+	if v.Type() == durationType && json.Unmarshal(raw, &s) == nil {
+		if err := setFromString(v, s); err != nil {
+			return []string{fmt.Sprintf("%s must be %s, got %s", path, describeType(v.Type()), bytes.TrimSpace(raw))}
+		}
+		return nil
+	}
+
+	switch {
+	case v.Kind() == reflect.Struct:
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return []string{fmt.Sprintf("%s must be an object", path)}
+		}
+		return decodeStruct(path, obj, v)
+	case v.Kind() == reflect.Ptr && v.Type().Elem().Kind() == reflect.Struct:
+		elem := reflect.New(v.Type().Elem())
+		problems := decodeValue(path, raw, elem.Elem())
+		v.Set(elem)
+		return problems
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct:
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return []string{fmt.Sprintf("%s must be a list", path)}
+		}
+		var problems []string
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			problems = append(problems, decodeValue(fmt.Sprintf("%s[%d]", path, i), item, slice.Index(i))...)
+		}
+		v.Set(slice)
+		return problems
+	default:
+		if err := json.Unmarshal(raw, v.Addr().Interface()); err != nil {
+			return []string{fmt.Sprintf("%s must be %s, got %s", path, describeType(v.Type()), bytes.TrimSpace(raw))}
+		}
+		return nil
+	}
+}
+
+// checkField applies the default, required, oneof, min and max tags of a decoded field
+func checkField(path string, f configField) []string {
+	var problems []string
+	tag := f.field.Tag
+
+	if def, ok := tag.Lookup("default"); ok && f.value.IsZero() {
+		if err := setFromString(f.value, def); err != nil {
+			// a bad default is a programming error, but still surface it rather than panicking at startup
+			problems = append(problems, fmt.Sprintf("%s has an invalid default %q: %v", path, def, err))
+		}
+	}
+
+	if tag.Get("required") == "true" && f.value.IsZero() {
+		problems = append(problems, fmt.Sprintf("%s is required", path))
+		return problems
+	}
+
+	if f.value.IsZero() {
+		return problems
+	}
+
+	if oneof, ok := tag.Lookup("oneof"); ok {
+		allowed := strings.Fields(oneof)
+		actual := fmt.Sprint(f.value.Interface())
+		found := false
+		for _, a := range allowed {
+			if a == actual {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s must be one of [%s], got %q", path, strings.Join(allowed, ", "), actual))
+		}
+	}
+
+	if bound, ok := tag.Lookup("min"); ok {
+		if n, isNumber := numericValue(f.value); isNumber {
+			if limit, err := strconv.ParseFloat(bound, 64); err == nil && n < limit {
+				problems = append(problems, fmt.Sprintf("%s must be at least %s, got %v", path, bound, f.value.Interface()))
+			}
+		}
+	}
+
+	if bound, ok := tag.Lookup("max"); ok {
+		if n, isNumber := numericValue(f.value); isNumber {
+			if limit, err := strconv.ParseFloat(bound, 64); err == nil && n > limit {
+				problems = append(problems, fmt.Sprintf("%s must be at most %s, got %v", path, bound, f.value.Interface()))
+			}
+		}
+	}
+
+	return problems
+}
+
+func setFromString(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("defaults are not supported for %s fields", v.Type())
+	}
+	return nil
+}
+
+func numericValue(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+func describeType(t reflect.Type) string {
+	if t == durationType {
+		return `a duration such as "30s"`
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a whole number"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	default:
+		return t.String()
+	}
+}
+
+func isJSONNull(raw json.RawMessage) bool {
+	return string(bytes.TrimSpace(raw)) == "null"
+}
+
+// closestName suggests the known key nearest to an unknown one, if it is close enough to be a likely typo
+func closestName(unknown string, known map[string]bool) string {
+	best := ""
+	bestDist := -1
+	for name := range known {
+		dist := editDistance(strings.ToLower(unknown), strings.ToLower(name))
+		if bestDist == -1 || dist < bestDist || (dist == bestDist && name < best) {
+			best = name
+			bestDist = dist
+		}
+	}
+
+	// only suggest names within a third of the typed key's length
+	if bestDist == -1 || bestDist > len(unknown)/3+1 {
+		return ""
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
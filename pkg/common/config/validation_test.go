@@ -0,0 +1,155 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testAccount struct {
+	Name   string `json:"name" required:"true"`
+	APIKey string `json:"api_key" required:"true"`
+}
+
+type testConfig struct {
+	Site     string        `json:"site" required:"true" oneof:"datadoghq.com datadoghq.eu"`
+	APIKey   string        `json:"api_key" required:"true"`
+	LogLevel string        `json:"log_level" default:"info" oneof:"trace debug info warn error"`
+	Timeout  time.Duration `json:"timeout" default:"30s"`
+	Day      int           `json:"day" default:"1" min:"1" max:"31"`
+	Accounts []testAccount `json:"accounts"`
+}
+
+func TestDecodeAppliesDefaults(t *testing.T) {
+	var cfg testConfig
+	err := Decode([]byte(`{"site": "datadoghq.eu", "api_key": "abc"}`), &cfg)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	if cfg.LogLevel != "info" {
+		t.Errorf("expected log level to default to info, got %s", cfg.LogLevel)
+	}
+	if cfg.Timeout != 30*time.Second {
+		t.Errorf("expected timeout to default to 30s, got %v", cfg.Timeout)
+	}
+	if cfg.Day != 1 {
+		t.Errorf("expected day to default to 1, got %d", cfg.Day)
+	}
+}
+
+func TestDecodeKeepsSetValues(t *testing.T) {
+	var cfg testConfig
+	err := Decode([]byte(`{
+		"site": "datadoghq.com",
+		"api_key": "abc",
+		"log_level": "debug",
+		"timeout": 5000000000,
+		"day": 15,
+		"accounts": [{"name": "prod", "api_key": "def"}]
+	}`), &cfg)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+
+	if cfg.LogLevel != "debug" || cfg.Timeout != 5*time.Second || cfg.Day != 15 {
+		t.Errorf("set values were overwritten: %+v", cfg)
+	}
+	if len(cfg.Accounts) != 1 || cfg.Accounts[0].Name != "prod" {
+		t.Errorf("expected nested accounts to decode, got %+v", cfg.Accounts)
+	}
+}
+
+func TestDecodeDurations(t *testing.T) {
+	var cfg testConfig
+	err := Decode([]byte(`{"site": "datadoghq.com", "api_key": "abc", "timeout": "2m30s"}`), &cfg)
+	if err != nil || cfg.Timeout != 150*time.Second {
+		t.Errorf("expected a timeout of 2m30s, got %v, %v", cfg.Timeout, err)
+	}
+
+	err = Decode([]byte(`{"site": "datadoghq.com", "api_key": "abc", "timeout": "soon"}`), &cfg)
+	if err == nil || !strings.Contains(err.Error(), `timeout must be a duration such as "30s", got "soon"`) {
+		t.Errorf("expected an invalid duration to be reported, got %v", err)
+	}
+}
+
+func TestDecodeReportsEveryProblem(t *testing.T) {
+	var cfg testConfig
+	err := Decode([]byte(`{
+		"site": "datadoghq.cn",
+		"api_kye": "abc",
+		"log_level": "verbose",
+		"day": "fifteen",
+		"accounts": [{"name": "prod"}, {"name": "dev", "api_key": "def", "extra": true}]
+	}`), &cfg)
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a validation error, got: %v", err)
+	}
+
+	expected := []string{
+		`api_kye is not a known config field, did you mean api_key?`,
+		`site must be one of [datadoghq.com, datadoghq.eu], got "datadoghq.cn"`,
+		`api_key is required`,
+		`log_level must be one of [trace, debug, info, warn, error], got "verbose"`,
+		`day must be a whole number, got "fifteen"`,
+		`accounts[0].api_key is required`,
+		`accounts[1].extra is not a known config field`,
+	}
+	if len(validationErr.Problems) != len(expected) {
+		t.Errorf("expected %d problems, got %d: %v", len(expected), len(validationErr.Problems), validationErr.Problems)
+	}
+	for _, msg := range expected {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("expected error to contain %q, got: %s", msg, err)
+		}
+	}
+}
+
+func TestDecodeBounds(t *testing.T) {
+	var cfg testConfig
+	err := Decode([]byte(`{"site": "datadoghq.com", "api_key": "abc", "day": 32}`), &cfg)
+	if err == nil || !strings.Contains(err.Error(), "day must be at most 31, got 32") {
+		t.Errorf("expected an out of bounds error, got: %v", err)
+	}
+}
+
+// windowConfig has a rule across keys, which only Validate can check
+type windowConfig struct {
+	Start int `json:"start"`
+	End   int `json:"end" required:"true"`
+}
+
+func (c *windowConfig) Validate() []string {
+	if c.End <= c.Start {
+		return []string{"end must be after start"}
+	}
+	return nil
+}
+
+func TestDecodeRunsValidator(t *testing.T) {
+	var cfg windowConfig
+	err := Decode([]byte(`{"start": 5, "end": 3, "extra": 1}`), &cfg)
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || len(validationErr.Problems) != 2 {
+		t.Fatalf("expected the tag and Validate problems together, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "end must be after start") {
+		t.Errorf("expected the Validate problem, got: %v", err)
+	}
+
+	if err := Decode([]byte(`{"start": 1, "end": 3}`), &cfg); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+}
+
+func TestDecodeInvalidJSON(t *testing.T) {
+	var cfg testConfig
+	err := Decode([]byte(`{"site": "datadoghq.com"`), &cfg)
+	if err == nil {
+		t.Errorf("expected an error, but got none")
+	}
+}
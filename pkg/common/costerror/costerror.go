@@ -0,0 +1,148 @@
+package costerror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/opencost/opencost/core/pkg/model/pb"
+)
+
+// Kind classifies an error reported in a CustomCostResponse, so that OpenCost and alerting can
+// tell failures apart without parsing messages.
+type Kind string
+
+const (
+	// Auth means the upstream rejected the plugin's credentials.
+	Auth Kind = "auth"
+	// Permission means the credentials are valid, but lack access to the requested data.
+	Permission Kind = "permission"
+	// RateLimited means the upstream kept rate limiting requests after every retry.
+	RateLimited Kind = "rate_limited"
+	// UnsupportedRequest means the plugin cannot serve the request as given, such as an unsupported resolution.
+	UnsupportedRequest Kind = "unsupported_request"
+	// UpstreamUnavailable means the upstream could not be reached or failed to answer.
+	UpstreamUnavailable Kind = "upstream_unavailable"
+	// PartialData means the response is incomplete, such as a window not fetched before the request budget ran out.
+	PartialData Kind = "partial_data"
+	// ParseError means the upstream answered, but its response could not be understood.
+	ParseError Kind = "parse_error"
+)
+
+// MetadataKey is the response Metadata key listing the kind of each error in Errors, comma separated and in the same order.
+const MetadataKey = "error_kinds"
+
+// Error is an error with a kind.
+type Error struct {
+	Kind Kind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New returns an error of the given kind with a formatted message.
+func New(kind Kind, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+// Wrap gives err a kind, keeping its message. It returns nil if err is nil.
+func Wrap(kind Kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// FromStatus returns an error of the kind matching an unexpected HTTP status from an upstream API.
+func FromStatus(code int, format string, args ...interface{}) error {
+	return New(KindOfStatus(code), format, args...)
+}
+
+// KindOfStatus returns the kind of error an unexpected HTTP status from an upstream API represents.
+func KindOfStatus(code int) Kind {
+	switch {
+	case code == http.StatusUnauthorized:
+		return Auth
+	case code == http.StatusForbidden:
+		return Permission
+	case code == http.StatusTooManyRequests:
+		return RateLimited
+	case code == http.StatusBadRequest, code == http.StatusNotFound, code == http.StatusUnprocessableEntity:
+		return UnsupportedRequest
+	default:
+		return UpstreamUnavailable
+	}
+}
+
+// KindOf returns the kind of err. Errors without a kind come from failed upstream calls in nearly every
+// plugin, so they are upstream_unavailable, unless they are a cancelled or expired context.
+func KindOf(err error) Kind {
+	var kindErr *Error
+	if errors.As(err, &kindErr) {
+		return kindErr.Kind
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return PartialData
+	}
+	return UpstreamUnavailable
+}
+
+// Add reports err in resp. The message is prefixed with its kind in Errors, and the kind is
+// listed in Metadata, so consumers can read kinds from either.
+func Add(resp *pb.CustomCostResponse, err error) {
+	kind := KindOf(err)
+	resp.Errors = append(resp.Errors, fmt.Sprintf("%s: %v", kind, err))
+
+	if resp.Metadata == nil {
+		resp.Metadata = map[string]string{}
+	}
+	kinds := resp.Metadata[MetadataKey]
+	if kinds != "" {
+		kinds += ","
+	}
+	resp.Metadata[MetadataKey] = kinds + string(kind)
+}
+
+// Response returns a response that only reports err, for failures that are not tied to a window.
+func Response(err error) *pb.CustomCostResponse {
+	resp := &pb.CustomCostResponse{}
+	Add(resp, err)
+	return resp
+}
+
+// Kinds returns the kinds of the errors reported in resp, in order.
+func Kinds(resp *pb.CustomCostResponse) []Kind {
+	listed := resp.GetMetadata()[MetadataKey]
+	if listed == "" {
+		return nil
+	}
+
+	var kinds []Kind
+	for _, kind := range strings.Split(listed, ",") {
+		kinds = append(kinds, Kind(kind))
+	}
+	return kinds
+}
+
+// Errors returns the errors reported in resp with their kinds, so they can be reported again in another
+// response, such as when responses from several accounts are merged.
+func Errors(resp *pb.CustomCostResponse) []error {
+	kinds := Kinds(resp)
+	var errs []error
+	for i, msg := range resp.GetErrors() {
+		kind := UpstreamUnavailable
+		if i < len(kinds) {
+			kind = kinds[i]
+		}
+		errs = append(errs, &Error{Kind: kind, Err: errors.New(strings.TrimPrefix(msg, string(kind)+": "))})
+	}
+	return errs
+}
@@ -0,0 +1,90 @@
+package costerror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/opencost/opencost/core/pkg/model/pb"
+)
+
+func TestKindOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want Kind
+	}{
+		{"typed", New(Auth, "invalid key"), Auth},
+		{"wrapped", fmt.Errorf("error getting pricing: %w", New(RateLimited, "too many requests")), RateLimited},
+		{"status", FromStatus(403, "forbidden"), Permission},
+		{"deadline", fmt.Errorf("error fetching usage: %w", context.DeadlineExceeded), PartialData},
+		{"untyped", errors.New("connection reset"), UpstreamUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := KindOf(tt.err); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAddEncodesKinds(t *testing.T) {
+	resp := &pb.CustomCostResponse{Metadata: map[string]string{"api_client_version": "v1"}}
+	Add(resp, New(UnsupportedRequest, "resolution must be 1d"))
+	Add(resp, New(PartialData, "window not fetched"))
+
+	wantErrors := []string{"unsupported_request: resolution must be 1d", "partial_data: window not fetched"}
+	if !reflect.DeepEqual(resp.Errors, wantErrors) {
+		t.Errorf("expected errors %v, got %v", wantErrors, resp.Errors)
+	}
+	if got := resp.Metadata[MetadataKey]; got != "unsupported_request,partial_data" {
+		t.Errorf("unexpected error kinds in metadata: %s", got)
+	}
+	if resp.Metadata["api_client_version"] != "v1" {
+		t.Errorf("expected existing metadata to be kept")
+	}
+	if kinds := Kinds(resp); !reflect.DeepEqual(kinds, []Kind{UnsupportedRequest, PartialData}) {
+		t.Errorf("unexpected kinds: %v", kinds)
+	}
+}
+
+func TestResponse(t *testing.T) {
+	resp := Response(New(Auth, "invalid key"))
+	if len(resp.Errors) != 1 || resp.Errors[0] != "auth: invalid key" || resp.Metadata[MetadataKey] != "auth" {
+		t.Errorf("unexpected response: %v", resp)
+	}
+	if kinds := Kinds(&pb.CustomCostResponse{}); kinds != nil {
+		t.Errorf("expected no kinds for a response without errors, got %v", kinds)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	resp := &pb.CustomCostResponse{}
+	Add(resp, New(RateLimited, "too many requests"))
+	// errors added without Add have no listed kind
+	resp.Errors = append(resp.Errors, "connection refused")
+
+	errs := Errors(resp)
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+	if KindOf(errs[0]) != RateLimited || errs[0].Error() != "too many requests" {
+		t.Errorf("unexpected first error: %s %v", KindOf(errs[0]), errs[0])
+	}
+	if KindOf(errs[1]) != UpstreamUnavailable || errs[1].Error() != "connection refused" {
+		t.Errorf("unexpected second error: %s %v", KindOf(errs[1]), errs[1])
+	}
+
+	// reporting the errors again keeps their kinds
+	again := &pb.CustomCostResponse{}
+	for _, err := range errs {
+		Add(again, err)
+	}
+	if !reflect.DeepEqual(again.Errors, []string{"rate_limited: too many requests", "upstream_unavailable: connection refused"}) {
+		t.Errorf("unexpected errors: %v", again.Errors)
+	}
+}
@@ -0,0 +1,38 @@
+package costid
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// namespace is the UUID namespace of plugin cost IDs, so they never collide with IDs generated elsewhere
+var namespace = uuid.UUID{0x6f, 0x63, 0x2d, 0x70, 0x6c, 0x75, 0x67, 0x69, 0x6e, 0x2d, 0x63, 0x6f, 0x73, 0x74, 0x69, 0x64}
+
+// New returns the ID of a cost, derived from the plugin domain, the account billed, the window the cost
+// was reported for and its provider ID. Querying the same window again yields the same ID, so OpenCost can
+// deduplicate and diff re-fetched costs. Qualifiers tell apart costs that share all of these, such as daily
+// line items for the same resource within a weekly window.
+//
+// IDs are name-based (version 5) UUIDs, so they have the same shape as the random IDs plugins used before.
+func New(domain, account string, start, end time.Time, providerID string, qualifiers ...string) string {
+	parts := append([]string{
+		domain,
+		account,
+		start.UTC().Format(time.RFC3339),
+		end.UTC().Format(time.RFC3339),
+		providerID,
+	}, qualifiers...)
+
+	// length prefixes keep fields from running into each other, e.g. "a/b" + "c" and "a" + "b/c"
+	var name strings.Builder
+	for _, part := range parts {
+		name.WriteString(strconv.Itoa(len(part)))
+		name.WriteByte(':')
+		name.WriteString(part)
+	}
+
+	return uuid.NewSHA1(namespace, []byte(name.String())).String()
+}
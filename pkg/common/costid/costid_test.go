@@ -0,0 +1,38 @@
+package costid
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+var uuidFormat = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestNew(t *testing.T) {
+	start := time.Date(2024, 10, 9, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+	id := New("openai", "acme", start, end, "org/project/gpt-4o")
+
+	if !uuidFormat.MatchString(id) {
+		t.Errorf("expected a version 5 UUID, got %s", id)
+	}
+
+	// the same window in another time zone is the same window
+	if again := New("openai", "acme", start.In(time.FixedZone("EST", -5*3600)), end, "org/project/gpt-4o"); again != id {
+		t.Errorf("expected the same ID for the same cost, got %s and %s", id, again)
+	}
+
+	different := map[string]string{
+		"domain":     New("datadog", "acme", start, end, "org/project/gpt-4o"),
+		"account":    New("openai", "acme-dev", start, end, "org/project/gpt-4o"),
+		"window":     New("openai", "acme", end, end.Add(24*time.Hour), "org/project/gpt-4o"),
+		"provider":   New("openai", "acme", start, end, "org/project/gpt-4o-mini"),
+		"qualifier":  New("openai", "acme", start, end, "org/project/gpt-4o", "2024-10-09"),
+		"boundaries": New("openai", "acmeorg/project/gpt-4", start, end, "o"),
+	}
+	for name, other := range different {
+		if other == id {
+			t.Errorf("expected a different %s to give a different ID", name)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package currency
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opencost/opencost-plugins/common/costerror"
+	"github.com/opencost/opencost-plugins/common/decimal"
+	"github.com/opencost/opencost/core/pkg/log"
+	"github.com/opencost/opencost/core/pkg/model/pb"
+	ocplugin "github.com/opencost/opencost/core/pkg/plugin"
+)
+
+// SourceCurrencyKey is the cost Metadata key plugins set when a cost is billed in a currency other than
+// its response's Currency, such as an invoice line issued in EUR.
+const SourceCurrencyKey = "source_currency"
+
+// Metadata keys recording the conversion of each converted cost
+const (
+	OriginalCurrencyKey      = "original_currency"
+	OriginalBilledCostKey    = "original_billed_cost"
+	OriginalListCostKey      = "original_list_cost"
+	OriginalListUnitPriceKey = "original_list_unit_price"
+	RateKey                  = "fx_rate"
+	RateDateKey              = "fx_rate_date"
+)
+
+// RatesKey is the response Metadata key listing every rate used to convert the response's costs
+const RatesKey = "fx_rates"
+
+const dateFormat = "2006-01-02"
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// CurrencyConfig is embedded in plugin configs to report costs in a currency other than the one they are billed in.
+type CurrencyConfig struct {
+	// ReportingCurrency is the ISO 4217 code costs are reported in. Costs are reported in the currency
+	// they are billed in when unset.
+	ReportingCurrency string `json:"reporting_currency"`
+	// FXRatesPath is the CSV rate table used for conversion, with the header "date,from,to,rate".
+	// Each row gives the rate that converts an amount in from into to, effective from date (YYYY-MM-DD)
+	// until the next row for the same currencies.
+	FXRatesPath string `json:"fx_rates_path"`
+}
+
+// rate is a single row of the rate table
+type rate struct {
+	effective time.Time
+	value     decimal.Decimal
+}
+
+// Converter converts the costs in responses into the reporting currency. A nil *Converter is valid,
+// and leaves every cost in the currency it is billed in.
+type Converter struct {
+	reporting string
+	// rates holds the rates for each pair of currencies, ordered by effective date
+	rates map[[2]string][]rate
+}
+
+// New reads the rate table for the configured reporting currency, or returns nil if conversion is disabled.
+func New(config CurrencyConfig) (*Converter, error) {
+	if config.ReportingCurrency == "" {
+		if config.FXRatesPath != "" {
+			return nil, fmt.Errorf("fx_rates_path is set, but reporting_currency is not")
+		}
+		return nil, nil
+	}
+
+	if !currencyCode.MatchString(config.ReportingCurrency) {
+		return nil, fmt.Errorf("reporting_currency must be an ISO 4217 code such as EUR, got %q", config.ReportingCurrency)
+	}
+	if config.FXRatesPath == "" {
+		return nil, fmt.Errorf("fx_rates_path is required when reporting_currency is set")
+	}
+
+	file, err := os.Open(config.FXRatesPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening fx_rates_path: %v", err)
+	}
+	defer file.Close()
+
+	rates, err := readRates(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading rate table %s: %v", config.FXRatesPath, err)
+	}
+
+	return &Converter{reporting: config.ReportingCurrency, rates: rates}, nil
+}
+
+// readRates parses a rate table, reporting the line of any invalid row
+func readRates(r io.Reader) (map[[2]string][]rate, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading header: %v", err)
+	}
+	if strings.Join(header, ",") != "date,from,to,rate" {
+		return nil, fmt.Errorf(`expected the header "date,from,to,rate", got %q`, strings.Join(header, ","))
+	}
+
+	rates := map[[2]string][]rate{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		effective, err := time.Parse(dateFormat, record[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: date must be YYYY-MM-DD, got %q", line, record[0])
+		}
+		from, to := strings.ToUpper(record[1]), strings.ToUpper(record[2])
+		if !currencyCode.MatchString(from) || !currencyCode.MatchString(to) {
+			return nil, fmt.Errorf("line %d: currencies must be ISO 4217 codes, got %q and %q", line, record[1], record[2])
+		}
+		value, err := decimal.Parse(record[3])
+		if err != nil || value.Sign() <= 0 {
+			return nil, fmt.Errorf("line %d: rate must be a positive number, got %q", line, record[3])
+		}
+
+		pair := [2]string{from, to}
+		rates[pair] = append(rates[pair], rate{effective: effective, value: value})
+	}
+
+	for _, pairRates := range rates {
+		sort.Slice(pairRates, func(i, j int) bool { return pairRates[i].effective.Before(pairRates[j].effective) })
+	}
+
+	return rates, nil
+}
+
+// rate returns the rate converting from into the reporting currency on the given day, and the date it took effect.
+// A rate for the reverse pair is inverted when the table has no rate for the pair itself.
+func (c *Converter) rate(from string, day time.Time) (decimal.Decimal, time.Time, bool) {
+	if r, ok := effectiveRate(c.rates[[2]string{from, c.reporting}], day); ok {
+		return r.value, r.effective, true
+	}
+	if r, ok := effectiveRate(c.rates[[2]string{c.reporting, from}], day); ok {
+		return decimal.NewFromInt(1).Div(r.value), r.effective, true
+	}
+	return decimal.Zero, time.Time{}, false
+}
+
+// effectiveRate returns the latest rate effective on or before day
+func effectiveRate(rates []rate, day time.Time) (rate, bool) {
+	i := sort.Search(len(rates), func(i int) bool { return rates[i].effective.After(day) })
+	if i == 0 {
+		return rate{}, false
+	}
+	return rates[i-1], true
+}
+
+// Convert converts the costs in resp into the reporting currency in place, using the rates effective on
+// the day the response's window starts. The original amounts and rate are recorded in each converted
+// cost's Metadata. Costs that cannot be converted are dropped, and reported as a partial_data error.
+func (c *Converter) Convert(resp *pb.CustomCostResponse) {
+	if c == nil || resp == nil || resp.Start == nil {
+		return
+	}
+
+	day := resp.Start.AsTime().UTC().Truncate(24 * time.Hour)
+	used := map[string]bool{}
+	dropped := map[string]int{}
+
+	converted := make([]*pb.CustomCost, 0, len(resp.Costs))
+	for _, cost := range resp.Costs {
+		source := strings.ToUpper(cost.Metadata[SourceCurrencyKey])
+		if source == "" {
+			source = strings.ToUpper(resp.Currency)
+		}
+		if source == c.reporting {
+			converted = append(converted, cost)
+			continue
+		}
+
+		value, effective, ok := c.rate(source, day)
+		if !ok {
+			dropped[source]++
+			continue
+		}
+
+		if cost.Metadata == nil {
+			cost.Metadata = map[string]string{}
+		}
+		cost.Metadata[OriginalCurrencyKey] = source
+		cost.Metadata[OriginalBilledCostKey] = formatAmount(cost.BilledCost)
+		cost.Metadata[OriginalListCostKey] = formatAmount(cost.ListCost)
+		cost.Metadata[OriginalListUnitPriceKey] = formatAmount(cost.ListUnitPrice)
+		cost.Metadata[RateKey] = value.String()
+		cost.Metadata[RateDateKey] = effective.Format(dateFormat)
+		delete(cost.Metadata, SourceCurrencyKey)
+
+		// amounts are converted at the exact rate, and rounded to float32 once
+		cost.BilledCost = convertAmount(cost.BilledCost, value)
+		cost.ListCost = convertAmount(cost.ListCost, value)
+		cost.ListUnitPrice = convertAmount(cost.ListUnitPrice, value)
+
+		used[fmt.Sprintf("%s/%s=%s@%s", source, c.reporting, cost.Metadata[RateKey], cost.Metadata[RateDateKey])] = true
+		converted = append(converted, cost)
+	}
+	resp.Costs = converted
+	resp.Currency = c.reporting
+
+	if len(used) > 0 {
+		if resp.Metadata == nil {
+			resp.Metadata = map[string]string{}
+		}
+		resp.Metadata[RatesKey] = strings.Join(sortedKeys(used), ",")
+	}
+
+	for _, source := range sortedKeys(dropped) {
+		log.Warnf("dropping %d cost(s) in %s: no rate to %s effective on %s", dropped[source], source, c.reporting, day.Format(dateFormat))
+		costerror.Add(resp, costerror.New(costerror.PartialData, "dropped %d cost(s) in %s: the rate table has no rate to %s effective on %s",
+			dropped[source], source, c.reporting, day.Format(dateFormat)))
+	}
+}
+
+// Source wraps a cost source so that every response is converted into the reporting currency.
+func (c *Converter) Source(src ocplugin.CustomCostSource) ocplugin.CustomCostSource {
+	if c == nil {
+		return src
+	}
+	return convertingSource{src: src, converter: c}
+}
+
+type convertingSource struct {
+	src       ocplugin.CustomCostSource
+	converter *Converter
+}
+
+func (s convertingSource) GetCustomCosts(req *pb.CustomCostRequest) []*pb.CustomCostResponse {
+	responses := s.src.GetCustomCosts(req)
+	for _, resp := range responses {
+		s.converter.Convert(resp)
+	}
+	return responses
+}
+
+func convertAmount(amount float32, rate decimal.Decimal) float32 {
+	return decimal.NewFromFloat32(amount).Mul(rate).Float32()
+}
+
+func formatAmount(amount float32) string {
+	return strconv.FormatFloat(float64(amount), 'f', -1, 32)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,144 @@
+package currency
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencost/opencost-plugins/common/costerror"
+	"github.com/opencost/opencost/core/pkg/model/pb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const rateTable = `date,from,to,rate
+2024-10-01,USD,EUR,0.9
+2024-10-15,USD,EUR,0.8
+2024-10-01,EUR,GBP,0.85
+`
+
+func newConverter(t *testing.T, reporting string) *Converter {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rates.csv")
+	if err := os.WriteFile(path, []byte(rateTable), 0o600); err != nil {
+		t.Fatalf("error writing rate table: %v", err)
+	}
+	converter, err := New(CurrencyConfig{ReportingCurrency: reporting, FXRatesPath: path})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return converter
+}
+
+func response(start time.Time, currency string, costs ...*pb.CustomCost) *pb.CustomCostResponse {
+	return &pb.CustomCostResponse{
+		Start:    timestamppb.New(start),
+		End:      timestamppb.New(start.Add(24 * time.Hour)),
+		Currency: currency,
+		Costs:    costs,
+	}
+}
+
+func TestConvertUsesEffectiveRate(t *testing.T) {
+	converter := newConverter(t, "EUR")
+
+	tests := []struct {
+		name     string
+		start    time.Time
+		wantCost float32
+		wantDate string
+	}{
+		{"before change", time.Date(2024, 10, 14, 23, 0, 0, 0, time.UTC), 90, "2024-10-01"},
+		{"on change", time.Date(2024, 10, 15, 0, 0, 0, 0, time.UTC), 80, "2024-10-15"},
+		{"after change", time.Date(2024, 11, 2, 0, 0, 0, 0, time.UTC), 80, "2024-10-15"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := response(tt.start, "USD", &pb.CustomCost{BilledCost: 100, ListCost: 100})
+			converter.Convert(resp)
+
+			if resp.Currency != "EUR" {
+				t.Errorf("expected currency EUR, got %s", resp.Currency)
+			}
+			cost := resp.Costs[0]
+			if cost.BilledCost != tt.wantCost || cost.ListCost != tt.wantCost {
+				t.Errorf("expected %v, got billed %v and list %v", tt.wantCost, cost.BilledCost, cost.ListCost)
+			}
+			if cost.Metadata[OriginalCurrencyKey] != "USD" || cost.Metadata[OriginalBilledCostKey] != "100" {
+				t.Errorf("expected the original amount to be recorded, got %v", cost.Metadata)
+			}
+			if cost.Metadata[RateDateKey] != tt.wantDate {
+				t.Errorf("expected rate effective %s, got %s", tt.wantDate, cost.Metadata[RateDateKey])
+			}
+			if !strings.HasPrefix(resp.Metadata[RatesKey], "USD/EUR=") {
+				t.Errorf("expected the rate to be recorded on the response, got %v", resp.Metadata)
+			}
+		})
+	}
+}
+
+func TestConvertPerCostSourceCurrency(t *testing.T) {
+	converter := newConverter(t, "EUR")
+
+	resp := response(time.Date(2024, 10, 20, 0, 0, 0, 0, time.UTC), "USD",
+		&pb.CustomCost{BilledCost: 10, Metadata: map[string]string{SourceCurrencyKey: "eur"}},
+		// only the inverse EUR/GBP rate is in the table
+		&pb.CustomCost{BilledCost: 17, Metadata: map[string]string{SourceCurrencyKey: "GBP"}},
+	)
+	converter.Convert(resp)
+
+	if len(resp.Costs) != 2 {
+		t.Fatalf("expected 2 costs, got %d", len(resp.Costs))
+	}
+	if resp.Costs[0].BilledCost != 10 || resp.Costs[0].Metadata[OriginalCurrencyKey] != "" {
+		t.Errorf("expected a cost already in EUR to be unchanged, got %v", resp.Costs[0])
+	}
+	if resp.Costs[1].BilledCost != 20 {
+		t.Errorf("expected 17 GBP to convert to 20 EUR, got %v", resp.Costs[1].BilledCost)
+	}
+}
+
+func TestConvertDropsCostsWithoutRate(t *testing.T) {
+	converter := newConverter(t, "EUR")
+
+	resp := response(time.Date(2024, 9, 30, 0, 0, 0, 0, time.UTC), "USD", &pb.CustomCost{BilledCost: 100})
+	converter.Convert(resp)
+
+	if len(resp.Costs) != 0 {
+		t.Errorf("expected the cost to be dropped, got %v", resp.Costs)
+	}
+	if kinds := costerror.Kinds(resp); len(kinds) != 1 || kinds[0] != costerror.PartialData {
+		t.Errorf("expected a partial_data error, got %v: %v", kinds, resp.Errors)
+	}
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	converter, err := New(CurrencyConfig{})
+	if err != nil || converter != nil {
+		t.Errorf("expected conversion to be disabled without a reporting currency, got %v, %v", converter, err)
+	}
+
+	// a nil converter leaves responses unchanged
+	resp := response(time.Now(), "USD", &pb.CustomCost{BilledCost: 1})
+	converter.Convert(resp)
+	if resp.Currency != "USD" {
+		t.Errorf("expected currency to be unchanged, got %s", resp.Currency)
+	}
+
+	if _, err := New(CurrencyConfig{ReportingCurrency: "EUR"}); err == nil {
+		t.Errorf("expected an error without fx_rates_path")
+	}
+	if _, err := New(CurrencyConfig{ReportingCurrency: "euro", FXRatesPath: "rates.csv"}); err == nil {
+		t.Errorf("expected an error for an invalid currency code")
+	}
+
+	path := filepath.Join(t.TempDir(), "rates.csv")
+	if err := os.WriteFile(path, []byte("date,from,to,rate\n2024-10-01,USD,EUR,-1\n"), 0o600); err != nil {
+		t.Fatalf("error writing rate table: %v", err)
+	}
+	if _, err := New(CurrencyConfig{ReportingCurrency: "EUR", FXRatesPath: path}); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error naming the invalid line, got %v", err)
+	}
+}
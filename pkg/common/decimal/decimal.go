@@ -0,0 +1,267 @@
+package decimal
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Decimal is an exact amount of money or usage. Plugins compute and aggregate costs as Decimals and
+// convert them to the protobuf float32 fields only when building a response, so summing thousands of
+// hourly amounts doesn't drift the way float32 sums do.
+//
+// Decimals are exact rationals, so even division by an amount that doesn't divide evenly, such as a
+// monthly price over 730 hours, loses nothing until the result is rounded or converted. The zero
+// value is 0, and Decimals are immutable, so they can be copied and shared freely.
+type Decimal struct {
+	r *big.Rat
+}
+
+// Zero is the Decimal 0
+var Zero = Decimal{}
+
+// maxStringPlaces is how many decimal places String shows of an amount that has no finite decimal
+// representation, such as one third
+const maxStringPlaces = 16
+
+// New returns value × 10^exp, e.g. New(12345, -2) is 123.45.
+func New(value int64, exp int32) Decimal {
+	r := new(big.Rat).SetInt64(value)
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(exp))), nil))
+	if exp < 0 {
+		return Decimal{r.Quo(r, scale)}
+	}
+	return Decimal{r.Mul(r, scale)}
+}
+
+// NewFromInt returns i as a Decimal.
+func NewFromInt(i int64) Decimal {
+	return Decimal{new(big.Rat).SetInt64(i)}
+}
+
+// NewFromFloat returns the shortest decimal that converts back to f, so 0.1 is exactly 0.1 rather
+// than the binary fraction nearest to it. NaN and infinities, which have no decimal value, are 0.
+func NewFromFloat(f float64) Decimal {
+	return fromFloat(f, 64)
+}
+
+// NewFromFloat32 is NewFromFloat for a float32, such as a protobuf cost field.
+func NewFromFloat32(f float32) Decimal {
+	return fromFloat(float64(f), 32)
+}
+
+func fromFloat(f float64, bitSize int) Decimal {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return Zero
+	}
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, bitSize))
+	if !ok {
+		return Zero
+	}
+	return Decimal{r}
+}
+
+// Parse reads a decimal string such as "123.45", "-0.002" or "1.5e-7", as upstream APIs report
+// prices and amounts. Surrounding whitespace is ignored.
+func Parse(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	// big.Rat also reads fractions such as "1/3", which no API reports as an amount
+	if strings.Contains(s, "/") {
+		return Zero, fmt.Errorf("invalid decimal %q", s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return Zero, fmt.Errorf("invalid decimal %q", s)
+	}
+	return Decimal{r}, nil
+}
+
+// Sum returns the sum of values.
+func Sum(values ...Decimal) Decimal {
+	sum := new(big.Rat)
+	for _, v := range values {
+		if v.r != nil {
+			sum.Add(sum, v.r)
+		}
+	}
+	return Decimal{sum}
+}
+
+// rat returns d as a big.Rat, which callers must not modify
+func (d Decimal) rat() *big.Rat {
+	if d.r == nil {
+		return new(big.Rat)
+	}
+	return d.r
+}
+
+// Add returns d + e.
+func (d Decimal) Add(e Decimal) Decimal {
+	return Decimal{new(big.Rat).Add(d.rat(), e.rat())}
+}
+
+// Sub returns d - e.
+func (d Decimal) Sub(e Decimal) Decimal {
+	return Decimal{new(big.Rat).Sub(d.rat(), e.rat())}
+}
+
+// Mul returns d × e.
+func (d Decimal) Mul(e Decimal) Decimal {
+	return Decimal{new(big.Rat).Mul(d.rat(), e.rat())}
+}
+
+// Div returns d / e. It panics if e is zero, so check divisors that come from upstream data.
+func (d Decimal) Div(e Decimal) Decimal {
+	if e.IsZero() {
+		panic("decimal: division by zero")
+	}
+	return Decimal{new(big.Rat).Quo(d.rat(), e.rat())}
+}
+
+// Neg returns -d.
+func (d Decimal) Neg() Decimal {
+	return Decimal{new(big.Rat).Neg(d.rat())}
+}
+
+// Cmp returns -1, 0 or +1 as d is less than, equal to or greater than e.
+func (d Decimal) Cmp(e Decimal) int {
+	return d.rat().Cmp(e.rat())
+}
+
+// Sign returns -1, 0 or +1 as d is negative, zero or positive.
+func (d Decimal) Sign() int {
+	return d.rat().Sign()
+}
+
+// IsZero reports whether d is 0.
+func (d Decimal) IsZero() bool {
+	return d.Sign() == 0
+}
+
+// Min returns the smaller of d and e.
+func (d Decimal) Min(e Decimal) Decimal {
+	if e.Cmp(d) < 0 {
+		return e
+	}
+	return d
+}
+
+// Round returns d rounded to places decimal places, with halves rounded away from zero. Round(2)
+// rounds an amount of dollars to the cent.
+func (d Decimal) Round(places int32) Decimal {
+	scale := New(1, places).rat()
+	scaled := new(big.Rat).Mul(d.rat(), scale)
+
+	// round |scaled| half up: floor((2|num| + den) / 2den)
+	num := new(big.Int).Abs(scaled.Num())
+	den := scaled.Denom()
+	rounded := new(big.Int).Add(new(big.Int).Lsh(num, 1), den)
+	rounded.Quo(rounded, new(big.Int).Lsh(den, 1))
+	if scaled.Sign() < 0 {
+		rounded.Neg(rounded)
+	}
+
+	r := new(big.Rat).SetInt(rounded)
+	return Decimal{r.Quo(r, scale)}
+}
+
+// Int64 returns the integer part of d, truncated toward zero. Round first to round to the nearest.
+func (d Decimal) Int64() int64 {
+	r := d.rat()
+	return new(big.Int).Quo(r.Num(), r.Denom()).Int64()
+}
+
+// Float64 returns the float64 nearest to d.
+func (d Decimal) Float64() float64 {
+	f, _ := d.rat().Float64()
+	return f
+}
+
+// Float32 returns the float32 nearest to d, for setting protobuf cost fields.
+func (d Decimal) Float32() float32 {
+	f, _ := d.rat().Float32()
+	return f
+}
+
+// String returns d in decimal notation, such as "123.45". Amounts without a finite decimal
+// representation are rounded to 16 decimal places.
+func (d Decimal) String() string {
+	r := d.rat()
+	if r.IsInt() {
+		return r.Num().String()
+	}
+
+	places, exact := decimalPlaces(r.Denom())
+	if !exact {
+		places = maxStringPlaces
+	}
+	s := r.FloatString(places)
+	if !exact {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+// decimalPlaces returns how many decimal places a fraction with denominator den needs, and whether
+// it has a finite decimal representation at all: only powers of 2 and 5 divide a power of 10
+func decimalPlaces(den *big.Int) (int, bool) {
+	rest := new(big.Int).Set(den)
+	twos, fives := 0, 0
+	for rest.Bit(0) == 0 {
+		rest.Rsh(rest, 1)
+		twos++
+	}
+	five := big.NewInt(5)
+	mod := new(big.Int)
+	for {
+		quo, m := new(big.Int).QuoRem(rest, five, mod)
+		if m.Sign() != 0 {
+			break
+		}
+		rest = quo
+		fives++
+	}
+	if rest.Cmp(big.NewInt(1)) != 0 {
+		return 0, false
+	}
+	if twos > fives {
+		return twos, true
+	}
+	return fives, true
+}
+
+// MarshalJSON encodes d as a JSON number.
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON reads a JSON number or a string holding one, exactly as written rather than through
+// a float. null leaves d unchanged.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+
+	parsed, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+func abs(i int32) int32 {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
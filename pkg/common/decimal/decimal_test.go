@@ -0,0 +1,120 @@
+package decimal
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func mustParse(t *testing.T, s string) Decimal {
+	t.Helper()
+	d, err := Parse(s)
+	if err != nil {
+		t.Fatalf("unexpected error parsing %q: %v", s, err)
+	}
+	return d
+}
+
+func TestHourlyAmountsSumToMonthlyTotal(t *testing.T) {
+	monthly := mustParse(t, "98765.43")
+	hourly := monthly.Div(NewFromInt(730))
+
+	var sum Decimal
+	var floatSum float32
+	for hour := 0; hour < 730; hour++ {
+		sum = sum.Add(hourly)
+		floatSum += hourly.Float32()
+	}
+
+	if sum.Cmp(monthly) != 0 {
+		t.Errorf("expected hourly amounts to sum to %s, got %s", monthly, sum)
+	}
+	// the float32 sum this replaces is off by more than a cent
+	if NewFromFloat32(floatSum).Round(2).Cmp(monthly) == 0 {
+		t.Errorf("expected the float32 sum %v to drift from %s", floatSum, monthly)
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	tests := []struct {
+		name string
+		got  Decimal
+		want string
+	}{
+		{"new", New(12345, -2), "123.45"},
+		{"new with positive exponent", New(12, 3), "12000"},
+		{"float", NewFromFloat(0.1), "0.1"},
+		{"float32", NewFromFloat32(0.1), "0.1"},
+		{"add", NewFromFloat(0.1).Add(NewFromFloat(0.2)), "0.3"},
+		{"sub", NewFromInt(1).Sub(mustParse(t, "0.01")), "0.99"},
+		{"mul", mustParse(t, "0.08").Mul(NewFromInt(24)), "1.92"},
+		{"div", NewFromInt(1).Div(NewFromInt(8)), "0.125"},
+		{"repeating", NewFromInt(1).Div(NewFromInt(3)), "0.3333333333333333"},
+		{"neg", New(5, -1).Neg(), "-0.5"},
+		{"sum", Sum(New(1, -2), New(2, -2), Zero), "0.03"},
+		{"min", NewFromInt(3).Min(NewFromInt(2)), "2"},
+		{"exponent", mustParse(t, "1.5e-7"), "0.00000015"},
+		{"round half up", mustParse(t, "2.345").Round(2), "2.35"},
+		{"round half away from zero", mustParse(t, "-2.345").Round(2), "-2.35"},
+		{"round down", mustParse(t, "2.344999").Round(2), "2.34"},
+		{"round repeating", NewFromInt(2).Div(NewFromInt(3)).Round(2), "0.67"},
+		{"zero value", Zero, "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got.String() != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, tt.got)
+			}
+		})
+	}
+}
+
+func TestConversions(t *testing.T) {
+	d := mustParse(t, "1073741824.75")
+	if d.Int64() != 1073741824 {
+		t.Errorf("expected the integer part 1073741824, got %d", d.Int64())
+	}
+	if d.Round(0).Int64() != 1073741825 {
+		t.Errorf("expected 1073741825 when rounded, got %d", d.Round(0).Int64())
+	}
+	if mustParse(t, "0.1").Float32() != float32(0.1) {
+		t.Errorf("expected the nearest float32 to 0.1, got %v", mustParse(t, "0.1").Float32())
+	}
+	if !NewFromFloat(0).IsZero() || Zero.Sign() != 0 || NewFromInt(-2).Sign() != -1 {
+		t.Errorf("unexpected signs")
+	}
+}
+
+func TestParseRejectsInvalidAmounts(t *testing.T) {
+	for _, s := range []string{"", "abc", "1/3", "NaN", "1.2.3"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("expected an error parsing %q", s)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var item struct {
+		Quantity Decimal `json:"quantity"`
+		Price    Decimal `json:"price"`
+		Missing  Decimal `json:"missing"`
+	}
+	if err := json.Unmarshal([]byte(`{"quantity": 0.1, "price": "19.99", "missing": null}`), &item); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if item.Quantity.String() != "0.1" || item.Price.String() != "19.99" || !item.Missing.IsZero() {
+		t.Errorf("unexpected values: %s, %s, %s", item.Quantity, item.Price, item.Missing)
+	}
+
+	encoded, err := json.Marshal(item)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(encoded) != `{"quantity":0.1,"price":19.99,"missing":0}` {
+		t.Errorf("unexpected encoding: %s", encoded)
+	}
+
+	if err := json.Unmarshal([]byte(`{"quantity": true}`), &item); err == nil {
+		t.Errorf("expected an error decoding a boolean")
+	}
+}
@@ -0,0 +1,90 @@
+package executor
+
+import (
+	"sync"
+
+	"github.com/opencost/opencost-plugins/common/costerror"
+	"github.com/opencost/opencost/core/pkg/model/pb"
+	"github.com/opencost/opencost/core/pkg/opencost"
+)
+
+// ExecutorConfig is embedded in plugin configs to set how many windows a GetCustomCosts call fetches at once.
+type ExecutorConfig struct {
+	// WindowConcurrency is the number of windows fetched at once. Every upstream request still waits
+	// on the plugin's rate limiter, so more workers only help while the limiter has capacity to spare.
+	WindowConcurrency int `json:"window_concurrency" default:"4" min:"1" max:"32"`
+}
+
+// FetchFunc fetches the response for a single window. A nil response leaves the window out of the
+// results, as for windows in the future. An error means no further windows can be fetched.
+//
+// FetchFunc is called from several goroutines at once. It must wait on the plugin's rate.Limiter
+// before every upstream request, which is safe for concurrent use and so bounds all workers together.
+type FetchFunc func(win opencost.Window) (*pb.CustomCostResponse, error)
+
+// Run fetches windows with a pool of workers and returns their responses in window order.
+// Windows are started in order. If fetching a window fails, no later windows are started, and the
+// results are the responses for every earlier window followed by a response reporting the error,
+// just as if the windows had been fetched one at a time.
+func (c ExecutorConfig) Run(windows []opencost.Window, fetch FetchFunc) []*pb.CustomCostResponse {
+	workers := c.WindowConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(windows) {
+		workers = len(windows)
+	}
+
+	responses := make([]*pb.CustomCostResponse, len(windows))
+	errs := make([]error, len(windows))
+
+	var mu sync.Mutex
+	next := 0
+	// failed is the index of the earliest window that failed, or len(windows) while none have
+	failed := len(windows)
+
+	// claim hands out windows in order until they run out or one fails
+	claim := func() (int, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if next >= failed {
+			return 0, false
+		}
+		i := next
+		next++
+		return i, true
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i, ok := claim(); ok; i, ok = claim() {
+				resp, err := fetch(windows[i])
+				if err != nil {
+					mu.Lock()
+					if i < failed {
+						failed = i
+					}
+					mu.Unlock()
+				}
+				responses[i], errs[i] = resp, err
+			}
+		}()
+	}
+	wg.Wait()
+
+	results := []*pb.CustomCostResponse{}
+	for i := 0; i < failed; i++ {
+		if responses[i] != nil {
+			results = append(results, responses[i])
+		}
+	}
+
+	if failed < len(windows) {
+		results = append(results, costerror.Response(errs[failed]))
+	}
+
+	return results
+}
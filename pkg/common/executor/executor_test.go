@@ -0,0 +1,131 @@
+package executor
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opencost/opencost-plugins/common/costerror"
+	"github.com/opencost/opencost/core/pkg/model/pb"
+	"github.com/opencost/opencost/core/pkg/opencost"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func testWindows(n int) []opencost.Window {
+	start := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	windows, _ := opencost.GetWindows(start, start.Add(time.Duration(n)*time.Hour), time.Hour)
+	return windows
+}
+
+func testResponse(win opencost.Window) *pb.CustomCostResponse {
+	return &pb.CustomCostResponse{Start: timestamppb.New(*win.Start())}
+}
+
+func TestRunKeepsWindowOrder(t *testing.T) {
+	windows := testWindows(50)
+	results := ExecutorConfig{WindowConcurrency: 8}.Run(windows, func(win opencost.Window) (*pb.CustomCostResponse, error) {
+		time.Sleep(time.Duration(rand.Intn(3)) * time.Millisecond)
+		return testResponse(win), nil
+	})
+
+	if len(results) != len(windows) {
+		t.Fatalf("expected %d responses, got %d", len(windows), len(results))
+	}
+	for i, resp := range results {
+		if !resp.Start.AsTime().Equal(*windows[i].Start()) {
+			t.Errorf("response %d is for window starting %v, expected %v", i, resp.Start.AsTime(), *windows[i].Start())
+		}
+	}
+}
+
+func TestRunBoundsConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+
+	ExecutorConfig{WindowConcurrency: 3}.Run(testWindows(20), func(win opencost.Window) (*pb.CustomCostResponse, error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return testResponse(win), nil
+	})
+
+	if maxRunning > 3 {
+		t.Errorf("expected at most 3 windows to be fetched at once, got %d", maxRunning)
+	}
+}
+
+func TestRunSkipsNilResponses(t *testing.T) {
+	windows := testWindows(4)
+	results := ExecutorConfig{WindowConcurrency: 2}.Run(windows, func(win opencost.Window) (*pb.CustomCostResponse, error) {
+		if win.Start().Hour()%2 == 1 {
+			return nil, nil
+		}
+		return testResponse(win), nil
+	})
+
+	if len(results) != 2 {
+		t.Fatalf("expected 2 responses, got %d", len(results))
+	}
+	if !results[1].Start.AsTime().Equal(*windows[2].Start()) {
+		t.Errorf("expected the second response to be for the third window, got %v", results[1].Start.AsTime())
+	}
+}
+
+func TestRunStopsAtFirstError(t *testing.T) {
+	windows := testWindows(20)
+	var mu sync.Mutex
+	fetched := 0
+
+	results := ExecutorConfig{WindowConcurrency: 4}.Run(windows, func(win opencost.Window) (*pb.CustomCostResponse, error) {
+		mu.Lock()
+		fetched++
+		mu.Unlock()
+
+		if win.Start().Hour() == 5 {
+			return nil, costerror.New(costerror.Auth, "error getting pricing")
+		}
+		time.Sleep(time.Millisecond)
+		return testResponse(win), nil
+	})
+
+	if len(results) != 6 {
+		t.Fatalf("expected 5 responses and an error, got %d responses", len(results))
+	}
+	for i, resp := range results[:5] {
+		if len(resp.Errors) > 0 || !resp.Start.AsTime().Equal(*windows[i].Start()) {
+			t.Errorf("unexpected response %d: %v", i, resp)
+		}
+	}
+	if errs := results[5].Errors; len(errs) != 1 || errs[0] != "auth: error getting pricing" {
+		t.Errorf("unexpected errors in the last response: %v", errs)
+	}
+	if fetched == len(windows) {
+		t.Errorf("expected fetching to stop after the error, fetched every window")
+	}
+}
+
+func TestRunWithoutConcurrency(t *testing.T) {
+	windows := testWindows(5)
+	var order []int
+	ExecutorConfig{}.Run(windows, func(win opencost.Window) (*pb.CustomCostResponse, error) {
+		order = append(order, win.Start().Hour())
+		return testResponse(win), nil
+	})
+
+	for i, hour := range order {
+		if hour != i {
+			t.Errorf("expected windows to be fetched one at a time in order, got %v", order)
+			break
+		}
+	}
+}
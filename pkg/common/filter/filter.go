@@ -0,0 +1,162 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/opencost/opencost-plugins/common/decimal"
+	"github.com/opencost/opencost/core/pkg/model/pb"
+)
+
+// Metadata keys recording the costs filters left out of a response's window
+const (
+	// ExcludedCostsKey holds how many costs were left out
+	ExcludedCostsKey = "excluded_costs"
+	// ExcludedBilledCostKey holds their billed cost in each currency, such as "USD=12.5,EUR=3"
+	ExcludedBilledCostKey = "excluded_billed_cost"
+)
+
+// Filter selects the values of one attribute of the costs a plugin reports, such as a product or a project.
+// A value is kept if it matches a pattern in Include, or Include is empty, and matches no pattern in Exclude.
+// Patterns match whole values, ignoring case, and "*" matches any run of characters.
+type Filter struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+// Keeps reports whether the filter keeps a value
+func (f Filter) Keeps(value string) bool {
+	for _, pattern := range f.Exclude {
+		if match(pattern, value) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, pattern := range f.Include {
+		if match(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether value matches a pattern in which "*" matches any run of characters
+func match(pattern, value string) bool {
+	pattern, value = strings.ToLower(pattern), strings.ToLower(value)
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == value
+	}
+
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+
+	// the first occurrence of each literal part leaves the most room for the parts after it
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(value, part)
+		if i < 0 {
+			return false
+		}
+		value = value[i+len(part):]
+	}
+	return strings.HasSuffix(value, parts[len(parts)-1])
+}
+
+// CacheAccount returns the cache account for windows fetched with a plugin's filters, so that windows
+// cached before the filters changed aren't served with the old ones applied. Without filters it is the
+// account itself.
+func CacheAccount(account string, filters any) string {
+	if reflect.ValueOf(filters).IsZero() {
+		return account
+	}
+	encoded, err := json.Marshal(filters)
+	if err != nil {
+		return account + "/" + fmt.Sprint(filters)
+	}
+	return account + "/" + string(encoded)
+}
+
+// Excluded totals the costs filters leave out of a window. The zero value is empty.
+type Excluded struct {
+	Costs int
+	// Billed is the billed cost left out in each currency
+	Billed map[string]decimal.Decimal
+}
+
+// Add records a cost left out of the window, billed in the given currency.
+func (e *Excluded) Add(currency string, billed decimal.Decimal) {
+	if e.Billed == nil {
+		e.Billed = map[string]decimal.Decimal{}
+	}
+	currency = strings.ToUpper(currency)
+	e.Costs++
+	e.Billed[currency] = e.Billed[currency].Add(billed)
+}
+
+// Merge adds the costs left out of another source's response for the same window.
+func (e *Excluded) Merge(other Excluded) {
+	if e.Billed == nil {
+		e.Billed = map[string]decimal.Decimal{}
+	}
+	e.Costs += other.Costs
+	for currency, billed := range other.Billed {
+		e.Billed[currency] = e.Billed[currency].Add(billed)
+	}
+}
+
+// Record sets the totals in resp's Metadata, so users can see what the filters left out. Nothing is
+// recorded when no costs were excluded.
+func (e Excluded) Record(resp *pb.CustomCostResponse) {
+	if resp == nil || e.Costs == 0 {
+		return
+	}
+	if resp.Metadata == nil {
+		resp.Metadata = map[string]string{}
+	}
+
+	currencies := make([]string, 0, len(e.Billed))
+	for currency := range e.Billed {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	totals := make([]string, 0, len(currencies))
+	for _, currency := range currencies {
+		totals = append(totals, currency+"="+e.Billed[currency].String())
+	}
+	resp.Metadata[ExcludedCostsKey] = strconv.Itoa(e.Costs)
+	resp.Metadata[ExcludedBilledCostKey] = strings.Join(totals, ",")
+}
+
+// Recorded returns the totals recorded in resp's Metadata. Totals that can't be read are ignored.
+func Recorded(resp *pb.CustomCostResponse) Excluded {
+	var e Excluded
+	if resp == nil {
+		return e
+	}
+
+	e.Costs, _ = strconv.Atoi(resp.Metadata[ExcludedCostsKey])
+	for _, total := range strings.Split(resp.Metadata[ExcludedBilledCostKey], ",") {
+		currency, amount, ok := strings.Cut(total, "=")
+		if !ok {
+			continue
+		}
+		billed, err := decimal.Parse(amount)
+		if err != nil {
+			continue
+		}
+		if e.Billed == nil {
+			e.Billed = map[string]decimal.Decimal{}
+		}
+		e.Billed[currency] = e.Billed[currency].Add(billed)
+	}
+	return e
+}
@@ -0,0 +1,81 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/opencost/opencost-plugins/common/decimal"
+	"github.com/opencost/opencost/core/pkg/model/pb"
+)
+
+func TestKeeps(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		value  string
+		want   bool
+	}{
+		{"no patterns", Filter{}, "logs", true},
+		{"excluded", Filter{Exclude: []string{"logs"}}, "logs", false},
+		{"not excluded", Filter{Exclude: []string{"logs"}}, "logs_indexed", true},
+		{"included", Filter{Include: []string{"infra_*"}}, "infra_host", true},
+		{"not included", Filter{Include: []string{"infra_*"}}, "apm_host", false},
+		{"exclude wins", Filter{Include: []string{"infra_*"}, Exclude: []string{"*_host"}}, "infra_host", false},
+		{"ignores case", Filter{Include: []string{"ATLAS_AWS_*"}}, "atlas_aws_instance_m10", true},
+		{"middle wildcard", Filter{Include: []string{"gpt-*-mini*"}}, "gpt-4o-mini-2024-07-18", true},
+		{"whole value", Filter{Include: []string{"gpt-4*"}}, "my-gpt-4o", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Keeps(tt.value); got != tt.want {
+				t.Errorf("expected Keeps(%q) to be %v, got %v", tt.value, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCacheAccount(t *testing.T) {
+	type filters struct {
+		Product Filter `json:"product"`
+	}
+
+	if got := CacheAccount("org", filters{}); got != "org" {
+		t.Errorf("expected the account itself without filters, got %q", got)
+	}
+	filtered := CacheAccount("org", filters{Product: Filter{Exclude: []string{"logs"}}})
+	if filtered == "org" || filtered == CacheAccount("org", filters{Product: Filter{Exclude: []string{"apm"}}}) {
+		t.Errorf("expected a cache account for each set of filters, got %q", filtered)
+	}
+}
+
+func TestExcludedRecord(t *testing.T) {
+	var excluded Excluded
+	excluded.Add("usd", decimal.New(1050, -2))
+	excluded.Add("USD", decimal.New(2, 0))
+	excluded.Add("EUR", decimal.New(3, 0))
+
+	resp := &pb.CustomCostResponse{}
+	excluded.Record(resp)
+	if resp.Metadata[ExcludedCostsKey] != "3" {
+		t.Errorf("expected 3 excluded costs, got %q", resp.Metadata[ExcludedCostsKey])
+	}
+	if resp.Metadata[ExcludedBilledCostKey] != "EUR=3,USD=12.5" {
+		t.Errorf("unexpected excluded billed cost: %q", resp.Metadata[ExcludedBilledCostKey])
+	}
+
+	// totals read back from a response merge with those of another source for the same window
+	merged := Recorded(resp)
+	merged.Merge(Recorded(resp))
+	merged.Record(resp)
+	if resp.Metadata[ExcludedCostsKey] != "6" || resp.Metadata[ExcludedBilledCostKey] != "EUR=6,USD=25" {
+		t.Errorf("unexpected merged totals: %v", resp.Metadata)
+	}
+}
+
+func TestExcludedRecordsNothingWhenEmpty(t *testing.T) {
+	resp := &pb.CustomCostResponse{}
+	Excluded{}.Record(resp)
+	if resp.Metadata != nil {
+		t.Errorf("expected no metadata, got %v", resp.Metadata)
+	}
+}
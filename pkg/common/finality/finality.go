@@ -0,0 +1,75 @@
+package finality
+
+import (
+	"time"
+
+	"github.com/opencost/opencost/core/pkg/model/pb"
+)
+
+// Status says whether the costs in a response window can still change.
+type Status string
+
+const (
+	// Estimated costs are derived by the plugin, such as from list prices or an estimated bill, rather
+	// than billed, and may differ from the final bill
+	Estimated Status = "estimated"
+	// Provisional costs are billed, but the upstream may still revise them, such as on an open invoice
+	Provisional Status = "provisional"
+	// Final costs have settled and will not change when queried again
+	Final Status = "final"
+)
+
+const (
+	// StatusKey is the response Metadata key holding the window's Status
+	StatusKey = "finality"
+	// SettlesAtKey is the response Metadata key holding the RFC3339 time the window's costs are
+	// expected to become final. It is unset for windows that never settle, such as estimates from
+	// list prices.
+	SettlesAtKey = "finality_settles_at"
+)
+
+// Mark records the finality of a response's window in its Metadata, so OpenCost and reports can
+// re-query or flag windows that haven't settled. A window is Final once settlesAt has passed, and
+// unsettled until then. A window whose response has Errors, such as one the request's budget ran out
+// before fetching, is missing costs, so it stays unsettled however old it is. A zero settlesAt means
+// the window never settles, and is always unsettled. Responses without a window, such as for rejected
+// requests, are left unmarked.
+func Mark(resp *pb.CustomCostResponse, unsettled Status, settlesAt, now time.Time) {
+	status := unsettled
+	if resp != nil && len(resp.Errors) == 0 && !settlesAt.IsZero() && !now.Before(settlesAt) {
+		status = Final
+	}
+	record(resp, status, settlesAt)
+}
+
+// MarkUnsettled records a response's window as unsettled whatever the time, for costs known not to be
+// final once settlesAt has passed, such as those priced from an estimate the upstream was expected to
+// have replaced by then.
+func MarkUnsettled(resp *pb.CustomCostResponse, unsettled Status, settlesAt time.Time) {
+	record(resp, unsettled, settlesAt)
+}
+
+func record(resp *pb.CustomCostResponse, status Status, settlesAt time.Time) {
+	if resp == nil || resp.Start == nil || resp.End == nil {
+		return
+	}
+
+	if resp.Metadata == nil {
+		resp.Metadata = map[string]string{}
+	}
+
+	resp.Metadata[StatusKey] = string(status)
+	if settlesAt.IsZero() {
+		delete(resp.Metadata, SettlesAtKey)
+	} else {
+		resp.Metadata[SettlesAtKey] = settlesAt.UTC().Format(time.RFC3339)
+	}
+}
+
+// MonthClose returns when the calendar month containing the end of a window closes, in UTC. Costs
+// billed on a monthly invoice settle then.
+func MonthClose(end time.Time) time.Time {
+	// a window ending exactly at midnight on the first belongs to the month before
+	last := end.UTC().Add(-time.Nanosecond)
+	return time.Date(last.Year(), last.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,106 @@
+package finality
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opencost/opencost/core/pkg/model/pb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var windowStart = time.Date(2024, 10, 9, 0, 0, 0, 0, time.UTC)
+
+func window() *pb.CustomCostResponse {
+	return &pb.CustomCostResponse{
+		Start: timestamppb.New(windowStart),
+		End:   timestamppb.New(windowStart.Add(24 * time.Hour)),
+	}
+}
+
+func TestMark(t *testing.T) {
+	settlesAt := windowStart.Add(96 * time.Hour)
+	tests := []struct {
+		name          string
+		unsettled     Status
+		settlesAt     time.Time
+		now           time.Time
+		wantStatus    string
+		wantSettlesAt string
+	}{
+		{"before settling", Estimated, settlesAt, settlesAt.Add(-time.Second), "estimated", "2024-10-13T00:00:00Z"},
+		{"once settled", Estimated, settlesAt, settlesAt, "final", "2024-10-13T00:00:00Z"},
+		{"provisional", Provisional, settlesAt, windowStart, "provisional", "2024-10-13T00:00:00Z"},
+		{"never settles", Estimated, time.Time{}, settlesAt.AddDate(1, 0, 0), "estimated", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := window()
+			Mark(resp, tt.unsettled, tt.settlesAt, tt.now)
+			if resp.Metadata[StatusKey] != tt.wantStatus {
+				t.Errorf("expected status %s, got %s", tt.wantStatus, resp.Metadata[StatusKey])
+			}
+			if resp.Metadata[SettlesAtKey] != tt.wantSettlesAt {
+				t.Errorf("expected settle time %q, got %q", tt.wantSettlesAt, resp.Metadata[SettlesAtKey])
+			}
+		})
+	}
+}
+
+func TestMarkKeepsWindowsWithErrorsUnsettled(t *testing.T) {
+	settlesAt := windowStart.Add(48 * time.Hour)
+	resp := window()
+	resp.Errors = []string{"partial_data: window not fetched: request budget of 10m0s exhausted"}
+	Mark(resp, Provisional, settlesAt, settlesAt.AddDate(1, 0, 0))
+
+	if resp.Metadata[StatusKey] != "provisional" || resp.Metadata[SettlesAtKey] != "2024-10-11T00:00:00Z" {
+		t.Errorf("expected a window with errors to stay provisional, got %v", resp.Metadata)
+	}
+}
+
+func TestMarkUnsettled(t *testing.T) {
+	settlesAt := windowStart.Add(48 * time.Hour)
+	resp := window()
+	MarkUnsettled(resp, Estimated, settlesAt)
+
+	if resp.Metadata[StatusKey] != "estimated" || resp.Metadata[SettlesAtKey] != "2024-10-11T00:00:00Z" {
+		t.Errorf("expected the window to be estimated, got %v", resp.Metadata)
+	}
+}
+
+func TestMarkKeepsMetadata(t *testing.T) {
+	resp := window()
+	resp.Metadata = map[string]string{"error_kinds": "partial_data"}
+	Mark(resp, Provisional, windowStart.Add(48*time.Hour), windowStart)
+
+	if resp.Metadata["error_kinds"] != "partial_data" || resp.Metadata[StatusKey] != "provisional" {
+		t.Errorf("unexpected metadata: %v", resp.Metadata)
+	}
+}
+
+func TestMarkSkipsResponsesWithoutWindow(t *testing.T) {
+	resp := &pb.CustomCostResponse{Errors: []string{"unsupported_request: resolution must be 1d"}}
+	Mark(resp, Provisional, windowStart, windowStart)
+	Mark(nil, Provisional, windowStart, windowStart)
+
+	if resp.Metadata != nil {
+		t.Errorf("expected no metadata, got %v", resp.Metadata)
+	}
+}
+
+func TestMonthClose(t *testing.T) {
+	tests := []struct {
+		end  time.Time
+		want time.Time
+	}{
+		{time.Date(2024, 10, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 12, 31, 13, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		if got := MonthClose(tt.end); !got.Equal(tt.want) {
+			t.Errorf("expected a window ending %s to settle at %s, got %s", tt.end, tt.want, got)
+		}
+	}
+}
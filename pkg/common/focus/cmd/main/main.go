@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/opencost/opencost-plugins/common/focus"
+	"github.com/opencost/opencost/core/pkg/model/pb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const usage = `usage: focus-check [--start <RFC3339> --end <RFC3339> --resolution <duration>] <responses file>...
+
+Checks saved GetCustomCosts responses against the FOCUS rules every plugin must follow.
+Each file holds a JSON list of responses, as written by the integration test harness or
+by running a plugin with "query --output json". When the request is given, windows must
+also fall within it and match its resolution.`
+
+// the FOCUS checker reads responses saved by the integration test harness or a plugin's query
+// subcommand, and reports every violation found. It exits non-zero if any file does not conform.
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(argv []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("focus-check", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	start := flags.String("start", "", "start of the request the responses answer, as RFC3339")
+	end := flags.String("end", "", "end of the request the responses answer, as RFC3339")
+	resolution := flags.Duration("resolution", 0, "resolution of the request the responses answer, such as 24h")
+	if err := flags.Parse(argv); err != nil || flags.NArg() == 0 {
+		fmt.Fprintln(stderr, usage)
+		return 2
+	}
+
+	req, err := request(*start, *end, *resolution)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s\n%v\n", usage, err)
+		return 2
+	}
+
+	exitCode := 0
+	for _, path := range flags.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", path, err)
+			exitCode = 1
+			continue
+		}
+
+		responses, err := focus.UnmarshalResponses(data)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", path, err)
+			exitCode = 1
+			continue
+		}
+
+		var violations []focus.Violation
+		if req != nil {
+			violations = focus.CheckRequest(req, responses)
+		} else {
+			violations = focus.Check(responses)
+		}
+
+		if len(violations) == 0 {
+			fmt.Fprintf(stdout, "%s: %d responses conform to FOCUS\n", path, len(responses))
+			continue
+		}
+
+		exitCode = 1
+		fmt.Fprintf(stderr, "%s: %d FOCUS violation(s):\n", path, len(violations))
+		for _, v := range violations {
+			fmt.Fprintf(stderr, "\t- %s\n", v)
+		}
+	}
+
+	return exitCode
+}
+
+// request builds the request the responses answer, or nil if none was given
+func request(start, end string, resolution time.Duration) (*pb.CustomCostRequest, error) {
+	if start == "" && end == "" && resolution == 0 {
+		return nil, nil
+	}
+	if start == "" || end == "" || resolution <= 0 {
+		return nil, fmt.Errorf("--start, --end and --resolution must be given together")
+	}
+
+	startTime, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return nil, fmt.Errorf("--start must be RFC3339: %v", err)
+	}
+	endTime, err := time.Parse(time.RFC3339, end)
+	if err != nil {
+		return nil, fmt.Errorf("--end must be RFC3339: %v", err)
+	}
+
+	return &pb.CustomCostRequest{
+		Start:      timestamppb.New(startTime),
+		End:        timestamppb.New(endTime),
+		Resolution: durationpb.New(resolution),
+	}, nil
+}
@@ -0,0 +1,273 @@
+package focus
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/opencost/opencost/core/pkg/model/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// ChargeCategory values allowed by FOCUS
+var chargeCategories = []string{"Usage", "Purchase", "Tax", "Credit", "Adjustment"}
+
+// ChargeFrequency values allowed by FOCUS
+var chargeFrequencies = []string{"One-Time", "Recurring", "Usage-Based"}
+
+// CommitmentDiscountCategory values allowed by FOCUS
+var commitmentDiscountCategories = []string{"Spend", "Usage"}
+
+// ServiceCategory values allowed by FOCUS
+var serviceCategories = []string{
+	"AI and Machine Learning", "Analytics", "Business Applications", "Compute", "Databases", "Developer Tools",
+	"Multicloud", "Identity", "Integration", "Internet of Things", "Management and Governance", "Media",
+	"Migration", "Mobile", "Networking", "Security", "Storage", "Web", "Other",
+}
+
+// placeholders are values that stand in for a missing string rather than describing anything
+var placeholders = []string{"nil", "null", "<nil>", "none", "n/a"}
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Violation is a single way a response breaks the FOCUS rules.
+type Violation struct {
+	// Window is the index of the response in the checked list
+	Window int
+	// Cost is the index of the cost in the response, or -1 for a problem with the response itself
+	Cost int
+	// Field is the protobuf name of the offending field, such as "charge_category"
+	Field   string
+	Message string
+}
+
+func (v Violation) String() string {
+	if v.Cost < 0 {
+		return fmt.Sprintf("window %d: %s %s", v.Window, v.Field, v.Message)
+	}
+	return fmt.Sprintf("window %d, cost %d: %s %s", v.Window, v.Cost, v.Field, v.Message)
+}
+
+// checker collects the violations found in a list of responses
+type checker struct {
+	violations []Violation
+}
+
+func (c *checker) add(window, cost int, field, format string, args ...interface{}) {
+	c.violations = append(c.violations, Violation{Window: window, Cost: cost, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Check validates responses against the FOCUS rules every plugin must follow: required fields are set,
+// enumerated fields hold allowed values, cost IDs are unique, costs are non-negative, quantities have
+// units and windows are well formed and in order. It returns every violation found, or nil if the
+// responses conform.
+func Check(responses []*pb.CustomCostResponse) []Violation {
+	c := &checker{}
+	for i, resp := range responses {
+		c.checkResponse(i, resp)
+	}
+	c.checkWindowOrder(responses)
+	return c.violations
+}
+
+// CheckRequest is Check, additionally requiring every window to fall within the request and match its resolution.
+func CheckRequest(req *pb.CustomCostRequest, responses []*pb.CustomCostResponse) []Violation {
+	c := &checker{}
+	for i, resp := range responses {
+		c.checkResponse(i, resp)
+		c.checkWindowBounds(i, req, resp)
+	}
+	c.checkWindowOrder(responses)
+	return c.violations
+}
+
+func (c *checker) checkResponse(i int, resp *pb.CustomCostResponse) {
+	if resp == nil {
+		c.add(i, -1, "response", "is nil")
+		return
+	}
+
+	// a response for a rejected request, such as costerror.Response builds, carries nothing but its errors
+	if resp.Start == nil && resp.End == nil && len(resp.Costs) == 0 && len(resp.Errors) > 0 {
+		return
+	}
+
+	if resp.Start == nil {
+		c.add(i, -1, "start", "is required")
+	}
+	if resp.End == nil {
+		c.add(i, -1, "end", "is required")
+	}
+	if resp.Start != nil && resp.End != nil && !resp.End.AsTime().After(resp.Start.AsTime()) {
+		c.add(i, -1, "end", "must be after start, got %s to %s", formatTime(resp.Start.AsTime()), formatTime(resp.End.AsTime()))
+	}
+
+	c.requireString(i, -1, "domain", resp.Domain)
+	c.requireString(i, -1, "cost_source", resp.CostSource)
+	if !currencyCode.MatchString(resp.Currency) {
+		c.add(i, -1, "currency", "must be an ISO 4217 code, got %q", resp.Currency)
+	}
+
+	// IDs identify a cost across re-fetches of its window, so they can't be shared within one
+	seen := map[string]int{}
+	for j, cost := range resp.Costs {
+		c.checkCost(i, j, cost)
+		if cost == nil || cost.Id == "" {
+			continue
+		}
+		if first, ok := seen[cost.Id]; ok {
+			c.add(i, j, "id", "must be unique within the window, got %q, also used by cost %d", cost.Id, first)
+			continue
+		}
+		seen[cost.Id] = j
+	}
+}
+
+func (c *checker) checkCost(i, j int, cost *pb.CustomCost) {
+	if cost == nil {
+		c.add(i, j, "cost", "is nil")
+		return
+	}
+
+	c.requireString(i, j, "id", cost.Id)
+	c.requireString(i, j, "provider_id", cost.ProviderId)
+	c.requireString(i, j, "account_name", cost.AccountName)
+	c.requireString(i, j, "resource_name", cost.ResourceName)
+	c.requireString(i, j, "description", cost.Description)
+	c.oneOf(i, j, "charge_category", cost.ChargeCategory, chargeCategories)
+
+	// credits and adjustments are the only charges that may reduce a bill
+	canBeNegative := cost.ChargeCategory == "Credit" || cost.ChargeCategory == "Adjustment"
+	amounts := []struct {
+		field  string
+		amount float32
+	}{
+		{"billed_cost", cost.BilledCost},
+		{"list_cost", cost.ListCost},
+		{"list_unit_price", cost.ListUnitPrice},
+		{"usage_quantity", cost.UsageQuantity},
+	}
+	for _, a := range amounts {
+		switch {
+		case math.IsNaN(float64(a.amount)) || math.IsInf(float64(a.amount), 0):
+			c.add(i, j, a.field, "must be a finite number, got %v", a.amount)
+		case a.amount < 0 && !canBeNegative:
+			c.add(i, j, a.field, "must not be negative for a %s charge, got %v", cost.ChargeCategory, a.amount)
+		}
+	}
+
+	if cost.UsageQuantity != 0 {
+		c.requireString(i, j, "usage_unit", cost.UsageUnit)
+	} else if isPlaceholder(cost.UsageUnit) {
+		c.add(i, j, "usage_unit", "must not be a placeholder, got %q", cost.UsageUnit)
+	}
+
+	attrs := cost.ExtendedAttributes
+	if attrs == nil {
+		return
+	}
+	if attrs.ChargeFrequency != nil {
+		c.oneOf(i, j, "charge_frequency", *attrs.ChargeFrequency, chargeFrequencies)
+	}
+	if attrs.CommitmentDiscountCategory != nil {
+		c.oneOf(i, j, "commitment_discount_category", *attrs.CommitmentDiscountCategory, commitmentDiscountCategories)
+	}
+	if attrs.ServiceCategory != nil {
+		c.oneOf(i, j, "service_category", *attrs.ServiceCategory, serviceCategories)
+	}
+	if attrs.PricingQuantity != nil && *attrs.PricingQuantity != 0 && attrs.GetPricingUnit() == "" {
+		c.add(i, j, "pricing_unit", "is required when pricing_quantity is set")
+	}
+	if attrs.BillingPeriodStart != nil && attrs.BillingPeriodEnd != nil &&
+		!attrs.BillingPeriodEnd.AsTime().After(attrs.BillingPeriodStart.AsTime()) {
+		c.add(i, j, "billing_period_end", "must be after billing_period_start")
+	}
+}
+
+// checkWindowBounds requires a response's window to lie within the request and span its resolution
+func (c *checker) checkWindowBounds(i int, req *pb.CustomCostRequest, resp *pb.CustomCostResponse) {
+	if req == nil || resp == nil || resp.Start == nil || resp.End == nil {
+		return
+	}
+
+	start, end := resp.Start.AsTime(), resp.End.AsTime()
+	if req.Start != nil && start.Before(req.Start.AsTime()) {
+		c.add(i, -1, "start", "must not be before the requested start %s, got %s", formatTime(req.Start.AsTime()), formatTime(start))
+	}
+	if req.End != nil && end.After(req.End.AsTime()) {
+		c.add(i, -1, "end", "must not be after the requested end %s, got %s", formatTime(req.End.AsTime()), formatTime(end))
+	}
+	if req.Resolution != nil && end.Sub(start) != req.Resolution.AsDuration() {
+		c.add(i, -1, "end", "must be one resolution of %s after start, got a %s window", req.Resolution.AsDuration(), end.Sub(start))
+	}
+}
+
+// checkWindowOrder requires windows to be returned in order, without overlapping
+func (c *checker) checkWindowOrder(responses []*pb.CustomCostResponse) {
+	var previous *pb.CustomCostResponse
+	for i, resp := range responses {
+		if resp == nil || resp.Start == nil || resp.End == nil {
+			continue
+		}
+		if previous != nil && resp.Start.AsTime().Before(previous.End.AsTime()) {
+			c.add(i, -1, "start", "must not be before the end of the previous window %s, got %s",
+				formatTime(previous.End.AsTime()), formatTime(resp.Start.AsTime()))
+		}
+		previous = resp
+	}
+}
+
+func (c *checker) requireString(i, j int, field, value string) {
+	switch {
+	case strings.TrimSpace(value) == "":
+		c.add(i, j, field, "is required")
+	case isPlaceholder(value):
+		c.add(i, j, field, "must not be a placeholder, got %q", value)
+	}
+}
+
+func (c *checker) oneOf(i, j int, field, value string, allowed []string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	c.add(i, j, field, "must be one of [%s], got %q", strings.Join(allowed, ", "), value)
+}
+
+func isPlaceholder(value string) bool {
+	value = strings.ToLower(strings.TrimSpace(value))
+	for _, p := range placeholders {
+		if value == p {
+			return true
+		}
+	}
+	return false
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// UnmarshalResponses reads responses saved by the integration test harness, a JSON list of
+// responses in their protobuf JSON encoding.
+func UnmarshalResponses(data []byte) ([]*pb.CustomCostResponse, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("expected a JSON list of responses: %v", err)
+	}
+
+	responses := make([]*pb.CustomCostResponse, len(raw))
+	for i, r := range raw {
+		resp := &pb.CustomCostResponse{}
+		if err := protojson.Unmarshal(r, resp); err != nil {
+			return nil, fmt.Errorf("error decoding response %d: %v", i, err)
+		}
+		responses[i] = resp
+	}
+
+	return responses, nil
+}
@@ -0,0 +1,155 @@
+package focus
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencost/opencost-plugins/common/costerror"
+	"github.com/opencost/opencost/core/pkg/model/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var windowStart = time.Date(2024, 10, 9, 0, 0, 0, 0, time.UTC)
+
+func validCost() *pb.CustomCost {
+	return &pb.CustomCost{
+		Id:             "a1",
+		ProviderId:     "org/project/gpt-4o",
+		AccountName:    "acme",
+		ResourceName:   "gpt-4o",
+		Description:    "OpenAI usage for model gpt-4o",
+		ChargeCategory: "Usage",
+		BilledCost:     1.5,
+		UsageQuantity:  1000,
+		UsageUnit:      "tokens",
+	}
+}
+
+func validResponse(start time.Time, costs ...*pb.CustomCost) *pb.CustomCostResponse {
+	return &pb.CustomCostResponse{
+		Domain:     "openai",
+		CostSource: "AI",
+		Currency:   "USD",
+		Start:      timestamppb.New(start),
+		End:        timestamppb.New(start.Add(24 * time.Hour)),
+		Costs:      costs,
+	}
+}
+
+func fields(violations []Violation) []string {
+	var names []string
+	for _, v := range violations {
+		names = append(names, v.Field)
+	}
+	return names
+}
+
+func TestCheckConformingResponses(t *testing.T) {
+	responses := []*pb.CustomCostResponse{
+		validResponse(windowStart, validCost()),
+		validResponse(windowStart.Add(24*time.Hour), validCost()),
+		// a rejected request reports nothing but its error
+		costerror.Response(costerror.New(costerror.UnsupportedRequest, "resolution must be 1d")),
+	}
+
+	if violations := Check(responses); len(violations) != 0 {
+		t.Errorf("expected no violations, got %v", violations)
+	}
+}
+
+func TestCheckCost(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*pb.CustomCost)
+		want   string
+	}{
+		{"lowercase charge category", func(c *pb.CustomCost) { c.ChargeCategory = "usage" }, "charge_category"},
+		{"placeholder description", func(c *pb.CustomCost) { c.Description = "nil" }, "description"},
+		{"missing provider id", func(c *pb.CustomCost) { c.ProviderId = "" }, "provider_id"},
+		{"missing account name", func(c *pb.CustomCost) { c.AccountName = "" }, "account_name"},
+		{"negative usage", func(c *pb.CustomCost) { c.UsageQuantity = -1 }, "usage_quantity"},
+		{"negative cost", func(c *pb.CustomCost) { c.BilledCost = -2 }, "billed_cost"},
+		{"quantity without unit", func(c *pb.CustomCost) { c.UsageUnit = "" }, "usage_unit"},
+		{"invalid service category", func(c *pb.CustomCost) {
+			category := "AI"
+			c.ExtendedAttributes = &pb.CustomCostExtendedAttributes{ServiceCategory: &category}
+		}, "service_category"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cost := validCost()
+			tt.modify(cost)
+			got := fields(Check([]*pb.CustomCostResponse{validResponse(windowStart, cost)}))
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("expected a single %s violation, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCheckDuplicateIDs(t *testing.T) {
+	got := fields(Check([]*pb.CustomCostResponse{validResponse(windowStart, validCost(), validCost())}))
+	if len(got) != 1 || got[0] != "id" {
+		t.Errorf("expected a single id violation, got %v", got)
+	}
+}
+
+func TestCheckCreditsMayBeNegative(t *testing.T) {
+	credit := validCost()
+	credit.ChargeCategory = "Credit"
+	credit.BilledCost = -10
+
+	if violations := Check([]*pb.CustomCostResponse{validResponse(windowStart, credit)}); len(violations) != 0 {
+		t.Errorf("expected no violations for a credit, got %v", violations)
+	}
+}
+
+func TestCheckRequestWindows(t *testing.T) {
+	req := &pb.CustomCostRequest{
+		Start:      timestamppb.New(windowStart),
+		End:        timestamppb.New(windowStart.Add(48 * time.Hour)),
+		Resolution: durationpb.New(24 * time.Hour),
+	}
+
+	hourly := validResponse(windowStart.Add(24 * time.Hour))
+	hourly.End = timestamppb.New(windowStart.Add(25 * time.Hour))
+	responses := []*pb.CustomCostResponse{
+		validResponse(windowStart.Add(24 * time.Hour)),
+		// ends after the request
+		validResponse(windowStart.Add(48 * time.Hour)),
+		// out of order
+		validResponse(windowStart),
+		// shorter than the resolution
+		hourly,
+	}
+
+	got := strings.Join(fields(CheckRequest(req, responses)), ",")
+	if got != "end,end,start" {
+		t.Errorf("unexpected violations: %v", CheckRequest(req, responses))
+	}
+}
+
+func TestUnmarshalResponses(t *testing.T) {
+	resp := validResponse(windowStart, validCost())
+	encoded, err := protojson.Marshal(resp)
+	if err != nil {
+		t.Fatalf("error encoding response: %v", err)
+	}
+	data, err := json.Marshal([]json.RawMessage{encoded})
+	if err != nil {
+		t.Fatalf("error encoding responses: %v", err)
+	}
+
+	responses, err := UnmarshalResponses(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(responses) != 1 || responses[0].Costs[0].ProviderId != "org/project/gpt-4o" {
+		t.Errorf("unexpected responses: %v", responses)
+	}
+}
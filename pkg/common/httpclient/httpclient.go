@@ -0,0 +1,248 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opencost/opencost-plugins/common/metrics"
+	"github.com/opencost/opencost/core/pkg/log"
+)
+
+const defaultMaxAttempts = 5
+const defaultBaseDelay = time.Second
+const defaultMaxDelay = 30 * time.Second
+
+// a reset header larger than this is a unix timestamp rather than a number of seconds
+const unixTimestampThreshold = 1_000_000_000
+
+// NewClient returns an http.Client that retries requests with the default Transport settings.
+// base is the round tripper that sends each attempt, such as an authenticating transport. If nil,
+// http.DefaultTransport is used.
+func NewClient(base http.RoundTripper) *http.Client {
+	return &http.Client{Transport: &Transport{Base: base}}
+}
+
+// Transport is an http.RoundTripper that retries failed requests with exponential backoff and jitter.
+//
+// Network errors and 408, 429, 500, 502, 503 and 504 responses are retried. Any other response,
+// including 401 and 403, is terminal and is returned to the caller as is. The delay before a retry
+// is taken from the Retry-After or X-RateLimit-* headers when the upstream sends them.
+//
+// Retries stop early when the request's context is done, or when the next retry could not start
+// before the context's deadline. The last response or error is then returned.
+type Transport struct {
+	// Base sends each attempt. Defaults to http.DefaultTransport.
+	Base http.RoundTripper
+	// MaxAttempts is the total number of attempts, including the first. Defaults to 5.
+	MaxAttempts int
+	// BaseDelay is the backoff before the first retry, doubled for each retry after it. Defaults to 1s.
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff between attempts. Delays requested by the upstream are not capped,
+	// but are still bound by the request's deadline. Defaults to 30s.
+	MaxDelay time.Duration
+
+	// wait blocks for the given delay, or until ctx is done. Replaced in tests.
+	wait func(ctx context.Context, d time.Duration) error
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	maxAttempts := t.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	// a body can only be sent again if it can be rewound
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		maxAttempts = 1
+	}
+
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		attemptReq := req
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("error rewinding request body for retry: %v", err)
+			}
+			attemptReq = req.Clone(ctx)
+			attemptReq.Body = body
+		}
+
+		start := time.Now()
+		resp, err := base.RoundTrip(attemptReq)
+		metrics.ObserveUpstreamRequest(req.URL.Host, resp, time.Since(start))
+		if !shouldRetry(ctx, resp, err) || attempt >= maxAttempts {
+			return resp, err
+		}
+
+		delay := t.backoff(attempt)
+		if serverDelay, ok := retryAfter(resp); ok {
+			delay = serverDelay
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			log.Warnf("not retrying %s %s: the next attempt in %v would start after the request deadline", req.Method, redact(req), delay)
+			return resp, err
+		}
+
+		if err != nil {
+			log.Warnf("error calling %s %s, retrying in %v (attempt %d of %d): %v", req.Method, redact(req), delay, attempt, maxAttempts, err)
+		} else {
+			log.Warnf("got %d from %s %s, retrying in %v (attempt %d of %d)", resp.StatusCode, req.Method, redact(req), delay, attempt, maxAttempts)
+			// drain the body so the connection can be reused
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		metrics.ObserveRetry(req.URL.Host)
+		wait := t.wait
+		if wait == nil {
+			wait = sleep
+		}
+		if waitErr := wait(ctx, delay); waitErr != nil {
+			return nil, waitErr
+		}
+	}
+}
+
+// backoff returns the delay before the retry following the given attempt: exponential growth
+// from BaseDelay up to MaxDelay, with the upper half of the delay randomized
+func (t *Transport) backoff(attempt int) time.Duration {
+	baseDelay := t.BaseDelay
+	if baseDelay <= 0 {
+		baseDelay = defaultBaseDelay
+	}
+	maxDelay := t.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxDelay
+	}
+
+	delay := maxDelay
+	// past 2^30 the delay is always capped, and shifting further could overflow
+	if attempt <= 30 && baseDelay<<(attempt-1) < maxDelay {
+		delay = baseDelay << (attempt - 1)
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// IsRetryableStatus reports whether a response status is worth retrying
+func IsRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return IsRetryableStatus(resp.StatusCode)
+}
+
+// retryAfter reads the delay the upstream asked for, if any. Retry-After is used when present.
+// Otherwise, the X-RateLimit reset headers are used when the request was rate limited or the
+// remaining quota is exhausted.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+
+	if value := resp.Header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			return nonNegative(time.Duration(seconds) * time.Second), true
+		}
+		if at, err := http.ParseTime(value); err == nil {
+			return nonNegative(time.Until(at)), true
+		}
+	}
+
+	rateLimited := resp.StatusCode == http.StatusTooManyRequests
+	for _, name := range []string{"X-RateLimit-Remaining", "X-RateLimit-Remaining-Requests"} {
+		if resp.Header.Get(name) == "0" {
+			rateLimited = true
+		}
+	}
+	if !rateLimited {
+		return 0, false
+	}
+
+	for _, name := range []string{"X-RateLimit-Reset", "X-RateLimit-Reset-Requests"} {
+		if delay, ok := parseReset(resp.Header.Get(name)); ok {
+			return delay, true
+		}
+	}
+	return 0, false
+}
+
+// parseReset reads a rate limit reset header, which upstreams send as seconds until the reset,
+// as a unix timestamp, or as a duration such as "6m0s"
+func parseReset(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds > unixTimestampThreshold {
+			return nonNegative(time.Until(time.Unix(int64(seconds), 0))), true
+		}
+		return nonNegative(time.Duration(seconds * float64(time.Second))), true
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return nonNegative(d), true
+	}
+
+	return 0, false
+}
+
+func nonNegative(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// redact drops the query string from a request URL for logging, as some upstreams take keys there
+func redact(req *http.Request) string {
+	url := req.URL.String()
+	if i := strings.Index(url, "?"); i >= 0 {
+		return url[:i]
+	}
+	return url
+}
@@ -0,0 +1,172 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testServer replies with the given statuses in turn, then with 200
+func testServer(t *testing.T, headers http.Header, statuses ...int) (*httptest.Server, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := int(atomic.AddInt32(&calls, 1))
+		if call <= len(statuses) {
+			for name, values := range headers {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(statuses[call-1])
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+// recordingTransport records the delays it would have waited instead of sleeping
+func recordingTransport(delays *[]time.Duration) *Transport {
+	return &Transport{
+		wait: func(ctx context.Context, d time.Duration) error {
+			*delays = append(*delays, d)
+			return nil
+		},
+	}
+}
+
+func TestRetriesRetryableStatuses(t *testing.T) {
+	server, calls := testServer(t, nil, http.StatusTooManyRequests, http.StatusBadGateway)
+
+	var delays []time.Duration
+	client := &http.Client{Transport: recordingTransport(&delays)}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || *calls != 3 {
+		t.Errorf("expected a 200 after 3 calls, got %d after %d", resp.StatusCode, *calls)
+	}
+
+	// exponential backoff from 1s, with jitter in the upper half of each delay
+	if len(delays) != 2 || delays[0] < 500*time.Millisecond || delays[0] > time.Second || delays[1] < time.Second || delays[1] > 2*time.Second {
+		t.Errorf("unexpected backoff delays: %v", delays)
+	}
+}
+
+func TestDoesNotRetryTerminalStatuses(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound} {
+		server, calls := testServer(t, nil, status)
+
+		var delays []time.Duration
+		client := &http.Client{Transport: recordingTransport(&delays)}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != status || *calls != 1 {
+			t.Errorf("expected %d to be returned without retrying, got %d after %d calls", status, resp.StatusCode, *calls)
+		}
+	}
+}
+
+func TestGivesUpAfterMaxAttempts(t *testing.T) {
+	server, calls := testServer(t, nil, 500, 500, 500, 500)
+
+	var delays []time.Duration
+	transport := recordingTransport(&delays)
+	transport.MaxAttempts = 3
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusInternalServerError || *calls != 3 {
+		t.Errorf("expected the last 500 after 3 calls, got %d after %d", resp.StatusCode, *calls)
+	}
+}
+
+func TestHonorsRateLimitHeaders(t *testing.T) {
+	tests := map[string]struct {
+		headers  http.Header
+		status   int
+		expected time.Duration
+	}{
+		"retry after seconds": {
+			headers:  http.Header{"Retry-After": []string{"7"}},
+			status:   http.StatusServiceUnavailable,
+			expected: 7 * time.Second,
+		},
+		"datadog reset seconds": {
+			headers:  http.Header{"X-Ratelimit-Remaining": []string{"0"}, "X-Ratelimit-Reset": []string{"12"}},
+			status:   http.StatusTooManyRequests,
+			expected: 12 * time.Second,
+		},
+		"openai reset duration": {
+			headers:  http.Header{"X-Ratelimit-Reset-Requests": []string{"1m30s"}},
+			status:   http.StatusTooManyRequests,
+			expected: 90 * time.Second,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			server, _ := testServer(t, tt.headers, tt.status)
+
+			var delays []time.Duration
+			resp, err := (&http.Client{Transport: recordingTransport(&delays)}).Get(server.URL)
+			if err != nil {
+				t.Fatalf("expected no error, but got: %v", err)
+			}
+			resp.Body.Close()
+
+			if len(delays) != 1 || delays[0] != tt.expected {
+				t.Errorf("expected a single delay of %v, got %v", tt.expected, delays)
+			}
+		})
+	}
+}
+
+func TestStopsAtDeadline(t *testing.T) {
+	server, calls := testServer(t, http.Header{"Retry-After": []string{"60"}}, http.StatusTooManyRequests)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+
+	var delays []time.Duration
+	resp, err := (&http.Client{Transport: recordingTransport(&delays)}).Do(req)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTooManyRequests || *calls != 1 || len(delays) != 0 {
+		t.Errorf("expected the 429 to be returned without waiting past the deadline, got %d after %d calls", resp.StatusCode, *calls)
+	}
+}
+
+func TestDoesNotReplayUnrewindableBodies(t *testing.T) {
+	server, calls := testServer(t, nil, http.StatusServiceUnavailable)
+
+	var delays []time.Duration
+	req, _ := http.NewRequest("POST", server.URL, strings.NewReader("payload"))
+	req.GetBody = nil
+	resp, err := (&http.Client{Transport: recordingTransport(&delays)}).Do(req)
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	resp.Body.Close()
+
+	if *calls != 1 {
+		t.Errorf("expected a single call, got %d", *calls)
+	}
+}
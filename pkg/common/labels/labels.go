@@ -0,0 +1,187 @@
+package labels
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/opencost/opencost/core/pkg/model/pb"
+	ocplugin "github.com/opencost/opencost/core/pkg/plugin"
+)
+
+// metadataPrefix selects a key of the cost's Metadata as a rule's field, such as "metadata.cluster_name"
+const metadataPrefix = "metadata."
+
+// fields are the cost attributes rules can match on, besides Metadata keys
+var fields = map[string]func(*pb.CustomCost) string{
+	"zone":            func(c *pb.CustomCost) string { return c.Zone },
+	"account_name":    func(c *pb.CustomCost) string { return c.AccountName },
+	"charge_category": func(c *pb.CustomCost) string { return c.ChargeCategory },
+	"description":     func(c *pb.CustomCost) string { return c.Description },
+	"resource_name":   func(c *pb.CustomCost) string { return c.ResourceName },
+	"resource_type":   func(c *pb.CustomCost) string { return c.ResourceType },
+	"provider_id":     func(c *pb.CustomCost) string { return c.ProviderId },
+	"usage_unit":      func(c *pb.CustomCost) string { return c.UsageUnit },
+	"account_id": func(c *pb.CustomCost) string {
+		return c.GetExtendedAttributes().GetAccountId()
+	},
+	"sub_account_id": func(c *pb.CustomCost) string {
+		return c.GetExtendedAttributes().GetSubAccountId()
+	},
+	"sub_account_name": func(c *pb.CustomCost) string {
+		return c.GetExtendedAttributes().GetSubAccountName()
+	},
+}
+
+// LabelsConfig is embedded in plugin configs to label costs, so they can be allocated in OpenCost.
+type LabelsConfig struct {
+	// LabelRules are applied to every cost in order. A label set by an earlier rule, or by the plugin itself,
+	// is never overwritten.
+	LabelRules []Rule `json:"label_rules"`
+}
+
+// Rule sets labels on the costs whose field matches. Exactly one of Exact, Prefix and Regex must be set.
+type Rule struct {
+	// Field is the cost attribute matched, such as "account_name", or a Metadata key such as "metadata.cluster_name"
+	Field  string `json:"field" required:"true"`
+	Exact  string `json:"exact"`
+	Prefix string `json:"prefix"`
+	// Regex must match the whole field. Label values may refer to its capture groups, such as "$1".
+	Regex string `json:"regex"`
+	// Labels are the labels set on matching costs
+	Labels map[string]string `json:"labels" required:"true"`
+}
+
+// rule is a validated Rule
+type rule struct {
+	value   func(*pb.CustomCost) string
+	matches func(string) bool
+	regex   *regexp.Regexp
+	labels  map[string]string
+}
+
+// Labeler applies label rules to costs. A nil *Labeler is valid, and leaves costs unlabeled.
+type Labeler struct {
+	rules []rule
+}
+
+// New validates the configured label rules, or returns nil if there are none.
+func New(config LabelsConfig) (*Labeler, error) {
+	if len(config.LabelRules) == 0 {
+		return nil, nil
+	}
+
+	var problems []string
+	labeler := &Labeler{}
+	for i, r := range config.LabelRules {
+		compiled, err := compile(r)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("label_rules[%d]: %v", i, err))
+			continue
+		}
+		labeler.rules = append(labeler.rules, compiled)
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid label rules:\n\t- %s", strings.Join(problems, "\n\t- "))
+	}
+
+	return labeler, nil
+}
+
+func compile(r Rule) (rule, error) {
+	compiled := rule{labels: r.Labels}
+
+	if key, ok := strings.CutPrefix(r.Field, metadataPrefix); ok && key != "" {
+		compiled.value = func(c *pb.CustomCost) string { return c.Metadata[key] }
+	} else if value, ok := fields[r.Field]; ok {
+		compiled.value = value
+	} else {
+		return rule{}, fmt.Errorf("field %q is not a cost field or a metadata.<key> reference", r.Field)
+	}
+
+	if len(r.Labels) == 0 {
+		return rule{}, fmt.Errorf("labels is required")
+	}
+
+	set := 0
+	for _, matcher := range []string{r.Exact, r.Prefix, r.Regex} {
+		if matcher != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return rule{}, fmt.Errorf("exactly one of exact, prefix and regex must be set")
+	}
+
+	switch {
+	case r.Exact != "":
+		compiled.matches = func(s string) bool { return s == r.Exact }
+	case r.Prefix != "":
+		compiled.matches = func(s string) bool { return strings.HasPrefix(s, r.Prefix) }
+	default:
+		regex, err := regexp.Compile("^(?:" + r.Regex + ")$")
+		if err != nil {
+			return rule{}, fmt.Errorf("invalid regex: %v", err)
+		}
+		compiled.regex = regex
+		compiled.matches = regex.MatchString
+	}
+
+	return compiled, nil
+}
+
+// Apply sets the labels of every matching rule on cost.
+func (l *Labeler) Apply(cost *pb.CustomCost) {
+	if l == nil || cost == nil {
+		return
+	}
+
+	for _, r := range l.rules {
+		value := r.value(cost)
+		if !r.matches(value) {
+			continue
+		}
+
+		if cost.Labels == nil {
+			cost.Labels = map[string]string{}
+		}
+		for key, label := range r.labels {
+			if _, ok := cost.Labels[key]; ok {
+				continue
+			}
+			if r.regex != nil {
+				label = expand(r.regex, label, value)
+			}
+			cost.Labels[key] = label
+		}
+	}
+}
+
+// expand replaces references to the regex's capture groups in a label value
+func expand(regex *regexp.Regexp, template, value string) string {
+	submatches := regex.FindStringSubmatchIndex(value)
+	return string(regex.ExpandString(nil, template, value, submatches))
+}
+
+// Source wraps a cost source so that label rules are applied to every cost it returns.
+func (l *Labeler) Source(src ocplugin.CustomCostSource) ocplugin.CustomCostSource {
+	if l == nil {
+		return src
+	}
+	return labelingSource{src: src, labeler: l}
+}
+
+type labelingSource struct {
+	src     ocplugin.CustomCostSource
+	labeler *Labeler
+}
+
+func (s labelingSource) GetCustomCosts(req *pb.CustomCostRequest) []*pb.CustomCostResponse {
+	responses := s.src.GetCustomCosts(req)
+	for _, resp := range responses {
+		for _, cost := range resp.Costs {
+			s.labeler.Apply(cost)
+		}
+	}
+	return responses
+}
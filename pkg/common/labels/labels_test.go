@@ -0,0 +1,88 @@
+package labels
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/opencost/opencost/core/pkg/model/pb"
+)
+
+func TestApply(t *testing.T) {
+	labeler, err := New(LabelsConfig{LabelRules: []Rule{
+		{Field: "account_name", Exact: "acme-prod", Labels: map[string]string{"env": "prod"}},
+		{Field: "resource_type", Prefix: "logs", Labels: map[string]string{"team": "observability"}},
+		{Field: "sub_account_name", Regex: `team-([a-z]+)-.*`, Labels: map[string]string{"team": "$1", "cost_center": "cc-$1"}},
+		{Field: "metadata.owner_name", Regex: `checkout-.*`, Labels: map[string]string{"team": "payments"}},
+		// a catch all, only applied where no earlier rule set the label
+		{Field: "account_name", Regex: `.*`, Labels: map[string]string{"team": "unallocated"}},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	projectName := "team-search-staging"
+	tests := []struct {
+		name string
+		cost *pb.CustomCost
+		want map[string]string
+	}{
+		{
+			"exact and prefix",
+			&pb.CustomCost{AccountName: "acme-prod", ResourceType: "logs_indexed"},
+			map[string]string{"env": "prod", "team": "observability"},
+		},
+		{
+			"regex capture groups",
+			&pb.CustomCost{AccountName: "acme", ExtendedAttributes: &pb.CustomCostExtendedAttributes{SubAccountName: &projectName}},
+			map[string]string{"team": "search", "cost_center": "cc-search"},
+		},
+		{
+			"metadata field",
+			&pb.CustomCost{Metadata: map[string]string{"owner_name": "checkout-api"}},
+			map[string]string{"team": "payments"},
+		},
+		{
+			"regex must match the whole field",
+			&pb.CustomCost{Metadata: map[string]string{"owner_name": "old-checkout-api"}},
+			map[string]string{"team": "unallocated"},
+		},
+		{
+			"plugin labels are kept",
+			&pb.CustomCost{AccountName: "acme-prod", Labels: map[string]string{"env": "dev"}},
+			map[string]string{"env": "dev", "team": "unallocated"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labeler.Apply(tt.cost)
+			if !reflect.DeepEqual(tt.cost.Labels, tt.want) {
+				t.Errorf("expected labels %v, got %v", tt.want, tt.cost.Labels)
+			}
+		})
+	}
+}
+
+func TestNewValidatesRules(t *testing.T) {
+	labeler, err := New(LabelsConfig{})
+	if err != nil || labeler != nil {
+		t.Errorf("expected no labeler without rules, got %v, %v", labeler, err)
+	}
+
+	_, err = New(LabelsConfig{LabelRules: []Rule{
+		{Field: "project", Exact: "a", Labels: map[string]string{"team": "a"}},
+		{Field: "account_name", Exact: "a", Prefix: "a", Labels: map[string]string{"team": "a"}},
+		{Field: "account_name", Regex: "(", Labels: map[string]string{"team": "a"}},
+		{Field: "account_name", Exact: "a"},
+	}})
+	if err == nil {
+		t.Fatalf("expected invalid rules to be rejected")
+	}
+	for i := 0; i < 4; i++ {
+		if !strings.Contains(err.Error(), fmt.Sprintf("label_rules[%d]", i)) {
+			t.Errorf("expected a problem reported for rule %d, got %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/opencost/opencost-plugins/common/costerror"
+	"github.com/opencost/opencost/core/pkg/log"
+	"github.com/opencost/opencost/core/pkg/model/pb"
+	ocplugin "github.com/opencost/opencost/core/pkg/plugin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/time/rate"
+)
+
+// namespace prefixes every metric. Each plugin runs as its own process, and its
+// metrics are labeled with the plugin name when they are served.
+const namespace = "opencost_plugin"
+
+// MetricsConfig is embedded in plugin configs to let the runtime serve the plugin's metrics.
+type MetricsConfig struct {
+	// MetricsAddr is the address, such as ":9091", that Prometheus metrics are served on at /metrics.
+	// Metrics are not served when unset.
+	MetricsAddr string `json:"metrics_addr"`
+}
+
+var (
+	getCustomCostsDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "get_custom_costs_duration_seconds",
+		Help:      "Time taken by each GetCustomCosts call.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 4, 8),
+	})
+	windowsServed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "windows_served_total",
+		Help:      "Windows returned from GetCustomCosts, by whether the window reported errors.",
+	}, []string{"result"})
+	costsEmitted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "costs_emitted_total",
+		Help:      "Cost line items returned from GetCustomCosts.",
+	})
+	responseErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "errors_total",
+		Help:      "Errors reported in GetCustomCosts responses, by kind.",
+	}, []string{"kind"})
+	upstreamRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_requests_total",
+		Help:      "Requests sent to upstream APIs, including retries, by host and status code.",
+	}, []string{"host", "code"})
+	upstreamRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Time taken by each request to an upstream API.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"host"})
+	upstreamRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_retries_total",
+		Help:      "Upstream requests retried after a failure or rate limited response, by host.",
+	}, []string{"host"})
+	rateLimitWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_wait_seconds",
+		Help:      "Time upstream requests were held by the plugin's rate limiter.",
+		Buckets:   []float64{0, 0.1, 0.5, 1, 5, 10, 30, 60, 120},
+	})
+)
+
+var (
+	// pluginCollectors holds the metrics plugins define for themselves
+	pluginCollectors []prometheus.Collector
+	pluginMu         sync.Mutex
+)
+
+// NewCounter defines a counter for plugin specific events, served alongside the shared metrics.
+// The name is prefixed with the shared namespace. Call it when initializing package variables.
+func NewCounter(name, help string) prometheus.Counter {
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Namespace: namespace, Name: name, Help: help})
+	addPluginCollector(counter)
+	return counter
+}
+
+// NewCounterVec is NewCounter for a counter partitioned by labels.
+func NewCounterVec(name, help string, labels ...string) *prometheus.CounterVec {
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: namespace, Name: name, Help: help}, labels)
+	addPluginCollector(counter)
+	return counter
+}
+
+func addPluginCollector(c prometheus.Collector) {
+	pluginMu.Lock()
+	defer pluginMu.Unlock()
+	pluginCollectors = append(pluginCollectors, c)
+}
+
+// Handler returns the /metrics handler for the named plugin. Every metric is labeled with the plugin name.
+func Handler(plugin string) http.Handler {
+	registry := prometheus.NewRegistry()
+	registerer := prometheus.WrapRegistererWith(prometheus.Labels{"plugin": plugin}, registry)
+	registerer.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		getCustomCostsDuration,
+		windowsServed,
+		costsEmitted,
+		responseErrors,
+		upstreamRequests,
+		upstreamRequestDuration,
+		upstreamRetries,
+		rateLimitWait,
+	)
+
+	pluginMu.Lock()
+	registerer.MustRegister(pluginCollectors...)
+	pluginMu.Unlock()
+
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Serve serves the named plugin's metrics on addr at /metrics in the background. It returns once
+// the address is bound, so a port that is already in use is reported before the plugin starts.
+func Serve(addr, plugin string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error serving metrics on %s: %v", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler(plugin))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("error serving metrics: %v", err)
+		}
+	}()
+
+	log.Infof("serving %s plugin metrics on %s/metrics", plugin, listener.Addr())
+	return nil
+}
+
+// ObserveUpstreamRequest records a single request to an upstream API. A nil response is a request
+// that failed without a status, such as a network error.
+func ObserveUpstreamRequest(host string, resp *http.Response, elapsed time.Duration) {
+	code := "error"
+	if resp != nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	upstreamRequests.WithLabelValues(host, code).Inc()
+	upstreamRequestDuration.WithLabelValues(host).Observe(elapsed.Seconds())
+}
+
+// ObserveRetry records that a request to an upstream API is about to be retried.
+func ObserveRetry(host string) {
+	upstreamRetries.WithLabelValues(host).Inc()
+}
+
+// WaitRateLimit waits on a plugin's rate limiter before an upstream request, recording how long it was held.
+func WaitRateLimit(ctx context.Context, limiter *rate.Limiter) error {
+	start := time.Now()
+	err := limiter.Wait(ctx)
+	rateLimitWait.Observe(time.Since(start).Seconds())
+	return err
+}
+
+// Instrument wraps a cost source so that every GetCustomCosts call is recorded.
+func Instrument(src ocplugin.CustomCostSource) ocplugin.CustomCostSource {
+	return instrumentedSource{src: src}
+}
+
+type instrumentedSource struct {
+	src ocplugin.CustomCostSource
+}
+
+func (s instrumentedSource) GetCustomCosts(req *pb.CustomCostRequest) []*pb.CustomCostResponse {
+	start := time.Now()
+	responses := s.src.GetCustomCosts(req)
+	getCustomCostsDuration.Observe(time.Since(start).Seconds())
+
+	for _, resp := range responses {
+		if len(resp.Errors) > 0 {
+			windowsServed.WithLabelValues("error").Inc()
+		} else {
+			windowsServed.WithLabelValues("ok").Inc()
+		}
+		costsEmitted.Add(float64(len(resp.Costs)))
+
+		kinds := costerror.Kinds(resp)
+		for i := range resp.Errors {
+			kind := "unknown"
+			if i < len(kinds) {
+				kind = string(kinds[i])
+			}
+			responseErrors.WithLabelValues(kind).Inc()
+		}
+	}
+
+	return responses
+}
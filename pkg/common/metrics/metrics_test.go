@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencost/opencost-plugins/common/costerror"
+	"github.com/opencost/opencost/core/pkg/model/pb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"golang.org/x/time/rate"
+)
+
+var testEvents = NewCounter("test_events_total", "Events counted by the metrics tests.")
+
+type testSource struct {
+	responses []*pb.CustomCostResponse
+}
+
+func (s testSource) GetCustomCosts(req *pb.CustomCostRequest) []*pb.CustomCostResponse {
+	return s.responses
+}
+
+func TestInstrumentRecordsResponses(t *testing.T) {
+	okBefore := testutil.ToFloat64(windowsServed.WithLabelValues("ok"))
+	errBefore := testutil.ToFloat64(windowsServed.WithLabelValues("error"))
+	costsBefore := testutil.ToFloat64(costsEmitted)
+	rateLimitedBefore := testutil.ToFloat64(responseErrors.WithLabelValues("rate_limited"))
+	unknownBefore := testutil.ToFloat64(responseErrors.WithLabelValues("unknown"))
+
+	src := Instrument(testSource{responses: []*pb.CustomCostResponse{
+		{Costs: []*pb.CustomCost{{}, {}}},
+		{Costs: []*pb.CustomCost{{}}},
+		{Errors: []string{"rate_limited: too many requests"}, Metadata: map[string]string{costerror.MetadataKey: "rate_limited"}},
+		{Errors: []string{"free-form error"}},
+	}})
+	src.GetCustomCosts(&pb.CustomCostRequest{})
+
+	if got := testutil.ToFloat64(windowsServed.WithLabelValues("ok")) - okBefore; got != 2 {
+		t.Errorf("expected 2 windows served without errors, got %v", got)
+	}
+	if got := testutil.ToFloat64(windowsServed.WithLabelValues("error")) - errBefore; got != 2 {
+		t.Errorf("expected 2 windows served with errors, got %v", got)
+	}
+	if got := testutil.ToFloat64(costsEmitted) - costsBefore; got != 3 {
+		t.Errorf("expected 3 costs emitted, got %v", got)
+	}
+	if got := testutil.ToFloat64(responseErrors.WithLabelValues("rate_limited")) - rateLimitedBefore; got != 1 {
+		t.Errorf("expected 1 rate limited error, got %v", got)
+	}
+	if got := testutil.ToFloat64(responseErrors.WithLabelValues("unknown")) - unknownBefore; got != 1 {
+		t.Errorf("expected 1 error without a kind, got %v", got)
+	}
+}
+
+func TestHandlerLabelsMetricsWithPlugin(t *testing.T) {
+	testEvents.Inc()
+	ObserveUpstreamRequest("api.example.com", &http.Response{StatusCode: 429}, 10*time.Millisecond)
+	ObserveUpstreamRequest("api.example.com", nil, time.Second)
+	ObserveRetry("api.example.com")
+
+	server := httptest.NewServer(Handler("example"))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("error scraping metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	for _, want := range []string{
+		`opencost_plugin_test_events_total{plugin="example"} 1`,
+		`opencost_plugin_upstream_requests_total{code="429",host="api.example.com",plugin="example"} 1`,
+		`opencost_plugin_upstream_requests_total{code="error",host="api.example.com",plugin="example"} 1`,
+		`opencost_plugin_upstream_retries_total{host="api.example.com",plugin="example"} 1`,
+		`opencost_plugin_upstream_request_duration_seconds_count{host="api.example.com",plugin="example"} 2`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("expected metrics to contain %s", want)
+		}
+	}
+}
+
+func TestWaitRateLimitRecordsWait(t *testing.T) {
+	limiter := rate.NewLimiter(rate.Every(20*time.Millisecond), 1)
+	for i := 0; i < 2; i++ {
+		if err := WaitRateLimit(context.Background(), limiter); err != nil {
+			t.Fatalf("unexpected error waiting on the rate limiter: %v", err)
+		}
+	}
+
+	var m dto.Metric
+	if err := rateLimitWait.Write(&m); err != nil {
+		t.Fatalf("error reading rate limit waits: %v", err)
+	}
+	if count := m.GetHistogram().GetSampleCount(); count != 2 {
+		t.Errorf("expected 2 rate limit waits to be recorded, got %d", count)
+	}
+	// the second request waited for the limiter to refill
+	if sum := m.GetHistogram().GetSampleSum(); sum < 0.01 {
+		t.Errorf("expected the second wait to be recorded, waited %vs in total", sum)
+	}
+}
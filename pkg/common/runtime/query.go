@@ -0,0 +1,238 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/opencost/opencost/core/pkg/model/pb"
+	ocplugin "github.com/opencost/opencost/core/pkg/plugin"
+	"github.com/opencost/opencost/core/pkg/util/timeutil"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const queryDateFormat = "2006-01-02"
+
+// queryArgs are the flags of the query subcommand
+type queryArgs struct {
+	start      timeFlag
+	end        timeFlag
+	resolution durationFlag
+	output     string
+}
+
+// newQueryArgs registers the query flags. By default, yesterday is queried at daily resolution.
+func newQueryArgs(flags *flag.FlagSet) *queryArgs {
+	today := time.Now().UTC().Truncate(timeutil.Day)
+	args := &queryArgs{
+		start:      timeFlag(today.Add(-timeutil.Day)),
+		end:        timeFlag(today),
+		resolution: durationFlag(timeutil.Day),
+	}
+
+	flags.Var(&args.start, "start", "start of the queried range, as RFC3339 or YYYY-MM-DD (UTC). defaults to the start of yesterday")
+	flags.Var(&args.end, "end", "end of the queried range, as RFC3339 or YYYY-MM-DD (UTC). defaults to the start of today")
+	flags.Var(&args.resolution, "resolution", "window size, such as 1h or 1d. defaults to 1d")
+	flags.StringVar(&args.output, "output", "table", "output format: json, table or csv")
+	return args
+}
+
+func (q queryArgs) validate() error {
+	if !time.Time(q.start).Before(time.Time(q.end)) {
+		return fmt.Errorf("--start must be before --end")
+	}
+	if q.resolution <= 0 {
+		return fmt.Errorf("--resolution must be positive")
+	}
+
+	switch q.output {
+	case "json", "table", "csv":
+		return nil
+	default:
+		return fmt.Errorf("--output must be one of [json, table, csv], got %q", q.output)
+	}
+}
+
+func (q queryArgs) request() *pb.CustomCostRequest {
+	return &pb.CustomCostRequest{
+		Start:      timestamppb.New(time.Time(q.start)),
+		End:        timestamppb.New(time.Time(q.end)),
+		Resolution: durationpb.New(time.Duration(q.resolution)),
+	}
+}
+
+// runQuery calls the cost source directly, without the go-plugin handshake, prints its responses
+// to stdout and returns the exit code. Any errors in the responses fail the command.
+func (p Plugin) runQuery(src ocplugin.CustomCostSource, q queryArgs, stdout, stderr io.Writer) int {
+	responses := src.GetCustomCosts(q.request())
+
+	var err error
+	switch q.output {
+	case "json":
+		err = writeJSON(stdout, responses)
+	case "csv":
+		err = writeCSV(stdout, responses)
+	default:
+		err = writeTable(stdout, responses)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: error writing query output: %v\n", p.Name, err)
+		return 1
+	}
+
+	failed := false
+	for _, resp := range responses {
+		for _, respErr := range resp.Errors {
+			failed = true
+			// json output already carries the errors, so only repeat them for the tabular formats
+			if q.output != "json" {
+				fmt.Fprintf(stderr, "%s: error for window %s - %s: %s\n", p.Name, formatTimestamp(resp.Start), formatTimestamp(resp.End), respErr)
+			}
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// writeJSON writes the responses as an array of protojson objects, the format read by plugin validators
+func writeJSON(w io.Writer, responses []*pb.CustomCostResponse) error {
+	raw := make([]json.RawMessage, len(responses))
+	for i, resp := range responses {
+		r, err := protojson.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		raw[i] = r
+	}
+
+	out, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, out, "", "  "); err != nil {
+		return err
+	}
+	indented.WriteByte('\n')
+	_, err = indented.WriteTo(w)
+	return err
+}
+
+// queryColumns are the columns of the table and csv outputs, one row per cost
+var queryColumns = []string{
+	"window_start", "window_end", "domain", "currency", "account_name", "provider_id", "resource_type", "resource_name",
+	"charge_category", "usage_quantity", "usage_unit", "list_unit_price", "list_cost", "billed_cost",
+}
+
+func costRows(responses []*pb.CustomCostResponse) [][]string {
+	var rows [][]string
+	for _, resp := range responses {
+		for _, cost := range resp.Costs {
+			rows = append(rows, []string{
+				formatTimestamp(resp.Start),
+				formatTimestamp(resp.End),
+				resp.Domain,
+				resp.Currency,
+				cost.AccountName,
+				cost.ProviderId,
+				cost.ResourceType,
+				cost.ResourceName,
+				cost.ChargeCategory,
+				formatFloat(cost.UsageQuantity),
+				cost.UsageUnit,
+				formatFloat(cost.ListUnitPrice),
+				formatFloat(cost.ListCost),
+				formatFloat(cost.BilledCost),
+			})
+		}
+	}
+	return rows
+}
+
+func writeCSV(w io.Writer, responses []*pb.CustomCostResponse) error {
+	csvWriter := csv.NewWriter(w)
+	if err := csvWriter.Write(queryColumns); err != nil {
+		return err
+	}
+	if err := csvWriter.WriteAll(costRows(responses)); err != nil {
+		return err
+	}
+	return csvWriter.Error()
+}
+
+func writeTable(w io.Writer, responses []*pb.CustomCostResponse) error {
+	rows := costRows(responses)
+	if len(rows) == 0 {
+		_, err := fmt.Fprintf(w, "no costs in %d window(s)\n", len(responses))
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, row := range append([][]string{queryColumns}, rows...) {
+		for i, cell := range row {
+			if i > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			fmt.Fprint(tw, cell)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+func formatTimestamp(ts *timestamppb.Timestamp) string {
+	if ts == nil {
+		return ""
+	}
+	return ts.AsTime().UTC().Format(time.RFC3339)
+}
+
+func formatFloat(f float32) string {
+	return strconv.FormatFloat(float64(f), 'f', -1, 32)
+}
+
+// timeFlag is a flag.Value accepting RFC3339 timestamps or dates
+type timeFlag time.Time
+
+func (t *timeFlag) String() string {
+	return time.Time(*t).Format(time.RFC3339)
+}
+
+func (t *timeFlag) Set(s string) error {
+	parsed, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		parsed, err = time.Parse(queryDateFormat, s)
+		if err != nil {
+			return fmt.Errorf("expected an RFC3339 time or a YYYY-MM-DD date, got %q", s)
+		}
+	}
+	*t = timeFlag(parsed.UTC())
+	return nil
+}
+
+// durationFlag is a flag.Value accepting durations with a day unit, such as 1d
+type durationFlag time.Duration
+
+func (d *durationFlag) String() string {
+	return timeutil.DurationString(time.Duration(*d))
+}
+
+func (d *durationFlag) Set(s string) error {
+	parsed, err := timeutil.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = durationFlag(parsed)
+	return nil
+}
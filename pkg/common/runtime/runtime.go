@@ -0,0 +1,316 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-plugin"
+	"github.com/opencost/opencost-plugins/common/accounts"
+	commonconfig "github.com/opencost/opencost-plugins/common/config"
+	"github.com/opencost/opencost-plugins/common/currency"
+	"github.com/opencost/opencost-plugins/common/labels"
+	"github.com/opencost/opencost-plugins/common/metrics"
+	"github.com/opencost/opencost/core/pkg/log"
+	ocplugin "github.com/opencost/opencost/core/pkg/plugin"
+)
+
+const defaultLogLevel = "info"
+const defaultLogLevelKey = "log_level"
+
+// checkTimeout bounds the preflight check, so a hung API can't keep a plugin from starting or failing
+const checkTimeout = time.Minute
+
+// NewSourceFunc builds a plugin's CustomCostSource from the raw contents of its config file.
+type NewSourceFunc func(configBytes []byte) (ocplugin.CustomCostSource, error)
+
+// Checker is implemented by cost sources that can verify their credentials before serving.
+// Check should make the cheapest authenticated request(s) that exercise every permission the
+// source needs, and return an error naming the permission that is missing. Independent
+// failures may be combined with errors.Join, in which case each is reported on its own line.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// Plugin describes a plugin binary to the shared runtime.
+type Plugin struct {
+	// Name is the plugin name. It must match the plugin's directory and manifest entry,
+	// as it is used as the handshake magic cookie value.
+	Name string
+	// LogLevelKey is the config file key holding the plugin log level. Defaults to "log_level".
+	LogLevelKey string
+	// NewSource builds the plugin's cost source from its config file.
+	NewSource NewSourceFunc
+}
+
+// Serve loads the plugin config, configures logging, runs the cost source's preflight check and
+// serves the CustomCostSource built by newSource over go-plugin. It does not return.
+func Serve(name string, newSource NewSourceFunc) {
+	Plugin{Name: name, NewSource: newSource}.Serve()
+}
+
+// Serve loads the plugin config, configures logging, runs the cost source's preflight check and
+// serves the plugin's CustomCostSource over go-plugin. It does not return.
+//
+// When run as `<plugin> --check <config file>`, the plugin runs its preflight check, reports
+// the result and exits instead of serving. When run as `<plugin> query ... <config file>`, the
+// plugin calls its own GetCustomCosts once, prints the responses and exits.
+func (p Plugin) Serve() {
+	log.Debugf("initializing %s plugin", p.Name)
+
+	args, err := parseArgs(p.Name, os.Args[1:])
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	loaded, err := p.load(args.configFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if args.check {
+		os.Exit(p.runCheck(loaded.src, os.Stdout, os.Stderr))
+	}
+
+	if args.query != nil {
+		os.Exit(p.runQuery(loaded.served, *args.query, os.Stdout, os.Stderr))
+	}
+
+	// fail fast on bad credentials, rather than burying the problem in the errors of every query.
+	// a plugin with several accounts still serves the ones that passed, reporting the others in every window
+	var accountsErr *accounts.CheckError
+	if err := p.check(loaded.src); errors.As(err, &accountsErr) && accountsErr.Partial() {
+		log.Warnf("%s plugin preflight check failed for %d of %d accounts, serving the others: %v", p.Name, accountsErr.Failed, accountsErr.Total, err)
+	} else if err != nil {
+		log.Fatalf("%s plugin preflight check failed: %v", p.Name, err)
+	}
+
+	if loaded.config.MetricsAddr != "" {
+		if err := metrics.Serve(loaded.config.MetricsAddr, p.Name); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: HandshakeConfig(p.Name),
+		Plugins:         PluginMap(metrics.Instrument(loaded.served)),
+		GRPCServer:      plugin.DefaultGRPCServer,
+	})
+}
+
+// usage documents the command line of every plugin binary
+const usage = `usage: %[1]s [--check] <config file>
+       %[1]s query [--start <time>] [--end <time>] [--resolution <duration>] [--output json|table|csv] <config file>`
+
+// pluginArgs is the parsed command line of a plugin binary
+type pluginArgs struct {
+	configFile string
+	check      bool
+	// query is set when the plugin was run with the query subcommand
+	query *queryArgs
+}
+
+// parseArgs parses the plugin command line. All config for the plugin must come through the config file.
+func parseArgs(name string, argv []string) (pluginArgs, error) {
+	var result pluginArgs
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	if len(argv) > 0 && argv[0] == "query" {
+		result.query = newQueryArgs(flags)
+		argv = argv[1:]
+	} else {
+		flags.BoolVar(&result.check, "check", false, "verify the configured credentials and exit")
+	}
+
+	positional, err := parseInterspersed(flags, argv)
+	if err != nil {
+		return result, fmt.Errorf(usage+"\n%[2]v", name, err)
+	}
+
+	if len(positional) != 1 {
+		return result, fmt.Errorf(usage+"\nexpected the full path to a config file, got %[2]d args", name, len(positional))
+	}
+	result.configFile = positional[0]
+
+	if _, err := os.Stat(result.configFile); err != nil {
+		return result, fmt.Errorf("error opening config file: error reading config file at %s: %v", result.configFile, err)
+	}
+
+	if result.query != nil {
+		if err := result.query.validate(); err != nil {
+			return result, fmt.Errorf(usage+"\n%[2]v", name, err)
+		}
+	}
+
+	return result, nil
+}
+
+// parseInterspersed parses flags wherever they appear, returning the positional args in order
+func parseInterspersed(flags *flag.FlagSet, argv []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(argv); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		argv = flags.Args()[1:]
+	}
+}
+
+// runtimeConfig is the part of every plugin config that the runtime acts on itself. Plugins embed
+// each of these in their own config, so the keys have been validated by the time it is read.
+type runtimeConfig struct {
+	metrics.MetricsConfig
+	currency.CurrencyConfig
+	labels.LabelsConfig
+}
+
+// loadedPlugin is a plugin's cost source along with the runtime config read from its config file
+type loadedPlugin struct {
+	// src is the plugin's own cost source, which the preflight check runs against
+	src ocplugin.CustomCostSource
+	// served wraps src with the runtime's own processing of responses, such as labeling and currency conversion
+	served ocplugin.CustomCostSource
+	config runtimeConfig
+}
+
+// load reads the config file, resolves its secrets, sets the log level and builds the cost source.
+func (p Plugin) load(configFile string) (loadedPlugin, error) {
+	// secret references are resolved before the plugin ever sees its config
+	configBytes, err := commonconfig.ReadConfigFile(configFile)
+	if err != nil {
+		return loadedPlugin{}, fmt.Errorf("error loading %s config: %v", p.Name, err)
+	}
+
+	logLevel, err := p.logLevel(configBytes)
+	if err != nil {
+		return loadedPlugin{}, fmt.Errorf("error building %s config: %v", p.Name, err)
+	}
+	if err := log.SetLogLevel(logLevel); err != nil {
+		// an invalid level is reported along with any other config problems when the source is built
+		log.Warnf("invalid log level %q, using %s: %v", logLevel, defaultLogLevel, err)
+		log.SetLogLevel(defaultLogLevel)
+	}
+
+	src, err := p.NewSource(configBytes)
+	if err != nil {
+		return loadedPlugin{}, fmt.Errorf("error building %s cost source: %v", p.Name, err)
+	}
+
+	var config runtimeConfig
+	if err := json.Unmarshal(configBytes, &config); err != nil {
+		return loadedPlugin{}, fmt.Errorf("error building %s config: %v", p.Name, err)
+	}
+
+	converter, err := currency.New(config.CurrencyConfig)
+	if err != nil {
+		return loadedPlugin{}, fmt.Errorf("error building %s config: %v", p.Name, err)
+	}
+
+	labeler, err := labels.New(config.LabelsConfig)
+	if err != nil {
+		return loadedPlugin{}, fmt.Errorf("error building %s config: %v", p.Name, err)
+	}
+
+	return loadedPlugin{src: src, served: labeler.Source(converter.Source(src)), config: config}, nil
+}
+
+// check runs the cost source's preflight check, if it has one
+func (p Plugin) check(src ocplugin.CustomCostSource) error {
+	checker, ok := src.(Checker)
+	if !ok {
+		log.Debugf("%s plugin has no preflight check", p.Name)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	log.Debugf("running %s plugin preflight check", p.Name)
+	return checker.Check(ctx)
+}
+
+// runCheck runs the preflight check for --check mode, reporting every problem it finds, and returns the exit code
+func (p Plugin) runCheck(src ocplugin.CustomCostSource, stdout, stderr io.Writer) int {
+	if _, ok := src.(Checker); !ok {
+		fmt.Fprintf(stdout, "%s: config is valid. this plugin has no credential check\n", p.Name)
+		return 0
+	}
+
+	err := p.check(src)
+	if err == nil {
+		fmt.Fprintf(stdout, "%s: config is valid and credentials have every required permission\n", p.Name)
+		return 0
+	}
+
+	fmt.Fprintf(stderr, "%s: credential check failed:\n", p.Name)
+	for _, line := range strings.Split(err.Error(), "\n") {
+		fmt.Fprintf(stderr, "\t- %s\n", line)
+	}
+	return 1
+}
+
+// logLevel pulls the log level out of the config, defaulting to info
+func (p Plugin) logLevel(configBytes []byte) (string, error) {
+	key := p.LogLevelKey
+	if key == "" {
+		key = defaultLogLevelKey
+	}
+
+	level, err := stringField(configBytes, key)
+	if err != nil {
+		return "", err
+	}
+
+	if level == "" {
+		level = defaultLogLevel
+	}
+
+	return level, nil
+}
+
+// stringField pulls a single string out of the config, or returns "" if it is not set
+func stringField(configBytes []byte, key string) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(configBytes, &fields); err != nil {
+		return "", fmt.Errorf("error marshaling json into config %v", err)
+	}
+
+	var value string
+	if raw, ok := fields[key]; ok {
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return "", fmt.Errorf("%s must be a string: %v", key, err)
+		}
+	}
+
+	return value, nil
+}
+
+// HandshakeConfig returns the config used to do a basic handshake between
+// a plugin and host. If the handshake fails, a user friendly error is shown.
+// This prevents users from executing bad plugins or executing a plugin
+// directory. It is a UX feature, not a security feature.
+func HandshakeConfig(name string) plugin.HandshakeConfig {
+	return plugin.HandshakeConfig{
+		ProtocolVersion:  1,
+		MagicCookieKey:   "PLUGIN_NAME",
+		MagicCookieValue: name,
+	}
+}
+
+// PluginMap returns the map of plugins we can dispense.
+func PluginMap(src ocplugin.CustomCostSource) map[string]plugin.Plugin {
+	return map[string]plugin.Plugin{
+		"CustomCostSource": &ocplugin.CustomCostPlugin{Impl: src},
+	}
+}
@@ -0,0 +1,194 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencost/opencost/core/pkg/model/pb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type testSource struct {
+	responses []*pb.CustomCostResponse
+}
+
+func (s *testSource) GetCustomCosts(req *pb.CustomCostRequest) []*pb.CustomCostResponse {
+	return s.responses
+}
+
+type checkedSource struct {
+	testSource
+	err error
+}
+
+func (s *checkedSource) Check(ctx context.Context) error {
+	return s.err
+}
+
+func TestParseArgs(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configFile, []byte(`{}`), 0600); err != nil {
+		t.Fatalf("error writing config file: %v", err)
+	}
+
+	args, err := parseArgs("test", []string{configFile})
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if args.configFile != configFile || args.check {
+		t.Errorf("unexpected args: %+v", args)
+	}
+
+	args, err = parseArgs("test", []string{"--check", configFile})
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if args.configFile != configFile || !args.check {
+		t.Errorf("unexpected args: %+v", args)
+	}
+
+	for _, argv := range [][]string{
+		{},
+		{"--check"},
+		{"--verbose", configFile},
+		{configFile, configFile},
+		{filepath.Join(t.TempDir(), "missing.json")},
+		{"query", "--output", "xml", configFile},
+		{"query", "--start", "2024-10-17", "--end", "2024-10-16", configFile},
+		{"query", "--resolution", "daily", configFile},
+		{"query", "--check", configFile},
+	} {
+		if _, err := parseArgs("test", argv); err == nil {
+			t.Errorf("expected an error for args %v, but got none", argv)
+		}
+	}
+}
+
+func TestRunCheck(t *testing.T) {
+	p := Plugin{Name: "test"}
+
+	var stdout, stderr bytes.Buffer
+	if code := p.runCheck(&testSource{}, &stdout, &stderr); code != 0 {
+		t.Errorf("expected sources without a check to pass, got exit code %d", code)
+	}
+
+	stdout.Reset()
+	if code := p.runCheck(&checkedSource{}, &stdout, &stderr); code != 0 {
+		t.Errorf("expected a passing check to exit 0, got %d", code)
+	}
+	if !strings.Contains(stdout.String(), "credentials have every required permission") {
+		t.Errorf("unexpected output: %s", stdout.String())
+	}
+
+	src := &checkedSource{err: errors.Join(
+		errors.New("api key is invalid"),
+		errors.New("application key is missing the usage_read scope"),
+	)}
+	if code := p.runCheck(src, &stdout, &stderr); code != 1 {
+		t.Errorf("expected a failing check to exit 1, got %d", code)
+	}
+	for _, expected := range []string{"\t- api key is invalid\n", "\t- application key is missing the usage_read scope\n"} {
+		if !strings.Contains(stderr.String(), expected) {
+			t.Errorf("expected output to contain %q, got: %s", expected, stderr.String())
+		}
+	}
+}
+
+func TestParseQueryArgs(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configFile, []byte(`{}`), 0600); err != nil {
+		t.Fatalf("error writing config file: %v", err)
+	}
+
+	// flags may come before or after the config file
+	args, err := parseArgs("test", []string{"query", "--start", "2024-10-16", configFile, "--end", "2024-10-17T06:00:00Z", "--resolution", "1h", "--output", "csv"})
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	if args.configFile != configFile || args.query == nil {
+		t.Fatalf("unexpected args: %+v", args)
+	}
+
+	req := args.query.request()
+	if !req.Start.AsTime().Equal(time.Date(2024, 10, 16, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected start: %v", req.Start.AsTime())
+	}
+	if !req.End.AsTime().Equal(time.Date(2024, 10, 17, 6, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected end: %v", req.End.AsTime())
+	}
+	if req.Resolution.AsDuration() != time.Hour || args.query.output != "csv" {
+		t.Errorf("unexpected query args: %+v", args.query)
+	}
+
+	// defaults to yesterday at daily resolution
+	args, err = parseArgs("test", []string{"query", configFile})
+	if err != nil {
+		t.Fatalf("expected no error, but got: %v", err)
+	}
+	req = args.query.request()
+	if req.End.AsTime().Sub(req.Start.AsTime()) != 24*time.Hour || req.Resolution.AsDuration() != 24*time.Hour {
+		t.Errorf("unexpected default request: %v", req)
+	}
+	if args.query.output != "table" {
+		t.Errorf("expected output to default to table, got %s", args.query.output)
+	}
+}
+
+func TestRunQuery(t *testing.T) {
+	p := Plugin{Name: "test"}
+	start := time.Date(2024, 10, 16, 0, 0, 0, 0, time.UTC)
+	src := &testSource{responses: []*pb.CustomCostResponse{
+		{
+			Domain:   "datadog",
+			Currency: "USD",
+			Start:    timestamppb.New(start),
+			End:      timestamppb.New(start.Add(24 * time.Hour)),
+			Costs: []*pb.CustomCost{
+				{AccountName: "prod", ProviderId: "abc/logs", ResourceName: "logs, indexed", UsageQuantity: 1.5, BilledCost: 0.25},
+			},
+		},
+		{
+			Domain: "datadog",
+			Start:  timestamppb.New(start.Add(24 * time.Hour)),
+			End:    timestamppb.New(start.Add(48 * time.Hour)),
+			Errors: []string{"rate limited"},
+		},
+	}}
+
+	var stdout, stderr bytes.Buffer
+	code := p.runQuery(src, queryArgs{output: "csv"}, &stdout, &stderr)
+	if code != 1 {
+		t.Errorf("expected responses with errors to exit 1, got %d", code)
+	}
+
+	expected := "window_start,window_end,domain,currency,account_name,provider_id,resource_type,resource_name,charge_category,usage_quantity,usage_unit,list_unit_price,list_cost,billed_cost\n" +
+		"2024-10-16T00:00:00Z,2024-10-17T00:00:00Z,datadog,USD,prod,abc/logs,,\"logs, indexed\",,1.5,,0,0,0.25\n"
+	if stdout.String() != expected {
+		t.Errorf("unexpected csv output:\n%s\nexpected:\n%s", stdout.String(), expected)
+	}
+	if !strings.Contains(stderr.String(), "error for window 2024-10-17T00:00:00Z - 2024-10-18T00:00:00Z: rate limited") {
+		t.Errorf("expected window errors on stderr, got: %s", stderr.String())
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	p.runQuery(src, queryArgs{output: "json"}, &stdout, &stderr)
+	if !strings.Contains(stdout.String(), `"providerId": "abc/logs"`) || !strings.Contains(stdout.String(), `"rate limited"`) {
+		t.Errorf("unexpected json output: %s", stdout.String())
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("expected json errors to stay in the output, got: %s", stderr.String())
+	}
+
+	stdout.Reset()
+	p.runQuery(src, queryArgs{output: "table"}, &stdout, &stderr)
+	if !strings.Contains(stdout.String(), "window_start") || !strings.Contains(stdout.String(), "logs, indexed") {
+		t.Errorf("unexpected table output: %s", stdout.String())
+	}
+}
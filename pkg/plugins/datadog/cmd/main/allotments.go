@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/opencost/opencost-plugins/common/costid"
+	"github.com/opencost/opencost-plugins/common/decimal"
+	datadogplugin "github.com/opencost/opencost-plugins/pkg/plugins/datadog/datadogplugin"
+	"github.com/opencost/opencost/core/pkg/log"
+	"github.com/opencost/opencost/core/pkg/model/pb"
+	"github.com/opencost/opencost/core/pkg/opencost"
+	"google.golang.org/protobuf/proto"
+)
+
+// allotment is free usage of a product that DD bundles with each unit of another product, such as the
+// containers included with each infra host. Usage beyond the allotment is billed as overage.
+type allotment struct {
+	// product is the product the allotment covers
+	product string
+	// perProduct is the product each unit of which includes perUnit units of product
+	perProduct string
+	perUnit    decimal.Decimal
+	// overagePrice is the product whose unit price usage beyond the allotment is billed at
+	overagePrice string
+}
+
+// builtinAllotments are the allotments of DD's Pro plans, as per https://www.datadoghq.com/pricing/allotments/.
+// Usage is reported hourly, so each allotment is per unit of hourly usage.
+var builtinAllotments = []allotment{
+	// the first 200 normalized queries per DBM host are free
+	{product: "dbm_queries", perProduct: "dbm_host", perUnit: decimal.NewFromInt(200), overagePrice: "dbm_queries"},
+	{product: "infra_container", perProduct: "infra_host", perUnit: decimal.NewFromInt(5), overagePrice: "infra_container"},
+	{product: "timeseries", perProduct: "infra_host", perUnit: decimal.NewFromInt(100), overagePrice: "timeseries"},
+	// each APM host includes 1M indexed spans a month
+	{product: "indexed_spans", perProduct: "apm_host", perUnit: decimal.NewFromInt(1000000).Div(decimal.NewFromInt(hoursPerMonth)), overagePrice: "indexed_spans"},
+	{product: "prof_container", perProduct: "prof_host", perUnit: decimal.NewFromInt(4), overagePrice: "prof_container"},
+}
+
+// newAllotments returns the built-in allotments, with the configured ones in place of those for the same
+// product. A configured allotment of zero units removes the product's allotment.
+func newAllotments(configured []datadogplugin.DatadogAllotment) []allotment {
+	byProduct := map[string]datadogplugin.DatadogAllotment{}
+	var added []string
+	for _, c := range configured {
+		if _, found := byProduct[c.Product]; !found {
+			added = append(added, c.Product)
+		}
+		byProduct[c.Product] = c
+	}
+
+	result := []allotment{}
+	for _, builtin := range builtinAllotments {
+		if _, found := byProduct[builtin.product]; !found {
+			result = append(result, builtin)
+		}
+	}
+	for _, product := range added {
+		c := byProduct[product]
+		if c.PerUnit == 0 {
+			continue
+		}
+		overagePrice := c.OveragePrice
+		if overagePrice == "" {
+			overagePrice = c.Product
+		}
+		result = append(result, allotment{product: c.Product, perProduct: c.PerProduct, perUnit: decimal.NewFromFloat(c.PerUnit), overagePrice: overagePrice})
+	}
+	return result
+}
+
+// allotted reports whether any allotment covers a product
+func allotted(rules []allotment, product string) bool {
+	for _, rule := range rules {
+		if rule.product == product {
+			return true
+		}
+	}
+	return false
+}
+
+// applyAllotments bills the usage each allotment covers beyond the allotment included with the org's usage
+// of another product as a separate overage line. The included usage is free, so it is left out rather than
+// reported at no cost. It returns the usage whose overage has no price, as product_family/usage_type, which
+// is left out too.
+func applyAllotments(window opencost.Window, lines []*costLine, rules []allotment, mapping usagePriceMap, prices map[string]billableCost) ([]*costLine, []string) {
+	var unpriced []string
+	for _, rule := range rules {
+		included := decimal.Zero
+		for _, line := range lines {
+			if product, _ := mapping.billedProduct(line.cost.ResourceType, line.cost.ResourceName); product == rule.perProduct {
+				included = included.Add(line.quantity.Mul(rule.perUnit))
+			}
+		}
+
+		kept := []*costLine{}
+		var overages []*costLine
+		for _, line := range lines {
+			cost := line.cost
+			if product, _ := mapping.billedProduct(cost.ResourceType, cost.ResourceName); product != rule.product {
+				kept = append(kept, line)
+				continue
+			}
+
+			// the allotment is used up by the product's usage types in turn
+			usage := line.quantity
+			free := usage.Min(included)
+			included = included.Sub(free)
+			overage := usage.Sub(free)
+			log.Debugf("%s usage of %s is %s included with %s and %s overage", cost.ProviderId, usage, free, rule.perProduct, overage)
+			if overage.Sign() <= 0 {
+				continue
+			}
+
+			price, found := prices[rule.overagePrice]
+			if !found {
+				unpriced = append(unpriced, cost.ResourceType+"/"+cost.ResourceName)
+				continue
+			}
+			overageCost := proto.Clone(cost).(*pb.CustomCost)
+			overageCost.ProviderId = cost.ProviderId + "/overage"
+			overageCost.Id = costid.New("datadog", orgPublicID(cost), *window.Start(), *window.End(), overageCost.ProviderId)
+			overageCost.Description = fmt.Sprintf("Datadog %s usage beyond the allotment included with %s", cost.ResourceName, rule.perProduct)
+			if price.unit != "" {
+				overageCost.UsageUnit = price.unit
+			}
+			overages = append(overages, &costLine{cost: overageCost, quantity: overage, billed: price.Cost.Mul(overage)})
+		}
+		lines = append(kept, overages...)
+	}
+	return lines, unpriced
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV1"
+	"github.com/opencost/opencost-plugins/common/costerror"
+	"github.com/opencost/opencost-plugins/common/costid"
+	"github.com/opencost/opencost-plugins/common/decimal"
+	"github.com/opencost/opencost-plugins/common/metrics"
+	"github.com/opencost/opencost/core/pkg/log"
+	"github.com/opencost/opencost/core/pkg/model/pb"
+	"github.com/opencost/opencost/core/pkg/opencost"
+	"google.golang.org/protobuf/proto"
+)
+
+// attributionUsageTypes maps the hourly usage types whose usage attribution type can't be derived from their name
+var attributionUsageTypes = map[string]datadogV1.HourlyUsageAttributionUsageType{
+	"host_count":                       datadogV1.HOURLYUSAGEATTRIBUTIONUSAGETYPE_INFRA_HOST_USAGE,
+	"infra_host_count":                 datadogV1.HOURLYUSAGEATTRIBUTIONUSAGETYPE_INFRA_HOST_USAGE,
+	"dbm_host_count":                   datadogV1.HOURLYUSAGEATTRIBUTIONUSAGETYPE_DBM_HOSTS_USAGE,
+	"ingested_events_bytes":            datadogV1.HOURLYUSAGEATTRIBUTIONUSAGETYPE_INGESTED_LOGS_BYTES_USAGE,
+	"logs_indexed_events_3_day_count":  datadogV1.HOURLYUSAGEATTRIBUTIONUSAGETYPE_LOGS_INDEXED_3DAY_USAGE,
+	"logs_indexed_events_7_day_count":  datadogV1.HOURLYUSAGEATTRIBUTIONUSAGETYPE_LOGS_INDEXED_7DAY_USAGE,
+	"logs_indexed_events_15_day_count": datadogV1.HOURLYUSAGEATTRIBUTIONUSAGETYPE_LOGS_INDEXED_15DAY_USAGE,
+	"logs_indexed_events_30_day_count": datadogV1.HOURLYUSAGEATTRIBUTIONUSAGETYPE_LOGS_INDEXED_30DAY_USAGE,
+	"num_custom_timeseries":            datadogV1.HOURLYUSAGEATTRIBUTIONUSAGETYPE_CUSTOM_TIMESERIES_USAGE,
+	"ingested_spans_bytes":             datadogV1.HOURLYUSAGEATTRIBUTIONUSAGETYPE_INGESTED_SPANS_BYTES_USAGE,
+	"indexed_spans_count":              datadogV1.HOURLYUSAGEATTRIBUTIONUSAGETYPE_INDEXED_SPANS_USAGE,
+}
+
+// attributionUsageType returns the usage attribution type that breaks down an hourly usage type by tag, if any
+func attributionUsageType(usageType string) (datadogV1.HourlyUsageAttributionUsageType, bool) {
+	if attributionType, found := attributionUsageTypes[usageType]; found {
+		return attributionType, true
+	}
+
+	// most usage types are named like their attribution type, such as apm_host_count and apm_host_usage
+	name := strings.TrimSuffix(strings.TrimSuffix(usageType, "_count"), "_sum")
+	attributionType, err := datadogV1.NewHourlyUsageAttributionUsageTypeFromValue(name + "_usage")
+	if err != nil {
+		return "", false
+	}
+	return *attributionType, true
+}
+
+// tagUsage is the usage attributed to one combination of tag values over a window
+type tagUsage struct {
+	// key identifies the combination, such as "service:web,team:checkout"
+	key   string
+	tags  map[string]string
+	usage decimal.Decimal
+}
+
+// attributeCosts splits each of a window's lines across the values of the configured tags, in proportion to
+// the usage DD attributes to each. Lines whose usage can't be attributed are kept whole. Errors fetching the
+// attribution are added to ccResp.
+func (d *DatadogCostSource) attributeCosts(ctx context.Context, window opencost.Window, ccResp *pb.CustomCostResponse, lines []*costLine) []*costLine {
+	// each usage type is fetched once, for every org in the window
+	byType := map[datadogV1.HourlyUsageAttributionUsageType][]datadogV1.HourlyUsageAttributionBody{}
+	failed := map[datadogV1.HourlyUsageAttributionUsageType]bool{}
+
+	attributed := []*costLine{}
+	for _, line := range lines {
+		attributionType, ok := attributionUsageType(line.cost.ResourceName)
+		if !ok {
+			log.Debugf("no usage attribution for %s, reporting its cost for the whole org", line.cost.ResourceName)
+			attributed = append(attributed, line)
+			continue
+		}
+
+		if _, fetched := byType[attributionType]; !fetched && !failed[attributionType] {
+			bodies, err := d.getUsageAttribution(ctx, window, attributionType)
+			if err != nil {
+				log.Errorf("error getting usage attribution for %s: %v", attributionType, err)
+				costerror.Add(ccResp, fmt.Errorf("error getting usage attribution for %s: %w", attributionType, err))
+				failed[attributionType] = true
+			} else {
+				byType[attributionType] = bodies
+			}
+		}
+
+		publicID := orgPublicID(line.cost)
+		shares := attributedUsage(byType[attributionType], publicID, d.attributionTags)
+		attributed = append(attributed, splitCost(line, shares, window, publicID)...)
+	}
+	return attributed
+}
+
+// getUsageAttribution returns the hourly usage of a type over a window, broken down by the configured tags
+func (d *DatadogCostSource) getUsageAttribution(ctx context.Context, window opencost.Window, attributionType datadogV1.HourlyUsageAttributionUsageType) ([]datadogV1.HourlyUsageAttributionBody, error) {
+	var bodies []datadogV1.HourlyUsageAttributionBody
+	params := datadogV1.GetHourlyUsageAttributionOptionalParameters{
+		EndHr:            window.End(),
+		TagBreakdownKeys: datadog.PtrString(strings.Join(d.attributionTags, ",")),
+	}
+	if d.includeChildOrgs {
+		params.IncludeDescendants = datadog.PtrBool(true)
+	}
+	for {
+		if err := metrics.WaitRateLimit(ctx, d.rateLimiter); err != nil {
+			return nil, costerror.Wrap(costerror.PartialData, err)
+		}
+
+		resp, r, err := d.v1UsageApi.GetHourlyUsageAttribution(ctx, *window.Start(), attributionType, params)
+		if err != nil {
+			return nil, ddError(r, err)
+		}
+		bodies = append(bodies, resp.Usage...)
+
+		next := resp.GetMetadata().Pagination.GetNextRecordId()
+		if next == "" {
+			return bodies, nil
+		}
+		params.NextRecordId = &next
+	}
+}
+
+// attributedUsage totals an org's attributed usage over a window for each combination of tag values, ordered by combination
+func attributedUsage(bodies []datadogV1.HourlyUsageAttributionBody, publicID string, tagKeys []string) []tagUsage {
+	byKey := map[string]*tagUsage{}
+	for _, body := range bodies {
+		if body.PublicId != nil && *body.PublicId != publicID {
+			continue
+		}
+
+		tags := map[string]string{}
+		var key []string
+		for _, tagKey := range tagKeys {
+			values := body.Tags[tagKey]
+			if len(values) == 0 {
+				continue
+			}
+			tags[tagKey] = strings.Join(values, ",")
+			key = append(key, tagKey+":"+tags[tagKey])
+		}
+
+		combination := strings.Join(key, ",")
+		if _, found := byKey[combination]; !found {
+			byKey[combination] = &tagUsage{key: combination, tags: tags}
+		}
+		byKey[combination].usage = byKey[combination].usage.Add(decimal.NewFromFloat(body.GetTotalUsageSum()))
+	}
+
+	shares := make([]tagUsage, 0, len(byKey))
+	for _, share := range byKey {
+		shares = append(shares, *share)
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].key < shares[j].key })
+	return shares
+}
+
+// splitCost splits a line across tag combinations in proportion to their usage, labeling each part with its tags.
+// A line with no usage attributed to any tag is kept whole.
+func splitCost(line *costLine, shares []tagUsage, window opencost.Window, publicID string) []*costLine {
+	total := decimal.Zero
+	tagged := false
+	for _, share := range shares {
+		total = total.Add(share.usage)
+		tagged = tagged || len(share.tags) > 0
+	}
+	if total.Sign() <= 0 || !tagged {
+		return []*costLine{line}
+	}
+
+	parts := make([]*costLine, 0, len(shares))
+	for _, share := range shares {
+		if share.usage.Sign() <= 0 {
+			continue
+		}
+		fraction := share.usage.Div(total)
+
+		part := proto.Clone(line.cost).(*pb.CustomCost)
+		if part.Labels == nil {
+			part.Labels = map[string]string{}
+		}
+		for key, value := range share.tags {
+			part.Labels[key] = value
+		}
+		// the parts of a cost share its provider ID, so each tag combination keeps its own ID
+		part.Id = costid.New("datadog", publicID, *window.Start(), *window.End(), line.cost.ProviderId, share.key)
+		parts = append(parts, &costLine{
+			cost:     part,
+			quantity: line.quantity.Mul(fraction),
+			billed:   line.billed.Mul(fraction),
+			list:     line.list.Mul(fraction),
+		})
+	}
+	return parts
+}
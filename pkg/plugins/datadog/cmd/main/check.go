@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	_nethttp "net/http"
+	"time"
+
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV1"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/opencost/opencost-plugins/common/httpclient"
+)
+
+// Check verifies the configured keys before the plugin serves any requests.
+// The API key is validated on its own, then the application key is used to read the
+// billable usage summary and estimated costs, which is what every cost query depends on.
+func (d *DatadogCostSource) Check(ctx context.Context) error {
+	// the dd context carries the keys and site, so cancel it along with the check's context
+	ddCtx, cancel := context.WithCancel(d.ddCtx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	configuration := datadog.NewConfiguration()
+	configuration.HTTPClient = httpclient.NewClient(nil)
+	authAPI := datadogV1.NewAuthenticationApi(datadog.NewAPIClient(configuration))
+	_, r, err := authAPI.Validate(ddCtx)
+	if err != nil {
+		// nothing else can succeed without a valid API key
+		return checkFailure("validate API key", r, err, "datadog_api_key is not a valid API key for datadog_site")
+	}
+
+	now := time.Now().UTC()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	var errs []error
+	_, r, err = d.v1UsageApi.GetUsageBillableSummary(ddCtx, datadogV1.GetUsageBillableSummaryOptionalParameters{Month: &month})
+	if err != nil {
+		errs = append(errs, checkFailure("read billable usage", r, err, "datadog_app_key is invalid, or is missing the usage_read scope"))
+	}
+
+	_, r, err = d.usageApi.GetEstimatedCostByOrg(ddCtx, datadogV2.GetEstimatedCostByOrgOptionalParameters{StartDate: &month})
+	if err != nil {
+		errs = append(errs, checkFailure("read estimated costs", r, err, "datadog_app_key is missing the usage_read scope, or does not belong to a parent organization"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkFailure explains a failed check request, naming the missing permission when Datadog refused it
+func checkFailure(action string, r *_nethttp.Response, err error, permissionMsg string) error {
+	if r == nil {
+		return fmt.Errorf("could not %s: %v", action, err)
+	}
+
+	switch r.StatusCode {
+	case _nethttp.StatusUnauthorized, _nethttp.StatusForbidden:
+		return fmt.Errorf("could not %s: %s (HTTP %d)", action, permissionMsg, r.StatusCode)
+	default:
+		return fmt.Errorf("could not %s: HTTP %d: %v", action, r.StatusCode, err)
+	}
+}
@@ -2,23 +2,31 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	_nethttp "net/http"
-	"os"
+	"net/http"
 	"reflect"
 	"strings"
 	"time"
 
 	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
 	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
-	"github.com/agnivade/levenshtein"
 	"golang.org/x/time/rate"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV1"
-	"github.com/hashicorp/go-plugin"
-	commonconfig "github.com/opencost/opencost-plugins/pkg/common/config"
+	"github.com/opencost/opencost-plugins/common/accounts"
+	"github.com/opencost/opencost-plugins/common/budget"
+	"github.com/opencost/opencost-plugins/common/cache"
+	commonconfig "github.com/opencost/opencost-plugins/common/config"
+	"github.com/opencost/opencost-plugins/common/costerror"
+	"github.com/opencost/opencost-plugins/common/costid"
+	"github.com/opencost/opencost-plugins/common/decimal"
+	"github.com/opencost/opencost-plugins/common/executor"
+	"github.com/opencost/opencost-plugins/common/filter"
+	"github.com/opencost/opencost-plugins/common/finality"
+	"github.com/opencost/opencost-plugins/common/httpclient"
+	"github.com/opencost/opencost-plugins/common/metrics"
+	"github.com/opencost/opencost-plugins/common/runtime"
 	datadogplugin "github.com/opencost/opencost-plugins/pkg/plugins/datadog/datadogplugin"
 	"github.com/opencost/opencost/core/pkg/log"
 	"github.com/opencost/opencost/core/pkg/model/pb"
@@ -26,25 +34,44 @@ import (
 	ocplugin "github.com/opencost/opencost/core/pkg/plugin"
 )
 
-// URL of the Datadog pricing page
-const url = "https://aws.amazon.com/marketplace/pp/prodview-536p4hpqbajc2"
+// DD usage and estimated costs can be revised for 72 hours
+const ddFinalityHorizon = 72 * time.Hour
 
-// handshakeConfigs are used to just do a basic handshake between
-// a plugin and host. If the handshake fails, a user friendly error is shown.
-// This prevents users from executing bad plugins or executing a plugin
-// directory. It is a UX feature, not a security feature.
-var handshakeConfig = plugin.HandshakeConfig{
-	ProtocolVersion:  1,
-	MagicCookieKey:   "PLUGIN_NAME",
-	MagicCookieValue: "datadog",
+// ddSettlesAt returns when the costs of a window ending at end are final. Costs are priced from DD's
+// estimated costs for the month until it closes and its historical costs are finalized, after which
+// windows are cached.
+func ddSettlesAt(end time.Time) time.Time {
+	return finality.MonthClose(end).Add(ddFinalityHorizon)
 }
 
+// Datadog bills hosts per month, as 730 host hours
+const hoursPerMonth = 730
+
+// URL of the Datadog pricing page
+const url = "https://aws.amazon.com/marketplace/pp/prodview-536p4hpqbajc2"
+
 // Implementation of CustomCostSource
 type DatadogCostSource struct {
 	ddCtx       context.Context
 	usageApi    *datadogV2.UsageMeteringApi
 	v1UsageApi  *datadogV1.UsageMeteringApi
 	rateLimiter *rate.Limiter
+	budget      budget.BudgetConfig
+	executor    executor.ExecutorConfig
+	cache       *cache.WindowCache
+	// cacheAccount identifies the DD org and filters in the cache
+	cacheAccount string
+	filters      datadogplugin.DatadogFilters
+	// usagePrices maps each usage type to the product it is billed as
+	usagePrices usagePriceMap
+	// allotments are the free usage bundled with each product
+	allotments []allotment
+	// attributionTags are the tag keys costs are split across, if any
+	attributionTags []string
+	// includeChildOrgs reports the usage of the child orgs of a parent org
+	includeChildOrgs bool
+	// prices memoizes the unit prices of each org for each month
+	prices *unitPrices
 }
 
 func (d *DatadogCostSource) GetCustomCosts(req *pb.CustomCostRequest) []*pb.CustomCostResponse {
@@ -53,69 +80,121 @@ func (d *DatadogCostSource) GetCustomCosts(req *pb.CustomCostRequest) []*pb.Cust
 	targets, err := opencost.GetWindows(req.Start.AsTime(), req.End.AsTime(), req.Resolution.AsDuration())
 	if err != nil {
 		log.Errorf("error getting windows: %v", err)
-		errResp := pb.CustomCostResponse{
-			Errors: []string{fmt.Sprintf("error getting windows: %v", err)},
-		}
-		results = append(results, &errResp)
+		results = append(results, costerror.Response(costerror.New(costerror.UnsupportedRequest, "error getting windows: %v", err)))
 		return results
 	}
 
-	for _, target := range targets {
-		// Call the function to scrape prices
-		unitPricing, err := d.GetDDUnitPrices(target.Start().UTC())
-		if err != nil {
-			log.Errorf("error getting dd pricing: %v", err)
-			errResp := pb.CustomCostResponse{
-				Errors: []string{fmt.Sprintf("error getting dd pricing: %v", err)},
-			}
-			results = append(results, &errResp)
-			return results
+	// every upstream call shares the request's budget. the dd context carries the keys and site
+	ctx, cancel := d.budget.Start(d.ddCtx)
+	defer cancel()
+
+	// windows are fetched concurrently. every DD request waits on the shared rate limiter
+	return d.executor.Run(targets, func(target opencost.Window) (*pb.CustomCostResponse, error) {
+		resp, pricesFinal, err := d.getDDWindow(ctx, target)
+		// costs are priced from DD's estimated bill, which is revised until the month's costs are finalized.
+		// a window priced from estimated costs after that, as the historical costs failed to fetch, isn't final
+		if pricesFinal {
+			finality.Mark(resp, finality.Estimated, ddSettlesAt(*target.End()), time.Now())
 		} else {
-			log.Debugf("got unit pricing: %v", unitPricing)
-		}
-		// DataDog gets mad if we ask them to tell the future
-		if target.Start().After(time.Now().UTC()) {
-			log.Debugf("skipping future window %v", target)
-			continue
+			finality.MarkUnsettled(resp, finality.Estimated, ddSettlesAt(*target.End()))
 		}
+		return resp, err
+	})
+}
+
+// getDDWindow returns the costs for a window from the cache, or fetches them, and whether they are priced
+// from DD's final costs for the month
+func (d *DatadogCostSource) getDDWindow(ctx context.Context, target opencost.Window) (*pb.CustomCostResponse, bool, error) {
+	// only windows priced from final costs are cached
+	if cached, found := d.cache.Get(d.cacheAccount, target); found {
+		return cached, true, nil
+	}
+
+	if budget.Exhausted(ctx) {
+		return timedOutDDWindow(ctx, target), false, nil
+	}
 
-		log.Debugf("fetching DD costs for window %v", target)
-		result := d.getDDCostsForWindow(target, unitPricing)
-		results = append(results, result)
+	// DataDog gets mad if we ask them to tell the future
+	if target.Start().After(time.Now().UTC()) {
+		log.Debugf("skipping future window %v", target)
+		return nil, false, nil
 	}
 
-	return results
+	// prices come from the month containing the window, fetched once for all of its windows
+	unitPricing, pricesFinal, err := d.GetDDUnitPrices(ctx, target.Start().UTC())
+	if err != nil && budget.Exhausted(ctx) {
+		return timedOutDDWindow(ctx, target), false, nil
+	} else if err != nil {
+		log.Errorf("error getting dd pricing: %v", err)
+		return nil, false, fmt.Errorf("error getting dd pricing: %w", err)
+	} else {
+		log.Debugf("got unit pricing: %v", unitPricing)
+	}
+
+	log.Debugf("fetching DD costs for window %v", target)
+	result := d.getDDCostsForWindow(ctx, target, unitPricing)
+	// a settled window priced from estimated costs, as its historical costs failed to fetch, isn't cached
+	if pricesFinal {
+		d.cache.Put(d.cacheAccount, target, result)
+	}
+	return result, pricesFinal, nil
 }
 
 func main() {
+	runtime.Serve("datadog", newDatadogCostSource)
+}
 
-	configFile, err := commonconfig.GetConfigFilePath()
+func newDatadogCostSource(configBytes []byte) (ocplugin.CustomCostSource, error) {
+	ddConfig, err := getDatadogConfig(configBytes)
 	if err != nil {
-		log.Fatalf("error opening config file: %v", err)
+		return nil, fmt.Errorf("error building DD config: %v", err)
 	}
 
-	ddConfig, err := getDatadogConfig(configFile)
+	windowCache, err := cache.NewSettling(ddConfig.CacheConfig, "datadog", ddSettlesAt)
 	if err != nil {
-		log.Fatalf("error building DD config: %v", err)
-	}
-	log.SetLogLevel(ddConfig.DDLogLevel)
-	// datadog usage APIs allow 10 requests every 30 seconds
-	rateLimiter := rate.NewLimiter(0.1, 1)
-	ddCostSrc := DatadogCostSource{
-		rateLimiter: rateLimiter,
+		return nil, fmt.Errorf("error creating DD window cache: %v", err)
+	}
+
+	var orgs []accounts.Account
+	for _, account := range ddConfig.AccountList() {
+		ddCostSrc := DatadogCostSource{
+			// datadog usage APIs allow 10 requests every 30 seconds, for each org
+			rateLimiter: rate.NewLimiter(0.1, 1),
+			budget:      ddConfig.BudgetConfig,
+			executor:    ddConfig.ExecutorConfig,
+			cache:       windowCache,
+			// the API key identifies the org. the cache hashes it before it is written to disk
+			cacheAccount:     ddCacheAccount(account, ddConfig),
+			filters:          ddConfig.Filters,
+			usagePrices:      newUsagePriceMap(ddConfig.UsagePrices),
+			allotments:       newAllotments(ddConfig.Allotments),
+			attributionTags:  ddConfig.AttributionTags,
+			includeChildOrgs: ddConfig.IncludeChildOrgs,
+			prices:           newUnitPrices(),
+		}
+		ddCostSrc.ddCtx, ddCostSrc.usageApi, ddCostSrc.v1UsageApi = getDatadogClients(account)
+		orgs = append(orgs, accounts.Account{Name: account.Name, Source: &ddCostSrc})
 	}
-	ddCostSrc.ddCtx, ddCostSrc.usageApi, ddCostSrc.v1UsageApi = getDatadogClients(*ddConfig)
 
-	// pluginMap is the map of plugins we can dispense.
-	var pluginMap = map[string]plugin.Plugin{
-		"CustomCostSource": &ocplugin.CustomCostPlugin{Impl: &ddCostSrc},
-	}
+	return accounts.Source(orgs), nil
+}
 
-	plugin.Serve(&plugin.ServeConfig{
-		HandshakeConfig: handshakeConfig,
-		Plugins:         pluginMap,
-		GRPCServer:      plugin.DefaultGRPCServer,
-	})
+// ddCacheAccount identifies an org in the cache, along with the settings that change the costs reported for it
+func ddCacheAccount(account datadogplugin.DatadogAccount, config *datadogplugin.DatadogConfig) string {
+	cacheAccount := filter.CacheAccount(account.DDSite+"/"+account.DDAPIKey, config.Filters)
+	if config.IncludeChildOrgs {
+		cacheAccount += "/child_orgs"
+	}
+	if len(config.UsagePrices) > 0 {
+		cacheAccount = filter.CacheAccount(cacheAccount, config.UsagePrices)
+	}
+	if len(config.Allotments) > 0 {
+		cacheAccount = filter.CacheAccount(cacheAccount, config.Allotments)
+	}
+	if len(config.AttributionTags) > 0 {
+		cacheAccount += "/attribution:" + strings.Join(config.AttributionTags, ",")
+	}
+	return cacheAccount
 }
 
 func boilerplateDDCustomCost(win opencost.Window) pb.CustomCostResponse {
@@ -131,9 +210,30 @@ func boilerplateDDCustomCost(win opencost.Window) pb.CustomCostResponse {
 		Costs:      []*pb.CustomCost{},
 	}
 }
-func (d *DatadogCostSource) getDDCostsForWindow(window opencost.Window, listPricing map[string]billableCost) *pb.CustomCostResponse {
+
+// timedOutDDWindow is the response for a window not fetched before the request's budget ran out
+func timedOutDDWindow(ctx context.Context, win opencost.Window) *pb.CustomCostResponse {
+	ccResp := boilerplateDDCustomCost(win)
+	costerror.Add(&ccResp, budget.WindowError(ctx))
+	return &ccResp
+}
+
+// ddError gives the error from a failed Datadog API call the kind matching its HTTP response.
+// The client returns an error with a successful response when the body can't be decoded.
+func ddError(r *http.Response, err error) error {
+	if r == nil {
+		return err
+	}
+	if r.StatusCode < http.StatusMultipleChoices {
+		return costerror.Wrap(costerror.ParseError, err)
+	}
+	return costerror.Wrap(costerror.KindOfStatus(r.StatusCode), err)
+}
+
+func (d *DatadogCostSource) getDDCostsForWindow(ctx context.Context, window opencost.Window, listPricing orgPrices) *pb.CustomCostResponse {
 	ccResp := boilerplateDDCustomCost(window)
-	costs := map[string]*pb.CustomCost{}
+	costs := map[string]*costLine{}
+	unpriced := map[string]bool{}
 	nextPageId := "init"
 	for morepages := true; morepages; morepages = (nextPageId != "") {
 		params := datadogV2.NewGetHourlyUsageOptionalParameters()
@@ -145,321 +245,233 @@ func (d *DatadogCostSource) getDDCostsForWindow(window opencost.Window, listPric
 			log.Infof("datadog rate limit reached. holding request until rate capacity is back")
 		}
 
-		err := d.rateLimiter.WaitN(context.TODO(), 1)
+		err := metrics.WaitRateLimit(ctx, d.rateLimiter)
 		if err != nil {
 			log.Errorf("error waiting on rate limiter`: %v\n", err)
-			ccResp.Errors = append(ccResp.Errors, err.Error())
+			costerror.Add(&ccResp, costerror.Wrap(costerror.PartialData, err))
 			return &ccResp
 		}
 
-		maxTries := 5
-		try := 1
-		var resp datadogV2.HourlyUsageResponse
-		for try <= maxTries {
-			params.FilterTimestampEnd = window.End()
-			var r *_nethttp.Response
-			resp, r, err = d.usageApi.GetHourlyUsage(d.ddCtx, *window.Start(), "all", *params)
-			if err != nil {
-				log.Errorf("Error when calling `UsageMeteringApi.GetHourlyUsage`: %v\n", err)
-				log.Errorf("Full HTTP response: %v\n", r)
-			}
-
-			if err == nil {
-				break
-			} else {
-				if strings.Contains(err.Error(), "429") {
-					log.Errorf("rate limit reached, retrying...")
-				}
-				time.Sleep(30 * time.Second)
-				try++
-			}
-
+		// the client retries rate limited and failed requests, waiting as long as datadog asks
+		params.FilterTimestampEnd = window.End()
+		if d.includeChildOrgs {
+			params.FilterIncludeDescendants = datadog.PtrBool(true)
 		}
-
+		resp, r, err := d.usageApi.GetHourlyUsage(ctx, *window.Start(), "all", *params)
 		if err != nil {
-			log.Errorf("after calling `UsageMeteringApi.GetHourlyUsage` %d times, still getting error: %v\n", maxTries, err)
-			ccResp.Errors = append(ccResp.Errors, err.Error())
+			log.Errorf("Error when calling `UsageMeteringApi.GetHourlyUsage`: %v\n", err)
+			log.Errorf("Full HTTP response: %v\n", r)
+			costerror.Add(&ccResp, ddError(r, err))
 		}
 
-		for index := range resp.Data {
-			// each of these entries gives hourly data steps
-			for indexMeas := range resp.Data[index].Attributes.Measurements {
-				usageQty := float32(0.0)
-
-				if resp.Data[index].Attributes.Measurements[indexMeas].Value.IsSet() {
-					usageQty = float32(resp.Data[index].Attributes.Measurements[indexMeas].GetValue())
-				}
-
-				if usageQty == 0.0 {
-					log.Tracef("product %s/%s had 0 usage, not recording that cost", *resp.Data[index].Attributes.ProductFamily, *resp.Data[index].Attributes.Measurements[indexMeas].UsageType)
-					continue
-				}
-
-				matched, pricing := matchUsageToPricing(*resp.Data[index].Attributes.Measurements[indexMeas].UsageType, listPricing)
-				log.Infof("matched %s to %s", *resp.Data[index].Attributes.Measurements[indexMeas].UsageType, matched)
-				provId := *resp.Data[index].Attributes.PublicId + "/" + *resp.Data[index].Attributes.Measurements[indexMeas].UsageType
-				if matched == "" {
-					log.Infof("no pricing found for %s", *resp.Data[index].Attributes.Measurements[indexMeas].UsageType)
-					continue
-				}
-
-				billedCost := float32(pricing.Cost) * usageQty
-
-				if _, found := costs[provId]; found {
-					// we have already encountered this cost type for this window, so add to the existing cost entry
-					costs[provId].UsageQuantity += usageQty
-					costs[provId].BilledCost += billedCost
-				} else {
-					// we have not encountered this cost type for this window yet, so create a new cost entry
-					cost := pb.CustomCost{
-						Zone:               *resp.Data[index].Attributes.Region,
-						AccountName:        *resp.Data[index].Attributes.OrgName,
-						ChargeCategory:     "usage",
-						Description:        "nil",
-						ResourceName:       *resp.Data[index].Attributes.Measurements[indexMeas].UsageType,
-						ResourceType:       *resp.Data[index].Attributes.ProductFamily,
-						Id:                 *resp.Data[index].Id,
-						ProviderId:         provId,
-						Labels:             map[string]string{},
-						ListCost:           0,
-						ListUnitPrice:      0,
-						BilledCost:         billedCost,
-						UsageQuantity:      usageQty,
-						UsageUnit:          pricing.unit,
-						ExtendedAttributes: nil,
-					}
-
-					costs[provId] = &cost
-				}
-			}
-		}
+		d.addHourlyUsage(window, resp.Data, listPricing, costs, unpriced)
 		if resp.Meta != nil && resp.Meta.Pagination != nil && resp.Meta.Pagination.NextRecordId.IsSet() {
 			nextPageId = *resp.Meta.Pagination.NextRecordId.Get()
 		} else {
 			nextPageId = ""
 		}
 	}
-	allCosts := []*pb.CustomCost{}
-	for _, cost := range costs {
-		allCosts = append(allCosts, cost)
+	lines := make([]*costLine, 0, len(costs))
+	for _, line := range costs {
+		lines = append(lines, line)
 	}
-	ccResp.Costs = allCosts
 
 	// post processing
-	// datadog's usage API sometimes provides usages that get counted multiple times
-	// this post processing stage de-duplicates those usages and costs
-	postProcess(&ccResp)
-
-	return &ccResp
-}
-
-func matchUsageToPricing(usageType string, pricing map[string]billableCost) (string, *billableCost) {
-	// for the usage, remove _count from the end of the usage type
-	usageType = strings.TrimSuffix(usageType, "_count")
-
-	// not specific enough to match on
-	if usageType == "host" {
-		return "", nil
-	}
-	// if the usage type is in the pricing map, use that
-	if _, found := pricing[usageType]; found {
-		entry := pricing[usageType]
-		return usageType, &entry
-	}
-
-	// break up the usage on _
-	tokens := strings.Split(usageType, "_")
-	// find the first pricing key that contains all tokens
-	for key, price := range pricing {
-		matchesAll := true
-		for _, token := range tokens {
-			if !strings.Contains(key, token) {
-				matchesAll = false
-				break
-			}
-		}
-		if matchesAll {
-			return key, &price
+	// usage types that total others, such as host_count, are already left out by the usage price mapping.
+	// this post processing stage bills the usage beyond each allotment, for each org on its own
+	lines, postUnpriced := d.postProcess(lines, window, listPricing)
+	for _, key := range postUnpriced {
+		unpriced[key] = true
+	}
+
+	// usage allocated elsewhere is left out once allotments are applied, as the filtered usage still
+	// includes free usage of other products
+	var excluded filter.Excluded
+	lines, excluded = excludeCosts(lines, d.filters, ccResp.Currency)
+	excluded.Record(&ccResp)
+
+	// usage allocated elsewhere isn't reported as unpriced
+	for key := range unpriced {
+		productFamily, usageType, _ := strings.Cut(key, "/")
+		if !d.filters.Keeps(productFamily, usageType) {
+			delete(unpriced, key)
 		}
 	}
+	reportUnpriced(&ccResp, unpriced)
 
-	// try replacing agent with infra and checking that
-	agentAsInfra := strings.ReplaceAll(usageType, "agent", "infra")
-	tokens = strings.Split(agentAsInfra, "_")
-	// find the first pricing key that contains all tokens
-	for key, price := range pricing {
-		matchesAll := true
-		for _, token := range tokens {
-			if !strings.Contains(key, token) {
-				matchesAll = false
-				break
-			}
-		}
-		if matchesAll {
-			return key, &price
-		}
+	// once the org's costs are complete, they are split across the teams and services they were used by
+	if len(d.attributionTags) > 0 {
+		lines = d.attributeCosts(ctx, window, &ccResp, lines)
 	}
+	ccResp.Costs = windowCosts(lines)
 
-	// if still no pricing key is found, compute the levenshtein distance between the usage type and the pricing key
-	// and use the one with the smallest distance
-	smallestDist := 4000000000
-	var closestKey string
-	for key := range pricing {
-		distance := levenshtein.ComputeDistance(usageType, key)
-		if distance < smallestDist {
-			smallestDist = distance
-			closestKey = key
-		}
-	}
-
-	// remember the pricing keys we have already matched. if we have already matched a pricing key, don't match it again
-	entry := pricing[closestKey]
-	return closestKey, &entry
+	return &ccResp
+}
 
+// costLine is a cost of a window whose usage and amounts are kept exact through post processing, filtering
+// and attribution. Its cost's float32 fields are only set by windowCosts, once the amounts are complete.
+type costLine struct {
+	cost     *pb.CustomCost
+	quantity decimal.Decimal
+	billed   decimal.Decimal
+	list     decimal.Decimal
 }
-func postProcess(ccResp *pb.CustomCostResponse) {
-	if ccResp == nil {
-		return
-	}
 
-	ccResp.Costs = processInfraHosts(ccResp.Costs)
+// addHourlyUsage prices a page of hourly usage with the prices of the org it belongs to, and adds it to the
+// window's totals, keyed by provider ID. Usage without a price is added to unpriced, as product_family/usage_type,
+// unless an allotment covers it, as its usage may be free.
+func (d *DatadogCostSource) addHourlyUsage(window opencost.Window, data []datadogV2.HourlyUsage, listPricing orgPrices, costs map[string]*costLine, unpriced map[string]bool) {
+	for index := range data {
+		orgPricing := listPricing.forOrg(data[index].Attributes.GetPublicId())
+		// each of these entries gives hourly data steps
+		for indexMeas := range data[index].Attributes.Measurements {
+			if data[index].Attributes.Measurements[indexMeas].GetValue() == 0 {
+				log.Tracef("product %s/%s had 0 usage, not recording that cost", *data[index].Attributes.ProductFamily, *data[index].Attributes.Measurements[indexMeas].UsageType)
+				continue
+			}
+			usageQty := decimal.NewFromInt(data[index].Attributes.Measurements[indexMeas].GetValue())
 
-	ccResp.Costs = processLogUsage(ccResp.Costs)
+			matched, pricing, billed := matchUsageToPricing(*data[index].Attributes.ProductFamily, *data[index].Attributes.Measurements[indexMeas].UsageType, d.usagePrices, orgPricing)
+			provId := *data[index].Attributes.PublicId + "/" + *data[index].Attributes.Measurements[indexMeas].UsageType
+			if !billed {
+				log.Tracef("%s is not billed on its own, not recording that cost", *data[index].Attributes.Measurements[indexMeas].UsageType)
+				continue
+			}
+			if pricing == nil && allotted(d.allotments, matched) {
+				log.Debugf("no pricing found for %s as %s, recording it for its allotment", *data[index].Attributes.Measurements[indexMeas].UsageType, matched)
+				pricing = &billableCost{ProductName: matched}
+			} else if pricing == nil {
+				log.Debugf("no pricing found for %s as %s", *data[index].Attributes.Measurements[indexMeas].UsageType, matched)
+				unpriced[*data[index].Attributes.ProductFamily+"/"+*data[index].Attributes.Measurements[indexMeas].UsageType] = true
+				continue
+			}
+			log.Debugf("matched %s to %s", *data[index].Attributes.Measurements[indexMeas].UsageType, matched)
 
-	// DBM queries have 200 * number of hosts included. We need to adjust the costs to reflect this
-	ccResp.Costs = adjustDBMQueries(ccResp.Costs)
+			billedCost := pricing.Cost.Mul(usageQty)
 
-	// removes any items that have 0 usage, either because of post processing or otherwise
-	ccResp.Costs = removeZeroUsages(ccResp.Costs)
-}
+			if total, found := costs[provId]; found {
+				// we have already encountered this cost type for this window, so add to the existing cost entry
+				total.quantity = total.quantity.Add(usageQty)
+				total.billed = total.billed.Add(billedCost)
+				continue
+			}
 
-// as per https://www.datadoghq.com/pricing/?product=database-monitoring#database-monitoring-can-i-still-use-dbm-if-i-have-additional-normalized-queries-past-the-a-hrefpricingallotmentsallotteda-amount
-// the first 200 queries per host are free.
-// if that zeroes out the dbm queries, we remove the cost
-func adjustDBMQueries(costs []*pb.CustomCost) []*pb.CustomCost {
-	totalFreeQueries := float32(0.0)
-	for index := 0; index < len(costs); index++ {
-		if costs[index].ResourceName == "dbm_host_count" {
-			hostCount := costs[index].UsageQuantity
-			totalFreeQueries += 200 * float32(hostCount)
-		}
-	}
-	log.Debugf("total free queries: %f", totalFreeQueries)
+			// we have not encountered this cost type for this window yet, so create a new cost entry
+			// under the org that used it, as each org of a parent org is reported as a sub account
+			cost := pb.CustomCost{
+				Zone:           *data[index].Attributes.Region,
+				AccountName:    *data[index].Attributes.OrgName,
+				ChargeCategory: "Usage",
+				Description:    fmt.Sprintf("Datadog %s usage for %s", *data[index].Attributes.ProductFamily, *data[index].Attributes.Measurements[indexMeas].UsageType),
+				ResourceName:   *data[index].Attributes.Measurements[indexMeas].UsageType,
+				ResourceType:   *data[index].Attributes.ProductFamily,
+				Id:             costid.New("datadog", *data[index].Attributes.PublicId, *window.Start(), *window.End(), provId),
+				ProviderId:     provId,
+				Labels:         map[string]string{},
+				ListCost:       0,
+				ListUnitPrice:  0,
+				UsageUnit:      pricing.unit,
+				ExtendedAttributes: &pb.CustomCostExtendedAttributes{
+					SubAccountId:   data[index].Attributes.PublicId,
+					SubAccountName: data[index].Attributes.OrgName,
+				},
+			}
 
-	for index := 0; index < len(costs); index++ {
-		if costs[index].ResourceName == "dbm_queries_count" {
-			costs[index].UsageQuantity -= totalFreeQueries
-			log.Debugf("adjusted dbm queries: %v", costs[index])
+			costs[provId] = &costLine{cost: &cost, quantity: usageQty, billed: billedCost}
 		}
-
 	}
+}
 
-	for index := 0; index < len(costs); index++ {
-		if costs[index].ResourceName == "dbm_queries_count" {
-			if costs[index].UsageQuantity <= 0 {
-				log.Debugf("removing cost %s because it has 0 usage", costs[index].ProviderId)
-				costs = append(costs[:index], costs[index+1:]...)
-				index = 0
-			} else {
-				// TODO else, multiply cost by the rate for extra queries
-				costs[index].ListCost = 0.0
-				costs[index].ListUnitPrice = 0.0
-				costs[index].UsageUnit = "queries"
-			}
+// excludeCosts removes the costs of the usage the filters leave out, and returns what they cost in currency
+func excludeCosts(lines []*costLine, filters datadogplugin.DatadogFilters, currency string) ([]*costLine, filter.Excluded) {
+	var excluded filter.Excluded
+	kept := []*costLine{}
+	for _, line := range lines {
+		if filters.Keeps(line.cost.ResourceType, line.cost.ResourceName) {
+			kept = append(kept, line)
+			continue
 		}
+		log.Debugf("filters exclude %s usage for %s", line.cost.ResourceType, line.cost.ResourceName)
+		excluded.Add(currency, line.billed)
 	}
-	return costs
+	return kept, excluded
 }
 
-// removes any items that have 0 usage or cost, either because of post processing or otherwise
-func removeZeroUsages(costs []*pb.CustomCost) []*pb.CustomCost {
-	log.Tracef("POST -costs length before post processing: %d", len(costs))
-	for index := 0; index < len(costs); index++ {
-		log.Tracef("POST - looking at cost %s with usage %f", costs[index].ResourceName, costs[index].UsageQuantity)
-		if costs[index].UsageQuantity < 0.001 && costs[index].ListCost == 0.0 && costs[index].BilledCost == 0.0 {
-			if costs[index].ResourceName == "dbm_queries_count" {
-				log.Tracef("leaving dbm queries cost in place")
-				continue
-			}
-			log.Tracef("POST -removing cost %s because it has 0 usage", costs[index].ProviderId)
-			costs = append(costs[:index], costs[index+1:]...)
-			log.Tracef("POST - costs is now %d", len(costs))
-			index = -1
-		}
+// windowCosts converts a window's lines to costs, only rounding amounts to float32 once they are complete
+func windowCosts(lines []*costLine) []*pb.CustomCost {
+	allCosts := []*pb.CustomCost{}
+	for _, line := range lines {
+		line.cost.UsageQuantity = line.quantity.Float32()
+		line.cost.BilledCost = line.billed.Float32()
+		line.cost.ListCost = line.list.Float32()
+		allCosts = append(allCosts, line.cost)
 	}
-	log.Tracef("POST -costs length after post processing: %d", len(costs))
-
-	return costs
+	return allCosts
 }
 
-func processInfraHosts(costs []*pb.CustomCost) []*pb.CustomCost {
-	// remove the container_count item
-	for index := 0; index < len(costs); index++ {
-		if costs[index].ResourceName == "container_count" {
-			costs = append(costs[:index], costs[index+1:]...)
-			index = 0
+// postProcess bills the usage beyond each allotment, and removes the lines left with no usage. It returns
+// the processed lines, and the usage whose overage has no price, as product_family/usage_type.
+func (d *DatadogCostSource) postProcess(lines []*costLine, window opencost.Window, listPricing orgPrices) ([]*costLine, []string) {
+	// the usage of one org never offsets another's, such as the DBM queries included with each org's hosts
+	byOrg := map[string][]*costLine{}
+	var orgs []string
+	for _, line := range lines {
+		publicID := orgPublicID(line.cost)
+		if _, found := byOrg[publicID]; !found {
+			orgs = append(orgs, publicID)
 		}
+		byOrg[publicID] = append(byOrg[publicID], line)
 	}
 
-	return costs
-}
-
-func processLogUsage(costs []*pb.CustomCost) []*pb.CustomCost {
+	processed := []*costLine{}
+	var unpriced []string
+	for _, publicID := range orgs {
+		// usage such as DBM queries includes an allotment per unit of another product, such as 200 per DBM host
+		orgLines, orgUnpriced := applyAllotments(window, byOrg[publicID], d.allotments, d.usagePrices, listPricing.forOrg(publicID))
+		unpriced = append(unpriced, orgUnpriced...)
 
-	for index := range costs {
-		if costs[index].ResourceName == "logs_live_indexed_events_15_day_count" {
-			costs = append(costs[:index], costs[index+1:]...)
-			break
-		}
+		// removes any items that have 0 usage, either because of post processing or otherwise
+		processed = append(processed, removeZeroUsages(orgLines)...)
 	}
+	return processed, unpriced
+}
 
-	// remove live indexed events count, that is covered by the other categories
-	for index := range costs {
-		if costs[index].ResourceName == "logs_live_indexed_count" {
-			costs = append(costs[:index], costs[index+1:]...)
-			break
-		}
-	}
+// orgPublicID returns the public ID of the org a cost was used by, which prefixes its provider ID
+func orgPublicID(cost *pb.CustomCost) string {
+	publicID, _, _ := strings.Cut(cost.ProviderId, "/")
+	return publicID
+}
 
-	var logsIndexed *pb.CustomCost
-	for index := range costs {
-		if costs[index].ResourceName == "indexed_events_count" {
-			logsIndexed = costs[index]
-			costs = append(costs[:index], costs[index+1:]...)
-			break
+// removes any items that have 0 usage or cost, either because of post processing or otherwise
+func removeZeroUsages(lines []*costLine) []*costLine {
+	minUsage := decimal.New(1, -3)
+	log.Tracef("POST -costs length before post processing: %d", len(lines))
+	for index := 0; index < len(lines); index++ {
+		log.Tracef("POST - looking at cost %s with usage %s", lines[index].cost.ResourceName, lines[index].quantity)
+		if lines[index].quantity.Cmp(minUsage) < 0 && lines[index].list.IsZero() && lines[index].billed.IsZero() {
+			log.Tracef("POST -removing cost %s because it has 0 usage", lines[index].cost.ProviderId)
+			lines = append(lines[:index], lines[index+1:]...)
+			log.Tracef("POST - costs is now %d", len(lines))
+			index = -1
 		}
 	}
+	log.Tracef("POST -costs length after post processing: %d", len(lines))
 
-	if logsIndexed != nil {
-		leftoverLogs := float32(0.0)
-		for index := range costs {
-			if costs[index].ResourceName == "logs_indexed_events_15_day_count" {
-				leftoverLogs = float32(logsIndexed.UsageQuantity) - float32(costs[index].UsageQuantity)
-				break
-			}
-		}
-
-		logsIndexed.Description = "other log events"
-		logsIndexed.UsageQuantity = leftoverLogs
-		logsIndexed.ResourceName = "other_log_events"
-		logsIndexed.ListCost = leftoverLogs * logsIndexed.ListUnitPrice
-		costs = append(costs, logsIndexed)
-	}
-	return costs
+	return lines
 }
 
-func getDatadogClients(config datadogplugin.DatadogConfig) (context.Context, *datadogV2.UsageMeteringApi, *datadogV1.UsageMeteringApi) {
+func getDatadogClients(account datadogplugin.DatadogAccount) (context.Context, *datadogV2.UsageMeteringApi, *datadogV1.UsageMeteringApi) {
 	ddctx := datadog.NewDefaultContext(context.Background())
 	ddctx = context.WithValue(
 		ddctx,
 		datadog.ContextServerVariables,
-		map[string]string{"site": config.DDSite},
+		map[string]string{"site": account.DDSite},
 	)
 
 	keys := make(map[string]datadog.APIKey)
 
-	keys["apiKeyAuth"] = datadog.APIKey{Key: config.DDAPIKey}
-	keys["appKeyAuth"] = datadog.APIKey{Key: config.DDAppKey}
+	keys["apiKeyAuth"] = datadog.APIKey{Key: account.DDAPIKey}
+	keys["appKeyAuth"] = datadog.APIKey{Key: account.DDAppKey}
 
 	ddctx = context.WithValue(
 		ddctx,
@@ -468,141 +480,234 @@ func getDatadogClients(config datadogplugin.DatadogConfig) (context.Context, *da
 	)
 
 	configuration := datadog.NewConfiguration()
+	configuration.HTTPClient = httpclient.NewClient(nil)
 	apiClient := datadog.NewAPIClient(configuration)
 	usageAPI := datadogV2.NewUsageMeteringApi(apiClient)
 	v1UsageAPI := datadogV1.NewUsageMeteringApi(apiClient)
 	return ddctx, usageAPI, v1UsageAPI
 }
 
-func getDatadogConfig(configFilePath string) (*datadogplugin.DatadogConfig, error) {
+func getDatadogConfig(configBytes []byte) (*datadogplugin.DatadogConfig, error) {
 	var result datadogplugin.DatadogConfig
-	bytes, err := os.ReadFile(configFilePath)
+	err := commonconfig.Decode(configBytes, &result)
 	if err != nil {
-		return nil, fmt.Errorf("error reading config file for DD config @ %s: %v", configFilePath, err)
+		return nil, fmt.Errorf("invalid DD config: %v", err)
+	}
+
+	return &result, nil
+}
+
+// GetDDUnitPrices returns each org's unit prices for a window starting at windowStart, from the costs of the
+// month containing it, and whether they are final. Prices are memoized per month, so only the first window
+// of a month fetches them.
+func (d *DatadogCostSource) GetDDUnitPrices(ctx context.Context, windowStart time.Time) (orgPrices, bool, error) {
+	now := time.Now().UTC()
+	month := pricingMonth(windowStart, now)
+	return d.prices.get(ctx, month, func() (orgPrices, bool, error) {
+		return d.fetchDDUnitPrices(ctx, month, now)
+	})
+}
+
+// fetchDDUnitPrices derives each org's unit prices for a month from its billable usage and costs, and
+// reports whether they are final
+func (d *DatadogCostSource) fetchDDUnitPrices(ctx context.Context, month time.Time, now time.Time) (orgPrices, bool, error) {
+	// first, get the billable usage for the month
+	opts := datadogV1.GetUsageBillableSummaryOptionalParameters{
+		Month: &month,
 	}
-	err = json.Unmarshal(bytes, &result)
+	respBillableUsage, r, err := d.v1UsageApi.GetUsageBillableSummary(ctx, opts)
 	if err != nil {
-		return nil, fmt.Errorf("error marshaling json into DD config %v", err)
+		return nil, false, fmt.Errorf("error getting usage billable usage summary: %w", ddError(r, err))
 	}
 
-	if result.DDLogLevel == "" {
-		result.DDLogLevel = "info"
+	// then, get the cost for the month
+	costsByOrg, final, err := d.getDDMonthCosts(ctx, month, now)
+	if err != nil {
+		return nil, false, err
 	}
 
-	return &result, nil
+	return orgUnitPrices(respBillableUsage.Usage, costsByOrg), final, nil
 }
 
-func (d *DatadogCostSource) GetDDUnitPrices(windowStart time.Time) (map[string]billableCost, error) {
+// orgPrices are the unit prices of each org in a month, keyed by org public ID and then product name.
+// The prices under accountPrices are the whole account's, for orgs and products DD has no prices for.
+type orgPrices map[string]map[string]billableCost
 
-	// DD estimated costs can be delayed 72 hours
-	// so ensure we are going far enough back
-	stableTimeframe := time.Now().UTC().Add(-3 * 24 * time.Hour)
+// accountPrices is the key of the whole account's prices in orgPrices
+const accountPrices = ""
 
-	targetMonth := time.Date(stableTimeframe.Year(), stableTimeframe.Month(), 1, 0, 0, 0, 0, time.UTC)
-	targetMonthEnd := time.Date(stableTimeframe.Year(), stableTimeframe.Month()+1, 1, 0, 0, 0, 0, time.UTC)
-	// first, get the billable usage for the month
-	opts := datadogV1.GetUsageBillableSummaryOptionalParameters{
-		Month: &targetMonth,
-	}
-	var respBillableUsage datadogV1.UsageBillableSummaryResponse
-	var err error
-	for try := 1; try <= 5; {
-		respBillableUsage, _, err = d.v1UsageApi.GetUsageBillableSummary(d.ddCtx, opts)
-		if err == nil {
-			break
-		} else {
-			if strings.Contains(err.Error(), "429") {
-				log.Errorf("rate limit reached, retrying...")
-			} else {
-				break
-			}
-			time.Sleep(30 * time.Second)
-			try++
+// forOrg returns the unit prices of an org, or the account's if DD reported no costs for the org
+func (p orgPrices) forOrg(publicID string) map[string]billableCost {
+	if prices, found := p[publicID]; found {
+		return prices
+	}
+	return p[accountPrices]
+}
+
+// orgUnitPrices calculates the unit prices of each org from its billable usage and costs
+// the unit price is the cost divided by the billable usage, for each product family
+func orgUnitPrices(usage []datadogV1.UsageBillableSummaryHour, costsByOrg map[string]map[string]decimal.Decimal) orgPrices {
+	// a parent org's billable usage may total its child orgs' usage in a single entry
+	usageByOrg := map[string]*datadogV1.UsageBillableSummaryKeys{}
+	var accountUsage []*datadogV1.UsageBillableSummaryKeys
+	for _, hour := range usage {
+		log.Debugf("usage: %v", hour)
+		if hour.Usage == nil {
+			continue
 		}
+		if hour.GetNumOrgs() > 1 {
+			accountUsage = append(accountUsage, hour.Usage)
+			continue
+		}
+		usageByOrg[hour.GetPublicId()] = hour.Usage
+	}
+	if len(accountUsage) == 0 {
+		for _, orgUsage := range usageByOrg {
+			accountUsage = append(accountUsage, orgUsage)
+		}
+	}
 
+	result := orgPrices{accountPrices: {}}
+	accountCosts := map[string]decimal.Decimal{}
+	for publicID, costs := range costsByOrg {
+		result[publicID] = map[string]billableCost{}
+		for productName, cost := range costs {
+			accountCosts[productName] = accountCosts[productName].Add(cost)
+			orgUsage, found := usageByOrg[publicID]
+			if !found {
+				continue
+			}
+			usageAmount, unit := GetAccountBillableUsage(productName, orgUsage)
+			if usageAmount == 0 {
+				continue
+			}
+			result[publicID][productName] = unitPrice(productName, cost, usageAmount, unit)
+		}
 	}
-	if err != nil {
-		return nil, fmt.Errorf("error getting usage billable usage summary: %v", err)
+
+	for productName, cost := range accountCosts {
+		var usageAmount int64
+		var unit string
+		for _, keys := range accountUsage {
+			amount, amountUnit := GetAccountBillableUsage(productName, keys)
+			usageAmount += amount
+			if amountUnit != "" {
+				unit = amountUnit
+			}
+		}
+		if usageAmount == 0 {
+			continue
+		}
+		result[accountPrices][productName] = unitPrice(productName, cost, usageAmount, unit)
 	}
-	// then, get the estimated cost for the month
-	// the start date should be the beginning of the month
-	// the end date should be the end of the last month, or the stable time frame, depending on if we are in the first 3 days of the new month or not
-	endDateToUse := targetMonthEnd
-	if time.Now().Before(targetMonthEnd) {
-		endDateToUse = stableTimeframe
+
+	// products without billable usage of the org's own are priced at the account's prices
+	for publicID, prices := range result {
+		if publicID == accountPrices {
+			continue
+		}
+		for productName, price := range result[accountPrices] {
+			if _, found := prices[productName]; !found {
+				prices[productName] = price
+			}
+		}
 	}
+	return result
+}
 
-	costOpts := datadogV2.GetEstimatedCostByOrgOptionalParameters{
-		StartDate: &targetMonth,
-		EndDate:   &endDateToUse,
+// getDDMonthCosts returns the total cost of each org's products in a month, and whether it is final. Closed months
+// are priced from DD's historical costs once it has finalized them, and from its estimated costs until then.
+func (d *DatadogCostSource) getDDMonthCosts(ctx context.Context, month time.Time, now time.Time) (map[string]map[string]decimal.Decimal, bool, error) {
+	// DD estimated costs can be delayed 72 hours
+	// so ensure we are going far enough back
+	stableTimeframe := now.Add(-ddFinalityHorizon)
+	monthEnd := month.AddDate(0, 1, 0)
+
+	// a parent org's costs are broken down by child org, or else summed into its own
+	view := "summary"
+	if d.includeChildOrgs {
+		view = "sub-org"
 	}
-	var respEstimatedCost datadogV2.CostByOrgResponse
-	for try := 1; try <= 5; {
-		respEstimatedCost, _, err = d.usageApi.GetEstimatedCostByOrg(d.ddCtx, costOpts)
 
-		if err == nil {
-			break
+	if !monthEnd.After(stableTimeframe) {
+		historical, r, err := d.usageApi.GetHistoricalCostByOrg(ctx, month, datadogV2.GetHistoricalCostByOrgOptionalParameters{View: &view, EndMonth: &month})
+		if err != nil {
+			log.Warnf("error getting historical cost by org for %s, using estimated costs: %v", month.Format("2006-01"), ddError(r, err))
+		} else if costs, ok := totalCosts(historical); ok {
+			return costs, true, nil
 		} else {
-			if strings.Contains(err.Error(), "429") {
-				log.Errorf("rate limit reached, retrying...")
-			} else {
-				break
-			}
-			time.Sleep(30 * time.Second)
-			try++
+			log.Debugf("DD has not finalized the costs for %s yet, using estimated costs", month.Format("2006-01"))
 		}
 	}
 
+	// the start date should be the beginning of the month
+	// the end date should be the end of the month, or the stable time frame if the month hasn't settled yet
+	endDateToUse := monthEnd
+	if stableTimeframe.Before(monthEnd) {
+		endDateToUse = stableTimeframe
+	}
+
+	costOpts := datadogV2.GetEstimatedCostByOrgOptionalParameters{
+		View:      &view,
+		StartDate: &month,
+		EndDate:   &endDateToUse,
+	}
+	respEstimatedCost, r, err := d.usageApi.GetEstimatedCostByOrg(ctx, costOpts)
 	if err != nil {
-		return nil, fmt.Errorf("after calling `UsageMeteringApi.GetEstimatedCostByOrg` %d times, still getting error: %v", 5, err)
+		return nil, false, fmt.Errorf("error getting estimated cost by org: %w", ddError(r, err))
 	}
 
-	// now, we need to calculate the unit prices
-	// the unit price is the estimated cost divided by the billable usage
-	// we need to do this for each product family
-	costsByFamily := make(map[string]float64)
-	latestCosts := respEstimatedCost.Data[len(respEstimatedCost.Data)-1]
-	attrs := latestCosts.Attributes
-	for _, charge := range attrs.Charges {
-		if *charge.ChargeType != "total" {
-			continue
-		}
-		costsByFamily[*charge.ProductName] = float64(*charge.Cost)
+	costs, ok := totalCosts(respEstimatedCost)
+	if !ok {
+		return nil, false, costerror.New(costerror.PartialData, "DD reported no costs for %s", month.Format("2006-01"))
 	}
+	return costs, false, nil
+}
 
-	result := make(map[string]billableCost)
-	for _, usage := range respBillableUsage.Usage {
-		log.Debugf("usage: %v", usage)
-		for productName, cost := range costsByFamily {
-			usageAmount, unit := GetAccountBillableUsage(productName, usage.Usage)
-			if usageAmount == 0 {
+// totalCosts returns the total cost of each product for each org in a cost response, keyed by org public ID.
+// DD lists each org's entries by date, so the latest entry of each org holds its costs so far.
+func totalCosts(resp datadogV2.CostByOrgResponse) (map[string]map[string]decimal.Decimal, bool) {
+	costsByOrg := make(map[string]map[string]decimal.Decimal)
+	for _, entry := range resp.Data {
+		attrs := entry.GetAttributes()
+		costsByFamily := make(map[string]decimal.Decimal)
+		for _, charge := range attrs.GetCharges() {
+			if charge.GetChargeType() != "total" {
 				continue
 			}
-			// if the product family has 'hosts' in it, then the usage is per month
-			// so we need to adjust the cost to be per hour
-			isRated := false
-			if strings.Contains(productName, "host") {
-				isRated = true
-				cost /= float64(730)
-			}
-
-			result[productName] = billableCost{
-				ProductName: productName,
-				Cost:        cost / float64(usageAmount),
-				isRated:     isRated,
-				unit:        unit,
-			}
+			costsByFamily[charge.GetProductName()] = decimal.NewFromFloat(charge.GetCost())
+		}
+		if len(costsByFamily) > 0 {
+			costsByOrg[attrs.GetPublicId()] = costsByFamily
 		}
 	}
-
-	return result, nil
+	return costsByOrg, len(costsByOrg) > 0
 }
 
 type billableCost struct {
 	ProductName string
-	Cost        float64
-	isRated     bool
-	unit        string
+	// Cost is the price of one unit of hourly usage
+	Cost    decimal.Decimal
+	isRated bool
+	unit    string
+}
+
+// unitPrice is the price of one unit of usage, given a product's cost and billable usage for a month
+func unitPrice(productName string, monthlyCost decimal.Decimal, usageAmount int64, unit string) billableCost {
+	// if the product family has 'hosts' in it, then the usage is per month
+	// so we need to adjust the cost to be per hour
+	isRated := false
+	if strings.Contains(productName, "host") {
+		isRated = true
+		monthlyCost = monthlyCost.Div(decimal.NewFromInt(hoursPerMonth))
+	}
+
+	return billableCost{
+		ProductName: productName,
+		Cost:        monthlyCost.Div(decimal.NewFromInt(usageAmount)),
+		isRated:     isRated,
+		unit:        unit,
+	}
 }
 
 // CheckAccountBillableUsage checks if any AccountBillableUsage equals one.
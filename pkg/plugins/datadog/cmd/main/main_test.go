@@ -1,13 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV1"
+	"github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+	"github.com/opencost/opencost-plugins/common/decimal"
+	"github.com/opencost/opencost-plugins/common/filter"
+	"github.com/opencost/opencost-plugins/common/finality"
 	datadogplugin "github.com/opencost/opencost-plugins/pkg/plugins/datadog/datadogplugin"
 	"github.com/opencost/opencost/core/pkg/log"
 	"github.com/opencost/opencost/core/pkg/model/pb"
+	"github.com/opencost/opencost/core/pkg/opencost"
 	"github.com/opencost/opencost/core/pkg/util/timeutil"
 	"golang.org/x/time/rate"
 	"google.golang.org/protobuf/types/known/durationpb"
@@ -49,7 +59,7 @@ func TestGetCustomCosts(t *testing.T) {
 	ddCostSrc := DatadogCostSource{
 		rateLimiter: rateLimiter,
 	}
-	ddCostSrc.ddCtx, ddCostSrc.usageApi, ddCostSrc.v1UsageApi = getDatadogClients(config)
+	ddCostSrc.ddCtx, ddCostSrc.usageApi, ddCostSrc.v1UsageApi = getDatadogClients(config.AccountList()[0])
 	windowStart := time.Date(2024, 10, 16, 0, 0, 0, 0, time.UTC)
 	// query for qty 2 of 1 hour windows
 	windowEnd := time.Date(2024, 10, 17, 0, 0, 0, 0, time.UTC)
@@ -67,3 +77,542 @@ func TestGetCustomCosts(t *testing.T) {
 		t.Fatalf("empty response")
 	}
 }
+
+func TestTimedOutWindowIsNotFinal(t *testing.T) {
+	// the request was cancelled before any window was fetched
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ddCostSrc := DatadogCostSource{ddCtx: ctx}
+
+	// the window settled long ago, but its costs were never fetched
+	windowStart := time.Date(2024, 10, 16, 0, 0, 0, 0, time.UTC)
+	req := &pb.CustomCostRequest{
+		Start:      timestamppb.New(windowStart),
+		End:        timestamppb.New(windowStart.Add(timeutil.Day)),
+		Resolution: durationpb.New(timeutil.Day),
+	}
+
+	resp := ddCostSrc.GetCustomCosts(req)
+	if len(resp) != 1 || len(resp[0].Errors) != 1 {
+		t.Fatalf("expected a timed out window, got %v", resp)
+	}
+	if status := resp[0].Metadata[finality.StatusKey]; status != string(finality.Estimated) {
+		t.Errorf("expected the timed out window to stay estimated, got %s", status)
+	}
+}
+
+func TestHourlyCostsSumToMonthlyTotal(t *testing.T) {
+	monthStart := time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC)
+	hosts := decimal.New(152387, -2)
+	logs := decimal.New(84219, -2)
+	const hostCount, logEvents = 11, 123456789
+
+	pricing := orgPrices{"abc123": {
+		"infra_host":         unitPrice("infra_host", hosts, hostCount, "hosts"),
+		"logs_indexed_15day": unitPrice("logs_indexed_15day", logs, logEvents, "events"),
+	}}
+
+	ddCostSrc := &DatadogCostSource{usagePrices: newUsagePriceMap(nil), allotments: newAllotments(nil)}
+
+	// the month's usage, reported an hour at a time: 11 hosts for 730 host hours, and the
+	// log events spread unevenly over the 720 hours of September
+	sums := map[string]decimal.Decimal{}
+	for hour := 0; hour < hoursPerMonth; hour++ {
+		start := monthStart.Add(time.Duration(hour) * time.Hour)
+		window := opencost.NewClosedWindow(start, start.Add(time.Hour))
+
+		measurements := []datadogV2.HourlyUsageMeasurement{{
+			UsageType: datadog.PtrString("infra_host_count"),
+			Value:     *datadog.NewNullableInt64(datadog.PtrInt64(hostCount)),
+		}}
+		if hour < 720 {
+			events := int64(logEvents / 720)
+			if hour < logEvents%720 {
+				events++
+			}
+			measurements = append(measurements, datadogV2.HourlyUsageMeasurement{
+				UsageType: datadog.PtrString("logs_indexed_15day_count"),
+				Value:     *datadog.NewNullableInt64(&events),
+			})
+		}
+		data := []datadogV2.HourlyUsage{{
+			Attributes: &datadogV2.HourlyUsageAttributes{
+				Measurements:  measurements,
+				OrgName:       datadog.PtrString("acme"),
+				ProductFamily: datadog.PtrString("infra_hosts"),
+				PublicId:      datadog.PtrString("abc123"),
+				Region:        datadog.PtrString("us"),
+			},
+		}}
+
+		totals := map[string]*costLine{}
+		unpriced := map[string]bool{}
+		ddCostSrc.addHourlyUsage(window, data, pricing, totals, unpriced)
+		if len(unpriced) > 0 {
+			t.Fatalf("expected all usage to be priced, got %v unpriced", unpriced)
+		}
+		var lines []*costLine
+		for _, line := range totals {
+			lines = append(lines, line)
+		}
+		for _, cost := range windowCosts(lines) {
+			sums[cost.ResourceName] = sums[cost.ResourceName].Add(decimal.NewFromFloat32(cost.BilledCost))
+		}
+	}
+
+	want := map[string]decimal.Decimal{"infra_host_count": hosts, "logs_indexed_15day_count": logs}
+	for usageType, total := range want {
+		if got := sums[usageType].Round(2); got.Cmp(total) != 0 {
+			t.Errorf("expected hourly %s costs to sum to %s, got %s", usageType, total, got)
+		}
+	}
+}
+
+func TestUnitPrice(t *testing.T) {
+	price := unitPrice("infra_host", decimal.NewFromInt(730), 2, "hosts")
+	if !price.isRated || price.Cost.String() != "0.5" {
+		t.Errorf("expected a rated price of 0.5 per host hour, got %v", price)
+	}
+
+	price = unitPrice("logs_indexed_15day", decimal.NewFromInt(3), 1000, "events")
+	if price.isRated || price.Cost.String() != "0.003" {
+		t.Errorf("expected a price of 0.003 per event, got %v", price)
+	}
+}
+
+func TestExcludeCosts(t *testing.T) {
+	line := func(productFamily, usageType string, billed decimal.Decimal) *costLine {
+		return &costLine{cost: &pb.CustomCost{ResourceType: productFamily, ResourceName: usageType}, billed: billed}
+	}
+	lines := []*costLine{
+		line("infra_hosts", "infra_host_count", decimal.New(125, -1)),
+		line("logs", "logs_indexed_15day_count", decimal.NewFromInt(3)),
+		line("logs", "ingested_events_bytes_sum", decimal.New(45, -2)),
+	}
+	filters := datadogplugin.DatadogFilters{
+		ProductFamily: filter.Filter{Exclude: []string{"logs"}},
+		UsageType:     filter.Filter{Include: []string{"*_count"}},
+	}
+
+	kept, excluded := excludeCosts(lines, filters, "USD")
+
+	if len(kept) != 1 || kept[0].cost.ResourceName != "infra_host_count" {
+		t.Errorf("expected only the infra host usage to be kept, got %v", kept)
+	}
+	if excluded.Costs != 2 || excluded.Billed["USD"].String() != "3.45" {
+		t.Errorf("expected 2 costs totalling 3.45 USD to be excluded, got %v", excluded)
+	}
+}
+
+func TestPricingMonth(t *testing.T) {
+	now := time.Date(2024, 10, 16, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		windowStart time.Time
+		now         time.Time
+		want        time.Time
+	}{
+		{"current month", time.Date(2024, 10, 15, 0, 0, 0, 0, time.UTC), now, time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)},
+		{"closed month", time.Date(2024, 7, 3, 0, 0, 0, 0, time.UTC), now, time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"month without settled costs", time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 11, 2, 0, 0, 0, 0, time.UTC), time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pricingMonth(tt.windowStart, tt.now); !got.Equal(tt.want) {
+				t.Errorf("expected a window starting %s to be priced from %s, got %s", tt.windowStart, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestUnitPricesMemoizesEachMonth(t *testing.T) {
+	now := time.Date(2024, 10, 16, 12, 0, 0, 0, time.UTC)
+	prices := newUnitPrices()
+	prices.now = func() time.Time { return now }
+
+	fetches := map[time.Time]int{}
+	fetch := func(month time.Time, final bool) func() (orgPrices, bool, error) {
+		return func() (orgPrices, bool, error) {
+			fetches[month]++
+			return orgPrices{"abc": {"infra_host": {ProductName: month.Format("2006-01")}}}, final, nil
+		}
+	}
+
+	july := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	october := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		got, final, err := prices.get(context.Background(), july, fetch(july, true))
+		if err != nil || !final || got["abc"]["infra_host"].ProductName != "2024-07" {
+			t.Fatalf("expected July's final prices, got %v, %v, %v", got, final, err)
+		}
+		if _, final, err := prices.get(context.Background(), october, fetch(october, false)); err != nil || final {
+			t.Fatalf("expected October's estimated prices, got %v, %v", final, err)
+		}
+	}
+	if fetches[july] != 1 || fetches[october] != 1 {
+		t.Errorf("expected each month to be fetched once, got %v", fetches)
+	}
+
+	// estimated prices are fetched again once they expire, final prices never are
+	now = now.Add(ddEstimatedPriceTTL)
+	prices.get(context.Background(), july, fetch(july, true))
+	prices.get(context.Background(), october, fetch(october, false))
+	if fetches[july] != 1 || fetches[october] != 2 {
+		t.Errorf("expected only October to be fetched again, got %v", fetches)
+	}
+}
+
+func TestUnitPricesRetriesFailedFetch(t *testing.T) {
+	prices := newUnitPrices()
+	month := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	_, _, err := prices.get(context.Background(), month, func() (orgPrices, bool, error) {
+		return nil, false, errors.New("rate limited")
+	})
+	if err == nil {
+		t.Fatalf("expected the fetch error")
+	}
+
+	got, _, err := prices.get(context.Background(), month, func() (orgPrices, bool, error) {
+		return orgPrices{"abc": {"infra_host": {}}}, true, nil
+	})
+	if err != nil || len(got) != 1 {
+		t.Errorf("expected the prices to be fetched again, got %v, %v", got, err)
+	}
+}
+
+func TestTotalCosts(t *testing.T) {
+	charge := func(product, chargeType string, cost float64) datadogV2.ChargebackBreakdown {
+		return datadogV2.ChargebackBreakdown{ProductName: &product, ChargeType: &chargeType, Cost: &cost}
+	}
+	entry := func(publicID string, charges ...datadogV2.ChargebackBreakdown) datadogV2.CostByOrg {
+		return datadogV2.CostByOrg{Attributes: &datadogV2.CostByOrgAttributes{PublicId: &publicID, Charges: charges}}
+	}
+	resp := datadogV2.CostByOrgResponse{Data: []datadogV2.CostByOrg{
+		entry("parent", charge("infra_host", "total", 100)),
+		entry("child", charge("infra_host", "total", 40)),
+		entry("parent",
+			charge("infra_host", "committed", 1200),
+			charge("infra_host", "total", 1523.87),
+			charge("logs_indexed_15day", "total", 842.19),
+		),
+	}}
+
+	costs, ok := totalCosts(resp)
+	parent := costs["parent"]
+	if !ok || len(parent) != 2 || parent["infra_host"].String() != "1523.87" || parent["logs_indexed_15day"].String() != "842.19" {
+		t.Errorf("expected the totals of the parent's latest entry, got %v", costs)
+	}
+	if len(costs) != 2 || costs["child"]["infra_host"].String() != "40" {
+		t.Errorf("expected the child org's totals of its own, got %v", costs)
+	}
+
+	if _, ok := totalCosts(datadogV2.CostByOrgResponse{}); ok {
+		t.Errorf("expected no costs for an empty response")
+	}
+}
+
+func TestAttributionUsageType(t *testing.T) {
+	tests := []struct {
+		usageType string
+		want      datadogV1.HourlyUsageAttributionUsageType
+		ok        bool
+	}{
+		{"infra_host_count", datadogV1.HOURLYUSAGEATTRIBUTIONUSAGETYPE_INFRA_HOST_USAGE, true},
+		{"apm_host_count", datadogV1.HOURLYUSAGEATTRIBUTIONUSAGETYPE_APM_HOST_USAGE, true},
+		{"ingested_events_bytes", datadogV1.HOURLYUSAGEATTRIBUTIONUSAGETYPE_INGESTED_LOGS_BYTES_USAGE, true},
+		{"synthetics_check_calls_count", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := attributionUsageType(tt.usageType)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("expected %s to be attributed by %q (%v), got %q (%v)", tt.usageType, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestAttributedUsage(t *testing.T) {
+	body := func(publicID, team string, usage float64) datadogV1.HourlyUsageAttributionBody {
+		body := datadogV1.HourlyUsageAttributionBody{PublicId: &publicID, TotalUsageSum: &usage}
+		if team != "" {
+			body.Tags = map[string][]string{"team": {team}}
+		}
+		return body
+	}
+	bodies := []datadogV1.HourlyUsageAttributionBody{
+		body("abc", "web", 2),
+		body("abc", "checkout", 1),
+		body("abc", "web", 3),
+		body("abc", "", 4),
+		body("other-org", "web", 100),
+	}
+
+	shares := attributedUsage(bodies, "abc", []string{"team", "service"})
+
+	if len(shares) != 3 {
+		t.Fatalf("expected usage for 3 tag combinations, got %v", shares)
+	}
+	if shares[0].key != "" || shares[0].usage.String() != "4" {
+		t.Errorf("expected 4 untagged units, got %v", shares[0])
+	}
+	if shares[1].key != "team:checkout" || shares[1].usage.String() != "1" {
+		t.Errorf("expected 1 unit for checkout, got %v", shares[1])
+	}
+	if shares[2].key != "team:web" || shares[2].tags["team"] != "web" || shares[2].usage.String() != "5" {
+		t.Errorf("expected 5 units for web, got %v", shares[2])
+	}
+}
+
+func TestSplitCost(t *testing.T) {
+	start := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	window := opencost.NewWindow(&start, &end)
+	line := &costLine{
+		cost: &pb.CustomCost{
+			Id:           "org-cost",
+			ProviderId:   "abc/infra_host_count",
+			ResourceName: "infra_host_count",
+			Labels:       map[string]string{"org": "abc"},
+		},
+		quantity: decimal.NewFromInt(6),
+		billed:   decimal.NewFromInt(30),
+		list:     decimal.NewFromInt(60),
+	}
+	shares := []tagUsage{
+		{key: "", tags: map[string]string{}, usage: decimal.NewFromInt(3)},
+		{key: "team:checkout", tags: map[string]string{"team": "checkout"}, usage: decimal.NewFromInt(1)},
+		{key: "team:web", tags: map[string]string{"team": "web"}, usage: decimal.NewFromInt(2)},
+	}
+
+	parts := splitCost(line, shares, window, "abc")
+
+	if len(parts) != 3 {
+		t.Fatalf("expected a cost for each tag combination, got %v", parts)
+	}
+	billed := decimal.Zero
+	ids := map[string]bool{}
+	for _, part := range parts {
+		billed = billed.Add(part.billed)
+		ids[part.cost.Id] = true
+		if part.cost.Labels["org"] != "abc" {
+			t.Errorf("expected the org's labels to be kept, got %v", part.cost.Labels)
+		}
+	}
+	if billed.String() != "30" || len(ids) != 3 {
+		t.Errorf("expected parts with distinct IDs totalling the original cost, got %v", parts)
+	}
+	web := parts[2]
+	if web.cost.Labels["team"] != "web" || web.billed.String() != "10" || web.list.String() != "20" || web.quantity.String() != "2" {
+		t.Errorf("expected a third of the cost for web, got %v", web)
+	}
+	if _, found := line.cost.Labels["team"]; found {
+		t.Errorf("expected the original cost to be left as it was, got %v", line.cost.Labels)
+	}
+
+	whole := splitCost(line, shares[:1], window, "abc")
+	if len(whole) != 1 || whole[0] != line {
+		t.Errorf("expected a cost with no tagged usage to be kept whole, got %v", whole)
+	}
+}
+
+func TestOrgUnitPrices(t *testing.T) {
+	billable := func(publicID string, numOrgs int64, hosts float64) datadogV1.UsageBillableSummaryHour {
+		return datadogV1.UsageBillableSummaryHour{
+			PublicId: &publicID,
+			NumOrgs:  &numOrgs,
+			Usage: &datadogV1.UsageBillableSummaryKeys{AdditionalProperties: map[string]interface{}{
+				"infra_host_sum": map[string]interface{}{"account_billable_usage": hosts, "usage_unit": "hosts"},
+			}},
+		}
+	}
+	costsByOrg := map[string]map[string]decimal.Decimal{
+		"parent": {"infra_host": decimal.NewFromInt(730)},
+		"child":  {"infra_host": decimal.NewFromInt(2190)},
+		// DD has costs for this org, but no billable usage of its own
+		"new-child": {"infra_host": decimal.NewFromInt(730)},
+	}
+
+	// each org is priced from its own usage when DD reports it
+	prices := orgUnitPrices([]datadogV1.UsageBillableSummaryHour{billable("parent", 1, 1), billable("child", 1, 1)}, costsByOrg)
+	if got := prices.forOrg("parent")["infra_host"].Cost.String(); got != "1" {
+		t.Errorf("expected the parent's hosts at 1 an hour, got %s", got)
+	}
+	if got := prices.forOrg("child")["infra_host"].Cost.String(); got != "3" {
+		t.Errorf("expected the child's hosts at 3 an hour, got %s", got)
+	}
+	// orgs without usage of their own are priced at the account's prices
+	if got := prices.forOrg("new-child")["infra_host"].Cost.String(); got != "2.5" {
+		t.Errorf("expected the account's price of 2.5 an hour, got %s", got)
+	}
+	if got := prices.forOrg("unknown")["infra_host"].Cost.String(); got != "2.5" {
+		t.Errorf("expected the account's price of 2.5 an hour, got %s", got)
+	}
+
+	// a parent's usage totalled across its orgs prices every org at the account's prices
+	prices = orgUnitPrices([]datadogV1.UsageBillableSummaryHour{billable("parent", 3, 5)}, costsByOrg)
+	if got := prices.forOrg("child")["infra_host"].Cost.String(); got != "1" {
+		t.Errorf("expected the account's price of 1 an hour, got %s", got)
+	}
+}
+
+func TestPostProcessKeepsOrgsApart(t *testing.T) {
+	line := func(publicID, usageType string, quantity int64) *costLine {
+		cost := &pb.CustomCost{ProviderId: publicID + "/" + usageType, ResourceName: usageType}
+		return &costLine{cost: cost, quantity: decimal.NewFromInt(quantity), billed: decimal.NewFromInt(1)}
+	}
+	start := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	window := opencost.NewClosedWindow(start, start.Add(time.Hour))
+	lines := []*costLine{
+		line("parent", "dbm_host_count", 10),
+		line("parent", "dbm_queries_count", 500),
+		line("child", "dbm_queries_count", 500),
+	}
+	pricing := orgPrices{accountPrices: {"dbm_queries": {Cost: decimal.New(1, -2)}}}
+	ddCostSrc := &DatadogCostSource{usagePrices: newUsagePriceMap(nil), allotments: newAllotments(nil)}
+
+	lines, unpriced := ddCostSrc.postProcess(lines, window, pricing)
+
+	// the queries included with the parent's hosts don't cover the child's
+	quantities := map[string]string{}
+	for _, l := range lines {
+		quantities[l.cost.ProviderId] = l.quantity.String()
+	}
+	if len(unpriced) != 0 || len(lines) != 2 || quantities["parent/dbm_host_count"] != "10" || quantities["child/dbm_queries_count/overage"] != "500" {
+		t.Errorf("expected the parent's queries to be included with its hosts and the child's to be overage, got %v", quantities)
+	}
+}
+
+func TestMatchUsageToPricing(t *testing.T) {
+	pricing := map[string]billableCost{
+		"infra_host":         {ProductName: "infra_host"},
+		"apm_host":           {ProductName: "apm_host"},
+		"logs_ingested":      {ProductName: "logs_ingested"},
+		"ingested_spans":     {ProductName: "ingested_spans"},
+		"logs_indexed_15day": {ProductName: "logs_indexed_15day"},
+	}
+	mapping := newUsagePriceMap(map[string]string{
+		"apm_azure_app_service_host_count": "apm_host",
+		"logs_indexed_events_15_day_count": "",
+	})
+
+	tests := []struct {
+		productFamily, usageType string
+		wantProduct              string
+		wantPriced, wantBilled   bool
+	}{
+		{"infra_hosts", "agent_host_count", "infra_host", true, true},
+		{"infra_hosts", "apm_host_count", "apm_host", true, true},
+		{"infra_hosts", "apm_azure_app_service_host_count", "apm_host", true, true},
+		{"logs", "ingested_events_bytes", "logs_ingested", true, true},
+		{"ingested_spans", "ingested_events_bytes", "ingested_spans", true, true},
+		{"infra_hosts", "host_count", "", false, false},
+		{"infra_hosts", "container_count_excl_agent", "infra_container", false, true},
+		{"infra_hosts", "container_count", "", false, false},
+		{"logs", "logs_indexed_events_15_day_count", "", false, false},
+		// a new usage type isn't priced as a product with a similar name
+		{"infra_hosts", "apm_host_enterprise_count", "apm_host_enterprise", false, true},
+	}
+
+	for _, tt := range tests {
+		product, price, billed := matchUsageToPricing(tt.productFamily, tt.usageType, mapping, pricing)
+		if product != tt.wantProduct || (price != nil) != tt.wantPriced || billed != tt.wantBilled {
+			t.Errorf("%s/%s: expected %q (priced %v, billed %v), got %q (%v, %v)", tt.productFamily, tt.usageType, tt.wantProduct, tt.wantPriced, tt.wantBilled, product, price, billed)
+		}
+	}
+}
+
+func TestReportUnpriced(t *testing.T) {
+	start := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	window := opencost.NewClosedWindow(start, start.Add(time.Hour))
+	measurement := func(usageType string, value int64) datadogV2.HourlyUsageMeasurement {
+		return datadogV2.HourlyUsageMeasurement{UsageType: &usageType, Value: *datadog.NewNullableInt64(&value)}
+	}
+	data := []datadogV2.HourlyUsage{{
+		Attributes: &datadogV2.HourlyUsageAttributes{
+			Measurements: []datadogV2.HourlyUsageMeasurement{
+				measurement("infra_host_count", 3),
+				measurement("host_count", 3),
+				measurement("gpu_count", 2),
+			},
+			OrgName:       datadog.PtrString("acme"),
+			ProductFamily: datadog.PtrString("infra_hosts"),
+			PublicId:      datadog.PtrString("abc123"),
+			Region:        datadog.PtrString("us"),
+		},
+	}}
+	pricing := orgPrices{"abc123": {"infra_host": unitPrice("infra_host", decimal.NewFromInt(730), 1, "hosts")}}
+
+	costs := map[string]*costLine{}
+	unpriced := map[string]bool{}
+	ddCostSrc := &DatadogCostSource{usagePrices: newUsagePriceMap(nil), allotments: newAllotments(nil)}
+	ddCostSrc.addHourlyUsage(window, data, pricing, costs, unpriced)
+
+	if len(costs) != 1 || costs["abc123/infra_host_count"] == nil {
+		t.Errorf("expected only the infra hosts to be priced, got %v", costs)
+	}
+
+	resp := &pb.CustomCostResponse{}
+	reportUnpriced(resp, unpriced)
+	if resp.Metadata[unpricedUsageKey] != "infra_hosts/gpu_count" {
+		t.Errorf("expected the GPU usage to be listed as unpriced, got %v", resp.Metadata)
+	}
+	if len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0], "infra_hosts/gpu_count") {
+		t.Errorf("expected an error naming the unpriced usage, got %v", resp.Errors)
+	}
+}
+
+func TestApplyAllotments(t *testing.T) {
+	start := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	window := opencost.NewClosedWindow(start, start.Add(time.Hour))
+	line := func(productFamily, usageType string, quantity int64, billed decimal.Decimal) *costLine {
+		cost := &pb.CustomCost{
+			Id:           usageType,
+			ProviderId:   "abc/" + usageType,
+			ResourceType: productFamily,
+			ResourceName: usageType,
+		}
+		return &costLine{cost: cost, quantity: decimal.NewFromInt(quantity), billed: billed}
+	}
+	lines := []*costLine{
+		line("infra_hosts", "agent_host_count", 2, decimal.New(3, -1)),
+		line("infra_hosts", "aws_host_count", 1, decimal.New(15, -2)),
+		// 3 hosts include 15 containers and 300 custom metrics
+		line("infra_hosts", "container_count_excl_agent", 20, decimal.Zero),
+		line("timeseries", "num_custom_timeseries", 250, decimal.New(125, -1)),
+		line("profiling", "profiling_container_agent_count", 3, decimal.Zero),
+	}
+	prices := map[string]billableCost{
+		"infra_container": {Cost: decimal.New(2, -3), unit: "containers"},
+		"timeseries":      {Cost: decimal.New(5, -2)},
+	}
+	// profiled containers have no price, but a configured allotment includes one with each host
+	rules := newAllotments([]datadogplugin.DatadogAllotment{{Product: "prof_container", PerProduct: "infra_host", PerUnit: 1}})
+
+	lines, unpriced := applyAllotments(window, lines, rules, newUsagePriceMap(nil), prices)
+
+	byProviderID := map[string]*costLine{}
+	for _, l := range lines {
+		byProviderID[l.cost.ProviderId] = l
+	}
+	// the usage included with the hosts is left out, and only the overage is billed
+	if byProviderID["abc/container_count_excl_agent"] != nil {
+		t.Errorf("expected the 15 containers included with the hosts to be left out")
+	}
+	if overage := byProviderID["abc/container_count_excl_agent/overage"]; overage == nil || overage.quantity.String() != "5" || overage.billed.String() != "0.01" || overage.cost.UsageUnit != "containers" || overage.cost.Id == "container_count_excl_agent" {
+		t.Errorf("expected 5 containers of overage at 0.002 each, got %v", overage)
+	}
+	if byProviderID["abc/num_custom_timeseries"] != nil || byProviderID["abc/num_custom_timeseries/overage"] != nil {
+		t.Errorf("expected the custom metrics to be included with the hosts")
+	}
+	if len(unpriced) != 0 || byProviderID["abc/profiling_container_agent_count"] != nil || len(lines) != 3 {
+		t.Errorf("expected the profiled containers to be included with the hosts, got %v unpriced", unpriced)
+	}
+
+	// overage without a price is left out and reported
+	lines, unpriced = applyAllotments(window, []*costLine{line("infra_hosts", "container_count_excl_agent", 20, decimal.Zero)}, rules, newUsagePriceMap(nil), nil)
+	if len(lines) != 0 || len(unpriced) != 1 || unpriced[0] != "infra_hosts/container_count_excl_agent" {
+		t.Errorf("expected the unpriced container overage to be reported, got %v, %v", lines, unpriced)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ddEstimatedPriceTTL is how long unit prices derived from DD's estimated costs are reused before they are
+// fetched again. Prices derived from a closed month's historical costs never change, and are kept for good.
+const ddEstimatedPriceTTL = time.Hour
+
+// pricingMonth returns the month whose costs price a window starting at windowStart: the month containing
+// the window, unless DD has no settled costs for it yet, in which case the latest month it does have.
+func pricingMonth(windowStart, now time.Time) time.Time {
+	month := startOfMonth(windowStart)
+	if stable := now.Add(-ddFinalityHorizon); month.After(stable) {
+		return startOfMonth(stable)
+	}
+	return month
+}
+
+func startOfMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// unitPrices memoizes the unit prices of each org in each month across windows and requests, so that the windows
+// of a month share a single fetch. A nil *unitPrices is valid, and fetches prices every time.
+type unitPrices struct {
+	mu     sync.Mutex
+	months map[time.Time]*monthPrices
+	now    func() time.Time
+}
+
+// monthPrices are the unit prices of one month, once done is closed
+type monthPrices struct {
+	done   chan struct{}
+	prices orgPrices
+	err    error
+	final  bool
+	// expires is when the prices are fetched again, or zero if they are final
+	expires time.Time
+}
+
+func newUnitPrices() *unitPrices {
+	return &unitPrices{months: map[time.Time]*monthPrices{}, now: time.Now}
+}
+
+// get returns the memoized prices for a month and whether they are final, or calls fetch for them. Prices
+// that failed to fetch are not memoized, so the next window tries again.
+func (u *unitPrices) get(ctx context.Context, month time.Time, fetch func() (orgPrices, bool, error)) (orgPrices, bool, error) {
+	if u == nil {
+		return fetch()
+	}
+
+	u.mu.Lock()
+	entry, found := u.months[month]
+	if found && entry.isExpired(u.now()) {
+		found = false
+	}
+	if !found {
+		entry = &monthPrices{done: make(chan struct{})}
+		u.months[month] = entry
+	}
+	u.mu.Unlock()
+
+	if found {
+		// another window may still be fetching the month's prices
+		select {
+		case <-entry.done:
+			return entry.prices, entry.final, entry.err
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+
+	prices, final, err := fetch()
+	entry.prices, entry.final, entry.err = prices, final, err
+	if !final {
+		entry.expires = u.now().Add(ddEstimatedPriceTTL)
+	}
+
+	u.mu.Lock()
+	if err != nil && u.months[month] == entry {
+		delete(u.months, month)
+	}
+	u.mu.Unlock()
+	close(entry.done)
+
+	return prices, final, err
+}
+
+// isExpired reports whether a fetched month's prices should be fetched again. Prices still being fetched
+// never expire.
+func (m *monthPrices) isExpired(now time.Time) bool {
+	select {
+	case <-m.done:
+		return !m.expires.IsZero() && !now.Before(m.expires)
+	default:
+		return false
+	}
+}
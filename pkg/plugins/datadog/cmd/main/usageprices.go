@@ -0,0 +1,132 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/opencost/opencost-plugins/common/costerror"
+	"github.com/opencost/opencost-plugins/common/metrics"
+	"github.com/opencost/opencost/core/pkg/log"
+	"github.com/opencost/opencost/core/pkg/model/pb"
+)
+
+// unpricedUsageKey is the response Metadata key listing the usage left out of a window's costs as no
+// product price was mapped to it, as comma separated product_family/usage_type pairs
+const unpricedUsageKey = "unpriced_usage"
+
+// unpricedUsage counts the usage types left out of a window's costs, as no product price was mapped to them
+var unpricedUsage = metrics.NewCounter("datadog_unpriced_usage_total", "Datadog usage types left out of a window's costs, as no product price was mapped to them.")
+
+// builtinUsagePrices maps the usage types whose billed product can't be derived from their name. Usage
+// mapped to "" is not billed on its own, and is left out without being reported.
+var builtinUsagePrices = map[string]string{
+	// host_count totals the hosts that the other host counts break down
+	"host_count":               "",
+	"infra_host_count":         "infra_host",
+	"agent_host_count":         "infra_host",
+	"alibaba_host_count":       "infra_host",
+	"aws_host_count":           "infra_host",
+	"azure_host_count":         "infra_host",
+	"gcp_host_count":           "infra_host",
+	"heroku_host_count":        "infra_host",
+	"opentelemetry_host_count": "infra_host",
+	"vsphere_host_count":       "infra_host",
+	// APM hosts are billed alike, wherever they run
+	"apm_azure_app_service_host_count": "apm_host",
+	"opentelemetry_apm_host_count":     "apm_host",
+	// containers are billed without the agent's own, and each host includes some, as per allotments
+	"container_count":            "",
+	"container_count_excl_agent": "infra_container",
+
+	// indexed_events_count totals the log events that are billed by retention period, and the live
+	// indexed counts are included in those retention periods
+	"logs/indexed_events_count":             "",
+	"logs/ingested_events_bytes":            "logs_ingested",
+	"logs_indexed_events_3_day_count":       "logs_indexed_3day",
+	"logs_indexed_events_7_day_count":       "logs_indexed_7day",
+	"logs_indexed_events_15_day_count":      "logs_indexed_15day",
+	"logs_indexed_events_30_day_count":      "logs_indexed_30day",
+	"logs_live_indexed_count":               "",
+	"logs_live_indexed_events_15_day_count": "",
+
+	"indexed_spans/indexed_events_count":   "indexed_spans",
+	"ingested_spans/ingested_events_bytes": "ingested_spans",
+	"num_custom_timeseries":                "timeseries",
+	"profiling_host_count":                 "prof_host",
+	"profiling_container_agent_count":      "prof_container",
+	"check_calls_count":                    "synthetics_api_tests",
+	"browser_check_calls_count":            "synthetics_browser_checks",
+}
+
+// usagePriceMap maps usage to the product it is billed as. Keys are either a usage type, or a product
+// family and usage type such as "logs/ingested_events_bytes" for usage types several families report.
+type usagePriceMap map[string]string
+
+// newUsagePriceMap returns the built-in mapping, extended and overridden by the configured one
+func newUsagePriceMap(configured map[string]string) usagePriceMap {
+	mapping := usagePriceMap{}
+	for usage, product := range builtinUsagePrices {
+		mapping[usage] = product
+	}
+	for usage, product := range configured {
+		mapping[usage] = product
+	}
+	return mapping
+}
+
+// product returns the product usage is mapped to, preferring a mapping for its product family
+func (m usagePriceMap) product(productFamily, usageType string) (string, bool) {
+	if product, found := m[productFamily+"/"+usageType]; found {
+		return product, true
+	}
+	product, found := m[usageType]
+	return product, found
+}
+
+// billedProduct returns the product usage is billed as. Usage that isn't mapped is billed as the product
+// named like its usage type, without a _count or _sum suffix. billed is false for usage that is
+// deliberately not priced.
+func (m usagePriceMap) billedProduct(productFamily, usageType string) (product string, billed bool) {
+	product, mapped := m.product(productFamily, usageType)
+	if !mapped {
+		return strings.TrimSuffix(strings.TrimSuffix(usageType, "_count"), "_sum"), true
+	}
+	return product, product != ""
+}
+
+// matchUsageToPricing returns the product usage is billed as and its unit price. The price is nil when no
+// price was found, rather than guessed from a similar product. billed is false for usage that is
+// deliberately not priced.
+func matchUsageToPricing(productFamily, usageType string, mapping usagePriceMap, pricing map[string]billableCost) (product string, price *billableCost, billed bool) {
+	product, billed = mapping.billedProduct(productFamily, usageType)
+	if !billed {
+		return "", nil, false
+	}
+
+	if entry, found := pricing[product]; found {
+		return product, &entry, true
+	}
+	return product, nil, true
+}
+
+// reportUnpriced lists the usage left out of a window's costs in resp's Metadata, and reports it as an
+// error so it isn't mistaken for a complete bill. Nothing is reported when all usage was priced.
+func reportUnpriced(resp *pb.CustomCostResponse, unpriced map[string]bool) {
+	if resp == nil || len(unpriced) == 0 {
+		return
+	}
+	if resp.Metadata == nil {
+		resp.Metadata = map[string]string{}
+	}
+
+	usage := make([]string, 0, len(unpriced))
+	for key := range unpriced {
+		usage = append(usage, key)
+	}
+	sort.Strings(usage)
+	resp.Metadata[unpricedUsageKey] = strings.Join(usage, ",")
+	unpricedUsage.Add(float64(len(usage)))
+
+	log.Warnf("no price for DD usage %s", strings.Join(usage, ", "))
+	costerror.Add(resp, costerror.New(costerror.PartialData, "no price for DD usage %s, map it to a product under usage_prices to price it", strings.Join(usage, ", ")))
+}
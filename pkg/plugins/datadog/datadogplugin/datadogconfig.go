@@ -1,8 +1,113 @@
 package datadog
 
+import (
+	"fmt"
+
+	"github.com/opencost/opencost-plugins/common/accounts"
+	"github.com/opencost/opencost-plugins/common/budget"
+	"github.com/opencost/opencost-plugins/common/cache"
+	"github.com/opencost/opencost-plugins/common/currency"
+	"github.com/opencost/opencost-plugins/common/executor"
+	"github.com/opencost/opencost-plugins/common/filter"
+	"github.com/opencost/opencost-plugins/common/labels"
+	"github.com/opencost/opencost-plugins/common/metrics"
+)
+
 type DatadogConfig struct {
-	DDSite     string `json:"datadog_site"`
-	DDAPIKey   string `json:"datadog_api_key"`
-	DDAppKey   string `json:"datadog_app_key"`
-	DDLogLevel string `json:"log_level"`
+	DDSite   string `json:"datadog_site" oneof:"datadoghq.com us3.datadoghq.com us5.datadoghq.com datadoghq.eu ap1.datadoghq.com ddog-gov.com"`
+	DDAPIKey string `json:"datadog_api_key"`
+	DDAppKey string `json:"datadog_app_key"`
+	// Accounts lists the orgs to report costs for, each with its own keys, in place of the keys above
+	Accounts []DatadogAccount `json:"accounts"`
+	// IncludeChildOrgs reports the usage of each child org of a parent org's keys, under the child's own name
+	IncludeChildOrgs bool   `json:"include_child_orgs"`
+	DDLogLevel       string `json:"log_level" default:"info" oneof:"trace debug info warn error"`
+	// Filters leave out usage that is already allocated elsewhere
+	Filters DatadogFilters `json:"filters"`
+	// UsagePrices maps usage types, or product families and usage types such as "logs/ingested_events_bytes",
+	// to the product they are billed as, extending and overriding the plugin's built-in mapping. Usage mapped
+	// to "" is left out of costs. Usage without a mapped price is left out and listed in each response.
+	UsagePrices map[string]string `json:"usage_prices"`
+	// Allotments replace the plugin's built-in allotments of free usage for the same products, such as to
+	// match an Enterprise plan's larger allotments
+	Allotments []DatadogAllotment `json:"allotments"`
+	// AttributionTags are the tag keys, such as "team" or "kube_namespace", that each product's cost is split
+	// across with DD's usage attribution. Costs are reported for the whole org when unset.
+	AttributionTags []string `json:"attribution_tags"`
+	cache.CacheConfig
+	budget.BudgetConfig
+	executor.ExecutorConfig
+	metrics.MetricsConfig
+	currency.CurrencyConfig
+	labels.LabelsConfig
+}
+
+// DatadogFilters select the usage costs are reported for
+type DatadogFilters struct {
+	// ProductFamily matches the product family of the usage, such as "logs" or "infra_hosts"
+	ProductFamily filter.Filter `json:"product_family"`
+	// UsageType matches the usage type, such as "ingested_events_bytes"
+	UsageType filter.Filter `json:"usage_type"`
+}
+
+// Keeps reports whether the filters keep usage of a product family and usage type
+func (f DatadogFilters) Keeps(productFamily, usageType string) bool {
+	return f.ProductFamily.Keeps(productFamily) && f.UsageType.Keeps(usageType)
+}
+
+// DatadogAllotment is free usage of a product that DD bundles with each unit of hourly usage of another,
+// such as the containers included with each infra host
+type DatadogAllotment struct {
+	// Product is the product the allotment covers, such as "infra_container"
+	Product string `json:"product" required:"true"`
+	// PerProduct is the product each unit of which includes PerUnit units of Product, such as "infra_host".
+	// An allotment of zero units removes the built-in allotment for Product.
+	PerProduct string  `json:"per_product" required:"true"`
+	PerUnit    float64 `json:"per_unit" min:"0"`
+	// OveragePrice is the product whose unit price usage beyond the allotment is billed at. It defaults to Product.
+	OveragePrice string `json:"overage_price"`
+}
+
+// DatadogAccount is one of several orgs reported on by a single plugin
+type DatadogAccount struct {
+	Name     string `json:"name" required:"true"`
+	DDSite   string `json:"datadog_site" required:"true" oneof:"datadoghq.com us3.datadoghq.com us5.datadoghq.com datadoghq.eu ap1.datadoghq.com ddog-gov.com"`
+	DDAPIKey string `json:"datadog_api_key" required:"true"`
+	DDAppKey string `json:"datadog_app_key" required:"true"`
+}
+
+// maxAttributionTags is the most tag keys DD's usage attribution can break usage down by
+const maxAttributionTags = 3
+
+// Validate requires either the keys of a single org or a list of accounts, and checks the attribution tags.
+func (c *DatadogConfig) Validate() []string {
+	var names []string
+	for _, account := range c.Accounts {
+		names = append(names, account.Name)
+	}
+	problems := accounts.ConfigProblems(names,
+		accounts.Field{Key: "datadog_site", Value: c.DDSite},
+		accounts.Field{Key: "datadog_api_key", Value: c.DDAPIKey},
+		accounts.Field{Key: "datadog_app_key", Value: c.DDAppKey},
+	)
+
+	// usage attribution breaks usage down by at most three tags
+	if len(c.AttributionTags) > maxAttributionTags {
+		problems = append(problems, fmt.Sprintf("attribution_tags can have at most %d tag keys, got %d", maxAttributionTags, len(c.AttributionTags)))
+	}
+	for i, tag := range c.AttributionTags {
+		if tag == "" {
+			problems = append(problems, fmt.Sprintf("attribution_tags[%d] must not be empty", i))
+		}
+	}
+	return problems
+}
+
+// AccountList returns the orgs to report costs for. A config with its keys at the top level has a
+// single account without a name.
+func (c *DatadogConfig) AccountList() []DatadogAccount {
+	if len(c.Accounts) > 0 {
+		return c.Accounts
+	}
+	return []DatadogAccount{{DDSite: c.DDSite, DDAPIKey: c.DDAPIKey, DDAppKey: c.DDAppKey}}
 }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Check verifies the configured API key before the plugin serves any requests, by requesting
+// the organization's pending invoice. Reading invoices needs the Organization Billing Viewer role.
+func (a *AtlasCostSource) Check(ctx context.Context) error {
+	request, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf(costExplorerPendingInvoicesURL, a.orgID), nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/vnd.atlas.2023-01-01+json")
+
+	response, err := a.atlasClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("could not read pending invoices: %v", err)
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return fmt.Errorf("could not read pending invoices: atlas_public_key and atlas_private_key were rejected. check the key pair, and that this host's IP is on the key's access list (HTTP %d)", response.StatusCode)
+	case http.StatusForbidden:
+		return fmt.Errorf("could not read pending invoices: the API key needs the Organization Billing Viewer role in organization %s (HTTP %d)", a.orgID, response.StatusCode)
+	case http.StatusNotFound:
+		return fmt.Errorf("could not read pending invoices: organization %s was not found, check atlas_org_id (HTTP %d)", a.orgID, response.StatusCode)
+	default:
+		return fmt.Errorf("could not read pending invoices: unexpected response: HTTP %d", response.StatusCode)
+	}
+}
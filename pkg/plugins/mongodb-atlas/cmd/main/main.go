@@ -1,15 +1,25 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"time"
 
-	"github.com/hashicorp/go-plugin"
 	"github.com/icholy/digest"
-	commonconfig "github.com/opencost/opencost-plugins/common/config"
+	"github.com/opencost/opencost-plugins/common/accounts"
+	"github.com/opencost/opencost-plugins/common/budget"
+	"github.com/opencost/opencost-plugins/common/costerror"
+	"github.com/opencost/opencost-plugins/common/costid"
+	"github.com/opencost/opencost-plugins/common/decimal"
+	"github.com/opencost/opencost-plugins/common/executor"
+	"github.com/opencost/opencost-plugins/common/filter"
+	"github.com/opencost/opencost-plugins/common/finality"
+	"github.com/opencost/opencost-plugins/common/httpclient"
+	"github.com/opencost/opencost-plugins/common/metrics"
+	"github.com/opencost/opencost-plugins/common/runtime"
 	atlasconfig "github.com/opencost/opencost-plugins/pkg/plugins/mongodb-atlas/config"
 	atlasplugin "github.com/opencost/opencost-plugins/pkg/plugins/mongodb-atlas/plugin"
 	"github.com/opencost/opencost/core/pkg/log"
@@ -18,64 +28,54 @@ import (
 	ocplugin "github.com/opencost/opencost/core/pkg/plugin"
 	"golang.org/x/time/rate"
 	"google.golang.org/protobuf/types/known/timestamppb"
-	"k8s.io/apimachinery/pkg/util/uuid"
 )
 
-// handshakeConfigs are used to just do a basic handshake between
-// a plugin and host. If the handshake fails, a user friendly error is shown.
-// This prevents users from executing bad plugins or executing a plugin
-// directory. It is a UX feature, not a security feature.
-var handshakeConfig = plugin.HandshakeConfig{
-	ProtocolVersion:  1,
-	MagicCookieKey:   "PLUGIN_NAME",
-	MagicCookieValue: "mongodb-atlas",
-}
-
 const costExplorerPendingInvoicesURL = "https://cloud.mongodb.com/api/atlas/v2/orgs/%s/invoices/pending"
 
-func main() {
-	log.Debug("Initializing Mongo plugin")
+// clusterNameKey is the cost Metadata key holding the cluster a line item was billed for
+const clusterNameKey = "cluster_name"
 
-	configFile, err := commonconfig.GetConfigFilePath()
-	if err != nil {
-		log.Fatalf("error opening config file: %v", err)
-	}
+// droppedLineItems counts invoice line items left out of a window's costs
+var droppedLineItems = metrics.NewCounterVec("atlas_line_items_dropped_total", "Atlas invoice line items left out of a window, because their dates could not be parsed, they do not fall entirely within the window or the filters exclude them.", "reason")
+
+func main() {
+	runtime.Plugin{
+		Name:        "mongodb-atlas",
+		LogLevelKey: "atlas_plugin_log_level",
+		NewSource:   newAtlasCostSource,
+	}.Serve()
+}
 
-	atlasConfig, err := atlasconfig.GetAtlasConfig(configFile)
+func newAtlasCostSource(configBytes []byte) (ocplugin.CustomCostSource, error) {
+	atlasConfig, err := atlasconfig.ParseAtlasConfig(configBytes)
 	if err != nil {
-		log.Fatalf("error building Atlas config: %v", err)
-	}
-	log.SetLogLevel(atlasConfig.LogLevel)
-
-	// as per https://www.mongodb.com/docs/atlas/api/atlas-admin-api-ref/,
-	// atlas admin APIs have a limit of 100 requests per minute
-	rateLimiter := rate.NewLimiter(1.1, 2)
-	atlasCostSrc := AtlasCostSource{
-		rateLimiter: rateLimiter,
-		orgID:       atlasConfig.OrgID,
+		return nil, fmt.Errorf("error building Atlas config: %v", err)
 	}
-	atlasCostSrc.atlasClient = getAtlasClient(*atlasConfig)
 
-	// pluginMap is the map of plugins we can dispense.
-	var pluginMap = map[string]plugin.Plugin{
-		"CustomCostSource": &ocplugin.CustomCostPlugin{Impl: &atlasCostSrc},
+	var orgs []accounts.Account
+	for _, account := range atlasConfig.AccountList() {
+		atlasCostSrc := AtlasCostSource{
+			// as per https://www.mongodb.com/docs/atlas/api/atlas-admin-api-ref/,
+			// atlas admin APIs have a limit of 100 requests per minute
+			rateLimiter: rate.NewLimiter(1.1, 2),
+			orgID:       account.OrgID,
+			budget:      atlasConfig.BudgetConfig,
+			executor:    atlasConfig.ExecutorConfig,
+			filters:     atlasConfig.Filters,
+		}
+		atlasCostSrc.atlasClient = getAtlasClient(account)
+		orgs = append(orgs, accounts.Account{Name: account.Name, Source: &atlasCostSrc})
 	}
 
-	plugin.Serve(&plugin.ServeConfig{
-		HandshakeConfig: handshakeConfig,
-		Plugins:         pluginMap,
-		GRPCServer:      plugin.DefaultGRPCServer,
-	})
-
+	return accounts.Source(orgs), nil
 }
 
-func getAtlasClient(atlasConfig atlasconfig.AtlasConfig) HTTPClient {
-	return &http.Client{
-		Transport: &digest.Transport{
-			Username: atlasConfig.PublicKey,
-			Password: atlasConfig.PrivateKey,
-		},
-	}
+func getAtlasClient(account atlasconfig.AtlasAccount) HTTPClient {
+	// retries wrap the digest transport, so each attempt answers a fresh challenge
+	return httpclient.NewClient(&digest.Transport{
+		Username: account.PublicKey,
+		Password: account.PrivateKey,
+	})
 }
 
 // Implementation of CustomCostSource
@@ -83,6 +83,9 @@ type AtlasCostSource struct {
 	orgID       string
 	rateLimiter *rate.Limiter
 	atlasClient HTTPClient
+	budget      budget.BudgetConfig
+	executor    executor.ExecutorConfig
+	filters     atlasconfig.AtlasFilters
 }
 
 type HTTPClient interface {
@@ -122,50 +125,54 @@ func (a *AtlasCostSource) GetCustomCosts(req *pb.CustomCostRequest) []*pb.Custom
 
 	requestErrors := validateRequest(req)
 	if len(requestErrors) > 0 {
-		//return empty response
+		// report why the request was rejected, rather than returning nothing
+		errResp := &pb.CustomCostResponse{}
+		for _, msg := range requestErrors {
+			costerror.Add(errResp, costerror.New(costerror.UnsupportedRequest, "%s", msg))
+		}
+		results = append(results, errResp)
 		return results
 	}
 
 	targets, err := opencost.GetWindows(req.Start.AsTime(), req.End.AsTime(), req.Resolution.AsDuration())
 	if err != nil {
 		log.Errorf("error getting windows: %v", err)
-		errResp := pb.CustomCostResponse{
-			Errors: []string{fmt.Sprintf("error getting windows: %v", err)},
-		}
-		results = append(results, &errResp)
+		results = append(results, costerror.Response(costerror.New(costerror.UnsupportedRequest, "error getting windows: %v", err)))
 		return results
 	}
 
-	lineItems, err := GetPendingInvoices(a.orgID, a.atlasClient)
+	ctx, cancel := a.budget.Start(context.Background())
+	defer cancel()
+
+	lineItems, err := GetPendingInvoices(ctx, a.orgID, a.atlasClient)
 
 	if err != nil {
 		log.Errorf("Error fetching invoices: %v", err)
-		errResp := pb.CustomCostResponse{
-			Errors: []string{fmt.Sprintf("error fetching invoices: %v", err)},
-		}
-		results = append(results, &errResp)
+		results = append(results, costerror.Response(fmt.Errorf("error fetching invoices: %w", err)))
 		return results
 
 	}
 
-	for _, target := range targets {
+	return a.executor.Run(targets, func(target opencost.Window) (*pb.CustomCostResponse, error) {
 		if target.Start().After(time.Now().UTC()) {
 			log.Debugf("skipping future window %v", target)
-			continue
+			return nil, nil
 		}
 
 		log.Debugf("fetching atlas costs for window %v", target)
-		result := a.getAtlasCostsForWindow(&target, lineItems)
-
-		results = append(results, result)
-
-	}
-
-	return results
+		resp := a.getAtlasCostsForWindow(&target, lineItems)
+		// costs come from the pending invoice, which atlas revises until the month closes. a window with
+		// errors stays provisional
+		finality.Mark(resp, finality.Provisional, finality.MonthClose(*target.End()), time.Now())
+		return resp, nil
+	})
 }
 
-func filterLineItemsByWindow(win *opencost.Window, lineItems []atlasplugin.LineItem) []*pb.CustomCost {
+// filterLineItemsByWindow converts the line items that fall within a window and are kept by the filters
+// into costs, and totals the line items in the window the filters leave out
+func filterLineItemsByWindow(win *opencost.Window, lineItems []atlasplugin.LineItem, filters atlasconfig.AtlasFilters) ([]*pb.CustomCost, filter.Excluded) {
 	var filteredItems []*pb.CustomCost
+	var excluded filter.Excluded
 
 	winStartUTC := win.Start().UTC()
 	winEndUTC := win.End().UTC()
@@ -184,6 +191,7 @@ func filterLineItemsByWindow(win *opencost.Window, lineItems []atlasplugin.LineI
 			if err2 != nil {
 				log.Warnf("%s", err2)
 			}
+			droppedLineItems.WithLabelValues("unparseable_dates").Inc()
 			continue
 		}
 
@@ -193,25 +201,38 @@ func filterLineItemsByWindow(win *opencost.Window, lineItems []atlasplugin.LineI
 			ChargeCategory: "Usage",
 			Description:    fmt.Sprintf("Usage for %s", item.SKU),
 			ResourceName:   item.SKU,
-			Id:             string(uuid.NewUUID()),
 			ProviderId:     fmt.Sprintf("%s/%s/%s", item.GroupId, item.ClusterName, item.SKU),
-			BilledCost:     float32(item.TotalPriceCents) / 100.0,
-			ListCost:       item.Quantity * item.UnitPriceDollars,
+			BilledCost:     decimal.New(int64(item.TotalPriceCents), -2).Float32(),
+			ListCost:       decimal.NewFromFloat32(item.Quantity).Mul(decimal.NewFromFloat32(item.UnitPriceDollars)).Float32(),
 			ListUnitPrice:  item.UnitPriceDollars,
 			UsageQuantity:  item.Quantity,
 			UsageUnit:      item.Unit,
 		}
+		// line items are daily, so several in a longer window can share a provider ID
+		customCost.Id = costid.New("mongodb-atlas", item.GroupId, *win.Start(), *win.End(), customCost.ProviderId, item.StartDate)
+		if item.ClusterName != "" {
+			// the project is the account name, so the cluster is the finest grain label rules can match on
+			customCost.Metadata = map[string]string{clusterNameKey: item.ClusterName}
+		}
 
 		log.Debugf("Line Item %s %s", startDate.UTC(), endDate.UTC())
 		// Check if the item's StartDate >= win.start and EndDate <= win.end
 		if (startDate.UTC().After(winStartUTC) || startDate.UTC().Equal(winStartUTC)) &&
 			(endDate.UTC().Before(winEndUTC) || endDate.UTC().Equal(winEndUTC)) {
+			if !filters.Keeps(item.GroupId, item.ClusterName, item.SKU) {
+				log.Debugf("filters exclude line item %s", customCost.ProviderId)
+				droppedLineItems.WithLabelValues("filtered").Inc()
+				excluded.Add("USD", decimal.New(int64(item.TotalPriceCents), -2))
+				continue
+			}
 			// 	// Append the customCost pointer to the slice
 			filteredItems = append(filteredItems, customCost)
+		} else {
+			droppedLineItems.WithLabelValues("outside_window").Inc()
 		}
 	}
 
-	return filteredItems
+	return filteredItems, excluded
 
 }
 
@@ -219,7 +240,7 @@ func (a *AtlasCostSource) getAtlasCostsForWindow(win *opencost.Window, lineItems
 
 	//filter responses between the win start and win end dates
 
-	costsInWindow := filterLineItemsByWindow(win, lineItems)
+	costsInWindow, excluded := filterLineItemsByWindow(win, lineItems, a.filters)
 
 	resp := pb.CustomCostResponse{
 		Metadata:   map[string]string{"api_client_version": "v1"},
@@ -232,32 +253,38 @@ func (a *AtlasCostSource) getAtlasCostsForWindow(win *opencost.Window, lineItems
 		Errors:     []string{},
 		Costs:      costsInWindow,
 	}
+	excluded.Record(&resp)
 	return &resp
 }
 
-func GetPendingInvoices(org string, client HTTPClient) ([]atlasplugin.LineItem, error) {
-	request, _ := http.NewRequest("GET", fmt.Sprintf(costExplorerPendingInvoicesURL, org), nil)
+func GetPendingInvoices(ctx context.Context, org string, client HTTPClient) ([]atlasplugin.LineItem, error) {
+	request, _ := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf(costExplorerPendingInvoicesURL, org), nil)
 
 	request.Header.Set("Accept", "application/vnd.atlas.2023-01-01+json")
 	request.Header.Set("Content-Type", "application/vnd.atlas.2023-01-01+json")
 
 	response, error := client.Do(request)
 	if error != nil {
-		msg := fmt.Sprintf("getPending Invoices: error from server: %v", error)
-		log.Errorf(msg)
-		return nil, fmt.Errorf(msg)
+		err := fmt.Errorf("getPending Invoices: error from server: %w", error)
+		log.Errorf("%v", err)
+		return nil, err
 
 	}
 
 	defer response.Body.Close()
 	body, _ := io.ReadAll(response.Body)
 	log.Debugf("response Body: %s", string(body))
+	if response.StatusCode != http.StatusOK {
+		log.Errorf("pendingInvoices: received non-200 response: %d", response.StatusCode)
+		return nil, costerror.FromStatus(response.StatusCode, "pendingInvoices: received non-200 response: %d", response.StatusCode)
+	}
+
 	var pendingInvoicesResponse atlasplugin.PendingInvoice
 	respUnmarshalError := json.Unmarshal([]byte(body), &pendingInvoicesResponse)
 	if respUnmarshalError != nil {
-		msg := fmt.Sprintf("pendingInvoices: error unmarshalling response: %v", respUnmarshalError)
-		log.Errorf(msg)
-		return nil, fmt.Errorf(msg)
+		err := costerror.New(costerror.ParseError, "pendingInvoices: error unmarshalling response: %v", respUnmarshalError)
+		log.Errorf("%v", err)
+		return nil, err
 	}
 
 	return pendingInvoicesResponse.LineItems, nil
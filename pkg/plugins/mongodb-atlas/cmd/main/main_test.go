@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,6 +12,12 @@ import (
 	"time"
 
 	"github.com/icholy/digest"
+	"github.com/opencost/opencost-plugins/common/costerror"
+	"github.com/opencost/opencost-plugins/common/decimal"
+	"github.com/opencost/opencost-plugins/common/filter"
+	"github.com/opencost/opencost-plugins/common/finality"
+	"github.com/opencost/opencost-plugins/common/focus"
+	atlasconfig "github.com/opencost/opencost-plugins/pkg/plugins/mongodb-atlas/config"
 	atlasplugin "github.com/opencost/opencost-plugins/pkg/plugins/mongodb-atlas/plugin"
 	"github.com/opencost/opencost/core/pkg/model/pb"
 	"github.com/opencost/opencost/core/pkg/opencost"
@@ -136,7 +143,7 @@ func TestGetCostsPendingInvoices(t *testing.T) {
 			}, nil
 		},
 	}
-	lineItems, err := GetPendingInvoices("myOrg", mockClient)
+	lineItems, err := GetPendingInvoices(context.Background(), "myOrg", mockClient)
 	assert.Nil(t, err)
 	assert.Equal(t, 1, len(lineItems))
 
@@ -157,7 +164,7 @@ func TestGetCostErrorFromServer(t *testing.T) {
 			return nil, fmt.Errorf("mock error: failed to execute request")
 		},
 	}
-	costs, err := GetPendingInvoices("myOrg", mockClient)
+	costs, err := GetPendingInvoices(context.Background(), "myOrg", mockClient)
 
 	assert.NotEmpty(t, err)
 	assert.Nil(t, costs)
@@ -177,7 +184,7 @@ func TestGetCostsBadMessage(t *testing.T) {
 		},
 	}
 
-	_, error := GetPendingInvoices("myOrd", mockClient)
+	_, error := GetPendingInvoices(context.Background(), "myOrd", mockClient)
 	assert.NotEmpty(t, error)
 
 }
@@ -230,6 +237,7 @@ func TestGetAtlasCostsForWindow(t *testing.T) {
 	assert.Equal(t, "v1", resp.Version)
 	assert.Equal(t, "USD", resp.Currency)
 	assert.Equal(t, 1, len(resp.Costs))
+	assert.Empty(t, focus.Check([]*pb.CustomCostResponse{resp}))
 }
 
 func TestGetCosts(t *testing.T) {
@@ -288,6 +296,12 @@ func TestGetCosts(t *testing.T) {
 	assert.Equal(t, 2, len(resp))
 	assert.True(t, len(resp[0].Costs) == 0)
 	assert.True(t, len(resp[1].Costs) == 0)
+
+	// the pending invoice can change until the month closes
+	for _, r := range resp {
+		assert.Equal(t, string(finality.Provisional), r.Metadata[finality.StatusKey])
+		assert.Equal(t, currentMonthStart.AddDate(0, 1, 0).Format(time.RFC3339), r.Metadata[finality.SettlesAtKey])
+	}
 }
 
 func TestValidateRequest(t *testing.T) {
@@ -373,7 +387,7 @@ func TestFilterInvoicesOnWindow(t *testing.T) {
 		{StartDate: "2024-10-12T00:00:00Z", EndDate: "2024-11-01T00:00:00Z"},                         // Partially in window
 	}
 
-	filteredItems := filterLineItemsByWindow(&window, lineItems)
+	filteredItems, _ := filterLineItemsByWindow(&window, lineItems, atlasconfig.AtlasFilters{})
 
 	// Verify results
 	assert.Equal(t, 3, len(filteredItems), "Expected 3 line items to be filtered")
@@ -404,6 +418,14 @@ func TestFilterInvoicesOnWindow(t *testing.T) {
 	assert.NotNil(t, filteredItems[0].Id)
 	assert.NotNil(t, filteredItems[0].ProviderId)
 	assert.Equal(t, "A/cluster-0/0", filteredItems[0].ProviderId)
+	assert.Equal(t, "cluster-0", filteredItems[0].Metadata["cluster_name"])
+
+	// re-fetching the window yields the same IDs, and items sharing a provider ID are still told apart
+	refetched, _ := filterLineItemsByWindow(&window, lineItems, atlasconfig.AtlasFilters{})
+	for i := range filteredItems {
+		assert.Equal(t, filteredItems[i].Id, refetched[i].Id)
+	}
+	assert.NotEqual(t, filteredItems[1].Id, filteredItems[2].Id)
 
 	assert.InDelta(t, float32(lineItems[0].TotalPriceCents)/100.0, filteredItems[0].BilledCost, 0.01)
 	assert.InDelta(t, filteredItems[0].ListCost, lineItems[0].Quantity*lineItems[0].UnitPriceDollars, 0.01)
@@ -411,6 +433,75 @@ func TestFilterInvoicesOnWindow(t *testing.T) {
 	assert.Equal(t, filteredItems[0].UsageUnit, lineItems[0].Unit)
 }
 
+func TestDailyCostsSumToInvoiceTotal(t *testing.T) {
+	monthStart := time.Date(2024, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	// a month of daily line items for an M10 cluster, priced per hour
+	var lineItems []atlasplugin.LineItem
+	var invoiceCents int64
+	for day := 0; day < 31; day++ {
+		start := monthStart.AddDate(0, 0, day)
+		cents := int32(192 + day%3)
+		invoiceCents += int64(cents)
+		lineItems = append(lineItems, atlasplugin.LineItem{
+			StartDate: start.Format(time.RFC3339), EndDate: start.AddDate(0, 0, 1).Format(time.RFC3339),
+			GroupId: "A", GroupName: "kubecost0", ClusterName: "cluster-0", SKU: "ATLAS_AWS_INSTANCE_M10",
+			Quantity: 24, UnitPriceDollars: 0.08, TotalPriceCents: cents, Unit: "SERVER-HOURS",
+		})
+	}
+
+	billed, listed := decimal.Zero, decimal.Zero
+	for day := 0; day < 31; day++ {
+		start := monthStart.AddDate(0, 0, day)
+		end := start.AddDate(0, 0, 1)
+		window := opencost.NewWindow(&start, &end)
+		costs, _ := filterLineItemsByWindow(&window, lineItems, atlasconfig.AtlasFilters{})
+		for _, cost := range costs {
+			billed = billed.Add(decimal.NewFromFloat32(cost.BilledCost))
+			listed = listed.Add(decimal.NewFromFloat32(cost.ListCost))
+		}
+	}
+
+	assert.Equal(t, decimal.New(invoiceCents, -2).String(), billed.Round(2).String())
+	// 31 days of 24 hours at $0.08
+	assert.Equal(t, "59.52", listed.Round(2).String())
+}
+
+func TestFilterInvoicesOnWindowExcludesFilteredItems(t *testing.T) {
+	windowStart := time.Date(2024, time.October, 1, 0, 0, 0, 0, time.UTC)
+	windowEnd := time.Date(2024, time.October, 2, 0, 0, 0, 0, time.UTC)
+	window := opencost.NewWindow(&windowStart, &windowEnd)
+
+	item := func(groupID, cluster, sku string, cents int32) atlasplugin.LineItem {
+		return atlasplugin.LineItem{StartDate: "2024-10-01T00:00:00Z", EndDate: "2024-10-02T00:00:00Z",
+			GroupId: groupID, ClusterName: cluster, SKU: sku, TotalPriceCents: cents}
+	}
+	lineItems := []atlasplugin.LineItem{
+		item("A", "cluster-0", "ATLAS_AWS_INSTANCE_M10", 192),
+		item("A", "cluster-0", "ATLAS_AWS_DATA_TRANSFER_SAME_REGION", 3),
+		item("B", "cluster-1", "ATLAS_AWS_INSTANCE_M30", 1250),
+		item("A", "", "ATLAS_SUPPORT", 500),
+		// outside the window, so left out without counting as excluded
+		{StartDate: "2024-10-02T00:00:00Z", EndDate: "2024-10-03T00:00:00Z", GroupId: "B", TotalPriceCents: 1250},
+	}
+	filters := atlasconfig.AtlasFilters{
+		Group:   filter.Filter{Exclude: []string{"B"}},
+		Cluster: filter.Filter{Include: []string{"cluster-*"}},
+		SKU:     filter.Filter{Exclude: []string{"*_DATA_TRANSFER_*"}},
+	}
+
+	costs, excluded := filterLineItemsByWindow(&window, lineItems, filters)
+
+	assert.Len(t, costs, 1)
+	assert.Equal(t, "A/cluster-0/ATLAS_AWS_INSTANCE_M10", costs[0].ProviderId)
+	assert.Equal(t, 3, excluded.Costs)
+	assert.Equal(t, "17.53", excluded.Billed["USD"].String())
+
+	resp := (&AtlasCostSource{filters: filters}).getAtlasCostsForWindow(&window, lineItems)
+	assert.Equal(t, "3", resp.Metadata[filter.ExcludedCostsKey])
+	assert.Equal(t, "USD=17.53", resp.Metadata[filter.ExcludedBilledCostKey])
+}
+
 func TestFilterInvoicesOnWindowBadResponse(t *testing.T) {
 	//setup a window between october 1st and october 31st 2024
 	windowStart := time.Date(2024, time.October, 1, 0, 0, 0, 0, time.UTC)
@@ -424,7 +515,7 @@ func TestFilterInvoicesOnWindowBadResponse(t *testing.T) {
 		// Partially in window
 	}
 
-	filteredItems := filterLineItemsByWindow(&window, lineItems)
+	filteredItems, _ := filterLineItemsByWindow(&window, lineItems, atlasconfig.AtlasFilters{})
 	assert.Equal(t, 0, len(filteredItems))
 }
 
@@ -489,3 +580,75 @@ func TestGetCostsReturnsErrorForPendingInvoices(t *testing.T) {
 	assert.True(t, len(resp[0].Errors) > 0)
 
 }
+
+func TestCheckReportsMissingRole(t *testing.T) {
+	for status, expected := range map[int]string{
+		http.StatusOK:           "",
+		http.StatusUnauthorized: "access list",
+		http.StatusForbidden:    "Organization Billing Viewer",
+		http.StatusNotFound:     "atlas_org_id",
+	} {
+		mockClient := &MockHTTPClient{
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				assert.Equal(t, "https://cloud.mongodb.com/api/atlas/v2/orgs/myOrg/invoices/pending", req.URL.String())
+				return &http.Response{
+					StatusCode: status,
+					Body:       io.NopCloser(bytes.NewBufferString("{}")),
+				}, nil
+			},
+		}
+
+		atlasCostSource := AtlasCostSource{
+			orgID:       "myOrg",
+			atlasClient: mockClient,
+		}
+		err := atlasCostSource.Check(context.Background())
+		if expected == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.ErrorContains(t, err, expected)
+		}
+	}
+}
+
+func TestGetCostsNon200Status(t *testing.T) {
+	mockClient := &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			// a valid body must not hide the failed status
+			return &http.Response{
+				StatusCode: http.StatusUnauthorized,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"lineItems": []}`)),
+			}, nil
+		},
+	}
+
+	lineItems, err := GetPendingInvoices(context.Background(), "myOrg", mockClient)
+	assert.ErrorContains(t, err, "401")
+	assert.Equal(t, costerror.Auth, costerror.KindOf(err))
+	assert.Nil(t, lineItems)
+}
+
+func TestGetCostsReportsRejectedRequest(t *testing.T) {
+	atlasCostSource := AtlasCostSource{
+		orgID: "myOrg",
+		atlasClient: &MockHTTPClient{
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				t.Fatalf("a rejected request must not reach the Atlas API")
+				return nil, nil
+			},
+		},
+	}
+
+	now := time.Now()
+	currentMonthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	customCostRequest := pb.CustomCostRequest{
+		Start:      timestamppb.New(currentMonthStart.AddDate(0, -2, 0)),
+		End:        timestamppb.New(currentMonthStart.Add(24 * time.Hour)),
+		Resolution: durationpb.New(time.Hour),
+	}
+
+	resp := atlasCostSource.GetCustomCosts(&customCostRequest)
+	assert.Len(t, resp, 1)
+	assert.Equal(t, []costerror.Kind{costerror.UnsupportedRequest, costerror.UnsupportedRequest}, costerror.Kinds(resp[0]))
+	assert.Contains(t, resp[0].Errors, "unsupported_request: Resolution should be at least one day.")
+}
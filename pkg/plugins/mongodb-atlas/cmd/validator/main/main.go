@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/hashicorp/go-multierror"
+	"github.com/opencost/opencost-plugins/common/costerror"
 	"github.com/opencost/opencost/core/pkg/log"
 	"github.com/opencost/opencost/core/pkg/model/pb"
 	"google.golang.org/protobuf/encoding/protojson"
@@ -71,15 +72,32 @@ func main() {
 	}
 }
 
+// isUnsupportedRequest reports whether resp only rejects the request as unsupported, without any costs
+func isUnsupportedRequest(resp *pb.CustomCostResponse) bool {
+	kinds := costerror.Kinds(resp)
+	if len(resp.Costs) > 0 || len(kinds) == 0 {
+		return false
+	}
+	for _, kind := range kinds {
+		if kind != costerror.UnsupportedRequest {
+			return false
+		}
+	}
+	return true
+}
+
 func validate(respDaily, respHourly []*pb.CustomCostResponse) bool {
 	if len(respDaily) == 0 {
 		log.Errorf("no daily response received from mongodb-atlas plugin")
 		return false
 	}
 
-	if len(respHourly) != 0 {
-		log.Errorf("mongo plugin does not support hourly costs")
-		return false
+	// the plugin rejects hourly requests, reporting why instead of returning costs
+	for _, resp := range respHourly {
+		if !isUnsupportedRequest(resp) {
+			log.Errorf("mongo plugin does not support hourly costs, but returned: %v", resp)
+			return false
+		}
 	}
 
 	var multiErr error
@@ -91,12 +109,6 @@ func validate(respDaily, respHourly []*pb.CustomCostResponse) bool {
 		}
 	}
 
-	for _, resp := range respHourly {
-		if resp.Errors != nil {
-			multiErr = multierror.Append(multiErr, fmt.Errorf("errors occurred in hourly response: %v", resp.Errors))
-		}
-	}
-
 	// check if any errors occurred
 	if multiErr != nil {
 		log.Errorf("Errors occurred during plugin testing for mongodb-atlas: %v", multiErr)
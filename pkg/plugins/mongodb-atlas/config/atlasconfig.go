@@ -1,31 +1,93 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
+
+	"github.com/opencost/opencost-plugins/common/accounts"
+	"github.com/opencost/opencost-plugins/common/budget"
+	commonconfig "github.com/opencost/opencost-plugins/common/config"
+	"github.com/opencost/opencost-plugins/common/currency"
+	"github.com/opencost/opencost-plugins/common/executor"
+	"github.com/opencost/opencost-plugins/common/filter"
+	"github.com/opencost/opencost-plugins/common/labels"
+	"github.com/opencost/opencost-plugins/common/metrics"
 )
 
 type AtlasConfig struct {
 	PublicKey  string `json:"atlas_public_key"`
 	PrivateKey string `json:"atlas_private_key"`
 	OrgID      string `json:"atlas_org_id"`
-	LogLevel   string `json:"atlas_plugin_log_level"`
+	// Accounts lists the organizations to report costs for, each with its own API key, in place of the keys above
+	Accounts []AtlasAccount `json:"accounts"`
+	LogLevel string         `json:"atlas_plugin_log_level" default:"info" oneof:"trace debug info warn error"`
+	// Filters leave out invoice line items that are already allocated elsewhere
+	Filters AtlasFilters `json:"filters"`
+	budget.BudgetConfig
+	executor.ExecutorConfig
+	metrics.MetricsConfig
+	currency.CurrencyConfig
+	labels.LabelsConfig
 }
 
-func GetAtlasConfig(configFilePath string) (*AtlasConfig, error) {
-	var result AtlasConfig
-	bytes, err := os.ReadFile(configFilePath)
-	if err != nil {
-		return nil, fmt.Errorf("error reading config file for Atlas config @ %s: %v", configFilePath, err)
+// AtlasFilters select the invoice line items costs are reported for
+type AtlasFilters struct {
+	// Group matches the ID of the project billed
+	Group filter.Filter `json:"group"`
+	// Cluster matches the name of the cluster billed. Line items for no cluster have an empty name.
+	Cluster filter.Filter `json:"cluster"`
+	// SKU matches the SKU billed, such as "ATLAS_AWS_INSTANCE_M10"
+	SKU filter.Filter `json:"sku"`
+}
+
+// Keeps reports whether the filters keep a line item for a SKU billed to a project's cluster
+func (f AtlasFilters) Keeps(groupID, cluster, sku string) bool {
+	return f.Group.Keeps(groupID) && f.Cluster.Keeps(cluster) && f.SKU.Keeps(sku)
+}
+
+// AtlasAccount is one of several organizations reported on by a single plugin
+type AtlasAccount struct {
+	Name       string `json:"name" required:"true"`
+	PublicKey  string `json:"atlas_public_key" required:"true"`
+	PrivateKey string `json:"atlas_private_key" required:"true"`
+	OrgID      string `json:"atlas_org_id" required:"true"`
+}
+
+// Validate requires either the API key and organization of a single account or a list of accounts.
+func (c *AtlasConfig) Validate() []string {
+	var names []string
+	for _, account := range c.Accounts {
+		names = append(names, account.Name)
 	}
-	err = json.Unmarshal(bytes, &result)
+	return accounts.ConfigProblems(names,
+		accounts.Field{Key: "atlas_public_key", Value: c.PublicKey},
+		accounts.Field{Key: "atlas_private_key", Value: c.PrivateKey},
+		accounts.Field{Key: "atlas_org_id", Value: c.OrgID},
+	)
+}
+
+// AccountList returns the organizations to report costs for. A config with its keys at the top level
+// has a single account without a name.
+func (c *AtlasConfig) AccountList() []AtlasAccount {
+	if len(c.Accounts) > 0 {
+		return c.Accounts
+	}
+	return []AtlasAccount{{PublicKey: c.PublicKey, PrivateKey: c.PrivateKey, OrgID: c.OrgID}}
+}
+
+func GetAtlasConfig(configFilePath string) (*AtlasConfig, error) {
+	bytes, err := commonconfig.ReadConfigFile(configFilePath)
 	if err != nil {
-		return nil, fmt.Errorf("error marshaling json into Atlas config %v", err)
+		return nil, fmt.Errorf("error loading Atlas config: %v", err)
 	}
 
-	if result.LogLevel == "" {
-		result.LogLevel = "info"
+	return ParseAtlasConfig(bytes)
+}
+
+func ParseAtlasConfig(configBytes []byte) (*AtlasConfig, error) {
+	var result AtlasConfig
+	err := commonconfig.Decode(configBytes, &result)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Atlas config: %v", err)
 	}
 
 	return &result, nil
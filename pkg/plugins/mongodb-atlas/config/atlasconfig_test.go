@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -12,7 +13,7 @@ func TestGetAtlasConfig(t *testing.T) {
 	t.Run("Valid configuration file", func(t *testing.T) {
 		configFilePath := "test_valid_config.json"
 		// Create a temporary valid JSON file
-		validConfig := `{"atlas_plugin_log_level": "debug"}`
+		validConfig := `{"atlas_public_key": "public", "atlas_private_key": "private", "atlas_org_id": "myOrg", "atlas_plugin_log_level": "debug"}`
 		err := os.WriteFile(configFilePath, []byte(validConfig), 0644)
 		if err != nil {
 			t.Fatalf("failed to create temporary config file: %v", err)
@@ -55,11 +56,88 @@ func TestGetAtlasConfig(t *testing.T) {
 		}
 	})
 
+	// Test: Missing keys, unknown fields and invalid values are all reported
+	t.Run("Invalid configuration values", func(t *testing.T) {
+		configFilePath := "test_invalid_values.json"
+		invalidValuesConfig := `{"atlas_public_kye": "public", "atlas_org_id": "myOrg", "atlas_plugin_log_level": "verbose"}`
+		err := os.WriteFile(configFilePath, []byte(invalidValuesConfig), 0644)
+		if err != nil {
+			t.Fatalf("failed to create temporary config file: %v", err)
+		}
+		defer os.Remove(configFilePath)
+
+		_, err = GetAtlasConfig(configFilePath)
+		if err == nil {
+			t.Fatalf("expected an error, but got none")
+		}
+		for _, expected := range []string{
+			"atlas_public_kye is not a known config field, did you mean atlas_public_key?",
+			"atlas_public_key is required",
+			"atlas_private_key is required",
+			"atlas_plugin_log_level must be one of",
+		} {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("expected error to contain %q, but got: %v", expected, err)
+			}
+		}
+	})
+
+	// Test: Several organizations, each with its own keys
+	t.Run("Accounts", func(t *testing.T) {
+		configFilePath := "test_accounts.json"
+		accountsConfig := `{"accounts": [
+			{"name": "prod", "atlas_public_key": "public", "atlas_private_key": "private", "atlas_org_id": "prodOrg"},
+			{"name": "dev", "atlas_public_key": "public2", "atlas_private_key": "private2", "atlas_org_id": "devOrg"}
+		]}`
+		err := os.WriteFile(configFilePath, []byte(accountsConfig), 0644)
+		if err != nil {
+			t.Fatalf("failed to create temporary config file: %v", err)
+		}
+		defer os.Remove(configFilePath)
+
+		config, err := GetAtlasConfig(configFilePath)
+		if err != nil {
+			t.Fatalf("expected no error, but got: %v", err)
+		}
+		accounts := config.AccountList()
+		if len(accounts) != 2 || accounts[0].Name != "prod" || accounts[1].OrgID != "devOrg" {
+			t.Errorf("unexpected accounts: %+v", accounts)
+		}
+	})
+
+	// Test: Top level keys can't be combined with accounts, and account names must be unique
+	t.Run("Invalid accounts", func(t *testing.T) {
+		configFilePath := "test_invalid_accounts.json"
+		invalidAccountsConfig := `{"atlas_org_id": "myOrg", "accounts": [
+			{"name": "prod", "atlas_public_key": "public", "atlas_private_key": "private", "atlas_org_id": "prodOrg"},
+			{"name": "prod", "atlas_public_key": "public2", "atlas_org_id": "devOrg"}
+		]}`
+		err := os.WriteFile(configFilePath, []byte(invalidAccountsConfig), 0644)
+		if err != nil {
+			t.Fatalf("failed to create temporary config file: %v", err)
+		}
+		defer os.Remove(configFilePath)
+
+		_, err = GetAtlasConfig(configFilePath)
+		if err == nil {
+			t.Fatalf("expected an error, but got none")
+		}
+		for _, expected := range []string{
+			"atlas_org_id can't be set along with accounts",
+			`accounts[1].name "prod" is already used by another account`,
+			"atlas_private_key is required",
+		} {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("expected error to contain %q, but got: %v", expected, err)
+			}
+		}
+	})
+
 	// Test: Default log level when missing
 	t.Run("Default log level when missing", func(t *testing.T) {
 		configFilePath := "test_missing_log_level.json"
 		// Create a temporary JSON file without log_level
-		missingLogLevelConfig := `{}`
+		missingLogLevelConfig := `{"atlas_public_key": "public", "atlas_private_key": "private", "atlas_org_id": "myOrg"}`
 		err := os.WriteFile(configFilePath, []byte(missingLogLevelConfig), 0644)
 		if err != nil {
 			t.Fatalf("failed to create temporary config file: %v", err)
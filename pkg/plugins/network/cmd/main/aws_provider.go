@@ -5,16 +5,22 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math"
 	"sort"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsConfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/pricing"
 	"github.com/aws/aws-sdk-go-v2/service/pricing/types"
-	"github.com/google/uuid"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"github.com/opencost/opencost-plugins/common/budget"
+	"github.com/opencost/opencost-plugins/common/costerror"
+	"github.com/opencost/opencost-plugins/common/costid"
+	"github.com/opencost/opencost-plugins/common/decimal"
+	"github.com/opencost/opencost-plugins/common/finality"
 	"github.com/opencost/opencost-plugins/pkg/plugins/network/networkplugin"
 	"github.com/opencost/opencost/core/pkg/log"
 	"github.com/opencost/opencost/core/pkg/model/pb"
@@ -23,32 +29,74 @@ import (
 )
 
 type AwsProvider struct {
+	// mu guards initialization, as the provider is shared by every request
+	mu     sync.Mutex
 	client *pricing.Client
+	// accountID is the AWS account the cluster's data transfer is billed to, or empty if it couldn't be identified
+	accountID string
 }
 
-func (p *AwsProvider) Init(src *NetworkCostSource) error {
+// Init creates the pricing client and identifies the AWS account on the first call. Later calls reuse them.
+func (p *AwsProvider) Init(ctx context.Context, src *NetworkCostSource) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client != nil {
+		return nil
+	}
+
 	// load AWS config
 	cfg, err := awsConfig.LoadDefaultConfig(
-		context.TODO(),
+		ctx,
 		awsConfig.WithRegion(networkplugin.AWS_REGION_US_EAST_1), // Pricing API is only available in us-east-1
 		awsConfig.WithSharedConfigProfile("network-cost-dev"),    // TODO: this credential needs to be added and configured automatically
 	)
 	if err != nil {
-		return fmt.Errorf("failed to load AWS config: %v", err)
+		return costerror.New(costerror.Auth, "failed to load AWS config: %v", err)
 	}
 
 	// create pricing client from configuration
 	p.client = pricing.NewFromConfig(cfg)
 
+	// costs are attributed to the account the credentials belong to. the account only fills in the costs'
+	// account, so costs are still reported without one if it can't be identified
+	identity, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		log.Warnf("failed to identify the AWS account, reporting costs without one: %v", awsError(err))
+	} else {
+		p.accountID = aws.ToString(identity.Account)
+	}
+
+	return nil
+}
+
+func (p *AwsProvider) Check(ctx context.Context, src *NetworkCostSource) error {
+	if err := p.Init(ctx, src); err != nil {
+		return fmt.Errorf("could not load AWS credentials from the network-cost-dev profile: %v", err)
+	}
+
+	// a single product is enough to prove the credentials can read prices
+	_, err := p.client.GetProducts(ctx, &pricing.GetProductsInput{
+		ServiceCode:   aws.String(networkplugin.AWS_SERVICE_CODE),
+		FormatVersion: aws.String(networkplugin.AWS_FORMAT_VERSION),
+		MaxResults:    aws.Int32(1),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "AccessDeniedException" {
+			return fmt.Errorf("could not read AWS prices: the AWS credentials need the pricing:GetProducts permission")
+		}
+		return fmt.Errorf("could not read AWS prices: %v", err)
+	}
+
 	return nil
 }
 
-func (p *AwsProvider) GetNetworkCost(src *NetworkCostSource, req *pb.CustomCostRequest, region string) []*pb.CustomCostResponse {
+func (p *AwsProvider) GetNetworkCost(ctx context.Context, src *NetworkCostSource, req *pb.CustomCostRequest, region string) []*pb.CustomCostResponse {
 	results := []*pb.CustomCostResponse{}
 
 	windows, err := opencost.GetWindows(req.Start.AsTime(), req.End.AsTime(), req.Resolution.AsDuration())
 	if err != nil {
-		return generateErrorResponse("failed to create windows from request parameters", err, results)
+		return generateErrorResponse("failed to create windows from request parameters", costerror.Wrap(costerror.UnsupportedRequest, err), results)
 	}
 
 	for _, window := range windows {
@@ -60,35 +108,76 @@ func (p *AwsProvider) GetNetworkCost(src *NetworkCostSource, req *pb.CustomCostR
 
 		// create a basic response and generate metadata
 		response := getCustomCostResponseWithMetadata(*window.Start(), *window.End())
+		// costs are estimated from AWS list prices and the traffic prometheus recorded, so they never settle
+		finality.Mark(&response, finality.Estimated, time.Time{}, time.Now())
+
+		// once the request budget runs out, report the remaining windows instead of fetching them
+		if budget.Exhausted(ctx) {
+			costerror.Add(&response, budget.WindowError(ctx))
+			results = append(results, &response)
+			continue
+		}
 
 		// calculate and append inter-zone costs to response
-		interZoneCosts, err := p.getInterZoneCostsForWindow(window, src, req, region)
+		interZoneCosts, err := p.getInterZoneCostsForWindow(ctx, window, src, req, region)
 		if err != nil {
 			log.Errorf("error calculating AWS inter-zone costs: %v", err)
-			response.Errors = append(response.Errors, err.Error())
+			costerror.Add(&response, err)
 		}
 		if interZoneCosts != nil {
 			response.Costs = append(response.Costs, interZoneCosts...)
 		}
 
 		// calculate and append internet costs to response
-		internetCosts, err := p.getInternetCostsForWindow(window, src, req, region)
+		internetCosts, err := p.getInternetCostsForWindow(ctx, window, src, req, region)
 		if err != nil {
 			log.Errorf("error calculating AWS internet costs: %v", err)
-			response.Errors = append(response.Errors, err.Error())
+			costerror.Add(&response, err)
 		}
-		if interZoneCosts != nil {
+		if internetCosts != nil {
 			response.Costs = append(response.Costs, internetCosts...)
 		}
 
+		// data transfer allocated elsewhere is left out before the costs are attributed
+		excludeCosts(&response, src.filters)
+		p.attributeCosts(window, response.Domain, response.Costs)
 		results = append(results, &response)
 	}
 
 	return results
 }
 
+// attributeCosts attributes a window's costs to the AWS account they are billed to, and gives each
+// an ID that is the same whenever the window is fetched
+func (p *AwsProvider) attributeCosts(window opencost.Window, domain string, costs []*pb.CustomCost) {
+	for _, cost := range costs {
+		cost.AccountName = p.accountID
+		cost.ExtendedAttributes = &pb.CustomCostExtendedAttributes{AccountId: &p.accountID}
+		cost.Id = costid.New(domain, p.accountID, *window.Start(), *window.End(), cost.ProviderId)
+	}
+}
+
+// awsError gives an error from the AWS pricing API the kind matching its error code
+func awsError(err error) error {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	switch apiErr.ErrorCode() {
+	case "AccessDeniedException":
+		return costerror.Wrap(costerror.Permission, err)
+	case "UnrecognizedClientException", "InvalidSignatureException", "ExpiredTokenException":
+		return costerror.Wrap(costerror.Auth, err)
+	case "ThrottlingException":
+		return costerror.Wrap(costerror.RateLimited, err)
+	default:
+		return err
+	}
+}
+
 // INTER-ZONE COST
-func (p *AwsProvider) getInterZoneCostsForWindow(window opencost.Window, src *NetworkCostSource, req *pb.CustomCostRequest, region string) ([]*pb.CustomCost, error) {
+func (p *AwsProvider) getInterZoneCostsForWindow(ctx context.Context, window opencost.Window, src *NetworkCostSource, req *pb.CustomCostRequest, region string) ([]*pb.CustomCost, error) {
 	// get correct usage type to filter for the correct product from the AWS API
 	usagetype := getAwsRegionalUsageTypeFromRegion(region)
 
@@ -107,51 +196,57 @@ func (p *AwsProvider) getInterZoneCostsForWindow(window opencost.Window, src *Ne
 	}
 
 	// get list of filtered products for inter-zone network pricing
-	interZoneProducts, err := p.client.GetProducts(context.TODO(), interZoneInput)
+	interZoneProducts, err := p.client.GetProducts(ctx, interZoneInput)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get AWS products related to inter-zone network cost: %v", err)
+		return nil, fmt.Errorf("failed to get AWS products related to inter-zone network cost: %w", awsError(err))
 	}
 
 	// loop through products that matched given filter and retrieve pricing data
 	priceDimensions, err := getSortedPriceDimensionsFromProducts(interZoneProducts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get price dimensions from products: %v", err)
+		return nil, fmt.Errorf("failed to get price dimensions from products: %w", err)
 	}
 
 	if len(priceDimensions) > 0 {
-		// get sums of billed data since start of billing period
-		ingressBytesSum, err := src.getSumOfInterZoneDataSinceBillingPeriodStart(req, networkplugin.QUERY_WORKLOAD_INGRESS_BYTES_TOTAL)
-		if err != nil {
-			return nil, fmt.Errorf("failed to calculate sum of inter-zone ingress data transfer since start of billing period: %v", err)
-		}
-
-		egressBytesSum, err := src.getSumOfInterZoneDataSinceBillingPeriodStart(req, networkplugin.QUERY_WORKLOAD_EGRESS_BYTES_TOTAL)
-		if err != nil {
-			return nil, fmt.Errorf("failed to calculate sum of inter-zone egress data transfer since start of billing period: %v", err)
-		}
+		return calculateInterZoneCostsForWindow(ctx, window, src, req, priceDimensions)
+	} else {
+		return nil, costerror.New(costerror.UnsupportedRequest, "received no inter-zone data transfer pricing information from AWS API for the given region")
+	}
+}
 
-		// create total sum of billed data to check against the pricing tier range
-		totalBilledBytesSum := ingressBytesSum + egressBytesSum
+// calculateInterZoneCostsForWindow prices the inter-zone data transfer of a window, ingress and egress
+// each from their own query, at the tiers left after the data transferred since the billing period started
+func calculateInterZoneCostsForWindow(ctx context.Context, window opencost.Window, src *NetworkCostSource, req *pb.CustomCostRequest, priceDimensions []networkplugin.PriceDimension) ([]*pb.CustomCost, error) {
+	// get sums of billed data since start of billing period
+	ingressBytesSum, err := src.getSumOfInterZoneDataSinceBillingPeriodStart(ctx, req, networkplugin.QUERY_WORKLOAD_INGRESS_BYTES_TOTAL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate sum of inter-zone ingress data transfer since start of billing period: %w", err)
+	}
 
-		// query inter-zone data transfer to calculate cost for window
-		ingressQueryResults, err := src.queryPrometheusData(networkplugin.QUERY_WORKLOAD_INGRESS_BYTES_TOTAL, *window.Start(), *window.End(), window.Duration())
-		if err != nil {
-			return nil, fmt.Errorf("failed to query inter-zone ingress data transfer for given window: %v", err)
-		}
+	egressBytesSum, err := src.getSumOfInterZoneDataSinceBillingPeriodStart(ctx, req, networkplugin.QUERY_WORKLOAD_EGRESS_BYTES_TOTAL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate sum of inter-zone egress data transfer since start of billing period: %w", err)
+	}
 
-		egressQueryResults, err := src.queryPrometheusData(networkplugin.QUERY_WORKLOAD_INGRESS_BYTES_TOTAL, *window.Start(), *window.End(), window.Duration())
-		if err != nil {
-			return nil, fmt.Errorf("failed to query inter-zone egress data transfer for given window: %v", err)
-		}
+	// create total sum of billed data to check against the pricing tier range
+	totalBilledBytesSum := ingressBytesSum + egressBytesSum
 
-		// calculate and return ingress & egress inter-zone costs
-		ingressCosts, totalBilledBytesSum := calculateAwsInterZoneCosts("Ingress Inter Zone", ingressQueryResults.(model.Matrix), priceDimensions, totalBilledBytesSum)
-		egressCosts, _ := calculateAwsInterZoneCosts("Egress Inter Zone", egressQueryResults.(model.Matrix), priceDimensions, totalBilledBytesSum)
+	// query inter-zone data transfer to calculate cost for window
+	ingressQueryResults, err := src.queryPrometheusData(ctx, networkplugin.QUERY_WORKLOAD_INGRESS_BYTES_TOTAL, *window.Start(), *window.End(), window.Duration())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query inter-zone ingress data transfer for given window: %w", err)
+	}
 
-		return append(ingressCosts, egressCosts...), nil
-	} else {
-		return nil, errors.New("received no inter-zone data transfer pricing information from AWS API for the given region")
+	egressQueryResults, err := src.queryPrometheusData(ctx, networkplugin.QUERY_WORKLOAD_EGRESS_BYTES_TOTAL, *window.Start(), *window.End(), window.Duration())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query inter-zone egress data transfer for given window: %w", err)
 	}
+
+	// calculate and return ingress & egress inter-zone costs
+	ingressCosts, totalBilledBytesSum := calculateAwsInterZoneCosts("Ingress Inter Zone", ingressQueryResults.(model.Matrix), priceDimensions, totalBilledBytesSum)
+	egressCosts, _ := calculateAwsInterZoneCosts("Egress Inter Zone", egressQueryResults.(model.Matrix), priceDimensions, totalBilledBytesSum)
+
+	return append(ingressCosts, egressCosts...), nil
 }
 
 func getAwsRegionalUsageTypeFromRegion(region string) string {
@@ -190,7 +285,7 @@ func calculateAwsInterZoneCosts(
 	totalBilledUsageBytes int64,
 ) (costs []*pb.CustomCost, updatedTotalBilledUsageBytes int64) {
 	// create a map to store costs by workload
-	workloadCostMap := make(map[networkplugin.Workload]*pb.CustomCost)
+	workloadCostMap := make(map[networkplugin.Workload]*networkplugin.BilledUsage)
 
 	// loop through data matrices and calculate the cost at the correct pricing tier
 	for _, stream := range resultsMatrix {
@@ -215,31 +310,31 @@ func calculateAwsInterZoneCosts(
 
 	// convert workload cost map to array and return
 	var workloadCostArray []*pb.CustomCost
-	for _, cost := range workloadCostMap {
-		workloadCostArray = append(workloadCostArray, cost)
+	for workload, usage := range workloadCostMap {
+		workloadCostArray = append(workloadCostArray, createAwsCustomCost(usage, resourceName, workload))
 	}
 
 	return workloadCostArray, totalBilledUsageBytes
 }
 
 // INTERNET COST
-func (p *AwsProvider) getInternetCostsForWindow(window opencost.Window, src *NetworkCostSource, req *pb.CustomCostRequest, region string) ([]*pb.CustomCost, error) {
-	priceDimensions, err := p.getAwsInternetPriceDimensions(region)
+func (p *AwsProvider) getInternetCostsForWindow(ctx context.Context, window opencost.Window, src *NetworkCostSource, req *pb.CustomCostRequest, region string) ([]*pb.CustomCost, error) {
+	priceDimensions, err := p.getAwsInternetPriceDimensions(ctx, region)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get AWS internet price dimensions: %v", err)
+		return nil, fmt.Errorf("failed to get AWS internet price dimensions: %w", err)
 	}
 
 	if len(priceDimensions) > 0 {
 		// get sum of billed data since billing period start
-		internetEgressBytesSum, err := src.getSumOfInternetDataSinceBillingPeriodStart(req)
+		internetEgressBytesSum, err := src.getSumOfInternetDataSinceBillingPeriodStart(ctx, req)
 		if err != nil {
-			return nil, fmt.Errorf("failed to calculate sum of internet egress data transfer since start of billing period: %v", err)
+			return nil, fmt.Errorf("failed to calculate sum of internet egress data transfer since start of billing period: %w", err)
 		}
 
 		// query internet data transfer to calculate cost for window
-		internetEgressQueryResults, err := src.queryPrometheusData(networkplugin.QUERY_CLUSTER_EXTERNAL_EGRESS_BYTES_TOTAL, *window.Start(), *window.End(), window.Duration())
+		internetEgressQueryResults, err := src.queryPrometheusData(ctx, networkplugin.QUERY_CLUSTER_EXTERNAL_EGRESS_BYTES_TOTAL, *window.Start(), *window.End(), window.Duration())
 		if err != nil {
-			return nil, fmt.Errorf("failed to query internet egress data transfer for given window: %v", err)
+			return nil, fmt.Errorf("failed to query internet egress data transfer for given window: %w", err)
 		}
 
 		// calculate and return egress internet costs
@@ -247,11 +342,11 @@ func (p *AwsProvider) getInternetCostsForWindow(window opencost.Window, src *Net
 
 		return internetEgressCosts, nil
 	} else {
-		return nil, errors.New("received no internet data transfer pricing information from AWS API for the given region")
+		return nil, costerror.New(costerror.UnsupportedRequest, "received no internet data transfer pricing information from AWS API for the given region")
 	}
 }
 
-func (p *AwsProvider) getAwsInternetPriceDimensions(region string) ([]networkplugin.PriceDimension, error) {
+func (p *AwsProvider) getAwsInternetPriceDimensions(ctx context.Context, region string) ([]networkplugin.PriceDimension, error) {
 	// currently the only region with API pricing available is sa-east-1
 	if region == networkplugin.AWS_REGION_SOUTH_AMERICA_EAST_1 {
 
@@ -270,15 +365,15 @@ func (p *AwsProvider) getAwsInternetPriceDimensions(region string) ([]networkplu
 		}
 
 		// get list of filtered products for internet network pricing
-		internetProducts, err := p.client.GetProducts(context.TODO(), interZoneInput)
+		internetProducts, err := p.client.GetProducts(ctx, interZoneInput)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get AWS products related to internet network cost: %v", err)
+			return nil, fmt.Errorf("failed to get AWS products related to internet network cost: %w", awsError(err))
 		}
 
 		// loop through products that matched given filter and retrieve pricing data
 		priceDimensions, err := getSortedPriceDimensionsFromProducts(internetProducts)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get price dimensions from products: %v", err)
+			return nil, fmt.Errorf("failed to get price dimensions from products: %w", err)
 		}
 
 		return priceDimensions, nil
@@ -310,7 +405,7 @@ func calculateAwsInternetCosts(
 	totalBilledUsageBytes int64,
 ) []*pb.CustomCost {
 	// create a map to store costs by workload
-	workloadCostMap := make(map[networkplugin.Workload]*pb.CustomCost)
+	workloadCostMap := make(map[networkplugin.Workload]*networkplugin.BilledUsage)
 
 	// loop through data matrices and calculate the cost at the correct pricing tier
 	for _, stream := range resultsMatrix {
@@ -320,28 +415,31 @@ func calculateAwsInternetCosts(
 
 	// convert workload cost map to array and return
 	var workloadCostArray []*pb.CustomCost
-	for _, cost := range workloadCostMap {
-		workloadCostArray = append(workloadCostArray, cost)
+	for workload, usage := range workloadCostMap {
+		workloadCostArray = append(workloadCostArray, createAwsCustomCost(usage, resourceName, workload))
 	}
 
 	return workloadCostArray
 }
 
 // SHARED FUNCTIONS
-func createAwsCustomCost(billedCost float32, usageQuantity float32, resourceName string) *pb.CustomCost {
+
+// createAwsCustomCost builds a workload's cost once all of its usage has been billed
+func createAwsCustomCost(usage *networkplugin.BilledUsage, resourceName string, workload networkplugin.Workload) *pb.CustomCost {
 	return &pb.CustomCost{
-		BilledCost:     billedCost,
+		BilledCost:     usage.BilledCost.Float32(),
 		ChargeCategory: "Usage",
 		Description:    fmt.Sprintf("%s Network Data Transfer", resourceName),
-		Id:             uuid.New().String(),
 		ResourceName:   resourceName,
 		ResourceType:   "Network",
-		UsageQuantity:  usageQuantity,
-
-		// TODO: figure out values
-		// AccountName:    billingEntry.OrganizationName,
-		// ProviderId:    fmt.Sprintf("%s/%s/%s", billingEntry.OrganizationID, billingEntry.ProjectID, billingEntry.Name)
-		// UsageUnit:      "tokens - All snapshots, all projects",
+		ProviderId:     fmt.Sprintf("%s/%s/%s", workload.Type, workload.Name, resourceName),
+		UsageQuantity:  usage.UsageQuantityGB.Float32(),
+		UsageUnit:      "GB",
+		// the workload that sent the traffic, so label rules can allocate the cost
+		Metadata: map[string]string{
+			networkplugin.METADATA_OWNER_NAME: workload.Name,
+			networkplugin.METADATA_OWNER_TYPE: workload.Type,
+		},
 	}
 }
 
@@ -363,7 +461,7 @@ func getSortedPriceDimensionsFromProducts(products *pricing.GetProductsOutput) (
 		// marshal pricing data JSON into a structure
 		var interzonePricingData networkplugin.ProductPrice
 		if err := json.Unmarshal([]byte(interzonePricingJson), &interzonePricingData); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal inter-zone network pricing entry: %v", err)
+			return nil, costerror.New(costerror.ParseError, "failed to unmarshal inter-zone network pricing entry: %v", err)
 		}
 
 		// navigate through pricing data structure to get price dimensions
@@ -390,7 +488,7 @@ func getSortedPriceDimensionsFromProducts(products *pricing.GetProductsOutput) (
 }
 
 func updateWorkloadCostMapFromSampleStream(
-	workloadCostMap map[networkplugin.Workload]*pb.CustomCost,
+	workloadCostMap map[networkplugin.Workload]*networkplugin.BilledUsage,
 	stream *model.SampleStream,
 	priceDimensions []networkplugin.PriceDimension,
 	totalBilledUsageBytes int64,
@@ -437,15 +535,13 @@ func updateWorkloadCostMapFromSampleStream(
 
 		// loop through the array of billed usage by dimension and update the workload's billed cost and usage quantity
 		for _, billedUsage := range billedUsageByDimension {
-			workloadCost, costExists := workloadCostMap[workload]
-			if !costExists {
-				workloadCostMap[workload] = createAwsCustomCost(billedUsage.BilledCost, billedUsage.UsageQuantityGB, resourceName)
-			} else {
-				workloadCost.BilledCost += billedUsage.BilledCost
-				workloadCost.UsageQuantity += billedUsage.UsageQuantityGB
-
-				workloadCostMap[workload] = workloadCost
+			workloadUsage, usageExists := workloadCostMap[workload]
+			if !usageExists {
+				workloadUsage = &networkplugin.BilledUsage{}
+				workloadCostMap[workload] = workloadUsage
 			}
+			workloadUsage.BilledCost = workloadUsage.BilledCost.Add(billedUsage.BilledCost)
+			workloadUsage.UsageQuantityGB = workloadUsage.UsageQuantityGB.Add(billedUsage.UsageQuantityGB)
 		}
 	}
 
@@ -464,11 +560,11 @@ func calculateBilledUsageAcrossPriceDimensions(
 		priceDimension := priceDimensions[i]
 
 		// get the current end range to check if we are in the correct tier before billing
-		var endRangeGB float64
-		if priceDimension.EndRange == "Inf" {
-			endRangeGB = math.Inf(1)
-		} else {
-			endRangeGB, err = strconv.ParseFloat(priceDimension.EndRange, 64)
+		// the last tier has no end, which leaves endRangeGB unset
+		unbounded := priceDimension.EndRange == "Inf"
+		var endRangeGB decimal.Decimal
+		if !unbounded {
+			endRangeGB, err = decimal.Parse(priceDimension.EndRange)
 			if err != nil {
 				return nil, totalBilledUsageBytes, fmt.Errorf("failed to parse end range: %v", err)
 			}
@@ -476,26 +572,27 @@ func calculateBilledUsageAcrossPriceDimensions(
 
 		// if the current total billed amount has passed the end range, skip this tier
 		totalBilledUsageGB := convertBytesToGB(totalBilledUsageBytes)
-		if totalBilledUsageGB >= endRangeGB {
+		if !unbounded && totalBilledUsageGB.Cmp(endRangeGB) >= 0 {
 			continue
 		}
 
 		// get price per unit from current pricing dimension to begin billed amount calculations
-		pricePerUnit, err := strconv.ParseFloat(priceDimension.PricePerUnit.USD, 64)
+		pricePerUnit, err := decimal.Parse(priceDimension.PricePerUnit.USD)
 		if err != nil {
 			return nil, totalBilledUsageBytes, fmt.Errorf("failed to parse price per unit: %v", err)
 		}
 
 		// determine amount to be billed based on current tier
 		// if pending amount exceeds the end range of the current tier, only bill the remaining amount at this tier
-		usagePendingBillingGB := convertBytesToGB(usagePendingBillingBytes)
-		maxUsageForCurrentTierGB := endRangeGB - totalBilledUsageGB
-		billedUsageGB := math.Min(usagePendingBillingGB, maxUsageForCurrentTierGB)
+		billedUsageGB := convertBytesToGB(usagePendingBillingBytes)
+		if !unbounded {
+			billedUsageGB = billedUsageGB.Min(endRangeGB.Sub(totalBilledUsageGB))
+		}
 
 		// calculate billed amount for current tier and add to response array
 		billedUsageForTier := networkplugin.BilledUsage{
-			UsageQuantityGB: float32(billedUsageGB),
-			BilledCost:      float32(billedUsageGB * pricePerUnit),
+			UsageQuantityGB: billedUsageGB,
+			BilledCost:      billedUsageGB.Mul(pricePerUnit),
 		}
 
 		billedUsageArray = append(billedUsageArray, billedUsageForTier)
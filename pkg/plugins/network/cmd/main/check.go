@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/opencost/opencost-plugins/pkg/plugins/network/networkplugin"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Check verifies that Prometheus, the Kubernetes API and the cloud provider's pricing API
+// can all be reached with the plugin's credentials before the plugin serves any requests.
+func (s *NetworkCostSource) Check(ctx context.Context) error {
+	var errs []error
+
+	promCtx, cancel := context.WithTimeout(ctx, s.prometheusTimeout)
+	defer cancel()
+	if _, _, err := s.prometheusClient.Query(promCtx, "vector(1)", time.Now()); err != nil {
+		errs = append(errs, fmt.Errorf("could not query prometheus_url: %v", err))
+	}
+
+	nodes, err := s.k8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{Limit: 1})
+	switch {
+	case k8serrors.IsForbidden(err) || k8serrors.IsUnauthorized(err):
+		errs = append(errs, fmt.Errorf("could not list nodes: the plugin's service account needs the list permission on nodes, to read the cluster's region"))
+	case err != nil:
+		errs = append(errs, fmt.Errorf("could not list nodes: %v", err))
+	case len(nodes.Items) == 0:
+		errs = append(errs, fmt.Errorf("could not read the cluster's region: the cluster has no nodes"))
+	default:
+		if _, ok := nodes.Items[0].Labels[networkplugin.K8S_REGION_LABEL]; !ok {
+			errs = append(errs, fmt.Errorf("could not read the cluster's region: label '%s' does not exist on node", networkplugin.K8S_REGION_LABEL))
+		}
+	}
+
+	if err := s.provider.Check(ctx, s); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
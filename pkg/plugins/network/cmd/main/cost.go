@@ -3,6 +3,10 @@ package main
 import (
 	"time"
 
+	"github.com/opencost/opencost-plugins/common/decimal"
+	"github.com/opencost/opencost-plugins/common/filter"
+	"github.com/opencost/opencost-plugins/pkg/plugins/network/networkplugin"
+	"github.com/opencost/opencost/core/pkg/log"
 	"github.com/opencost/opencost/core/pkg/model/pb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
@@ -21,6 +25,22 @@ func getCustomCostResponseWithMetadata(start time.Time, end time.Time) pb.Custom
 	}
 }
 
+// excludeCosts removes the costs the filters leave out of a response, and records what they cost in its metadata
+func excludeCosts(response *pb.CustomCostResponse, filters networkplugin.NetworkFilters) {
+	var excluded filter.Excluded
+	kept := []*pb.CustomCost{}
+	for _, cost := range response.Costs {
+		if !filters.Resource.Keeps(cost.ResourceName) {
+			log.Debugf("filters exclude %s cost %s", cost.ResourceName, cost.ProviderId)
+			excluded.Add(response.Currency, decimal.NewFromFloat32(cost.BilledCost))
+			continue
+		}
+		kept = append(kept, cost)
+	}
+	response.Costs = kept
+	excluded.Record(response)
+}
+
 func getBillingPeriodStartDate(queryStartDate time.Time, billingPeriodStartDate int) time.Time {
 	date := queryStartDate
 	dayDifference := billingPeriodStartDate - queryStartDate.Day()
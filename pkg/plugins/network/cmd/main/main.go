@@ -5,50 +5,111 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/opencost/opencost-plugins/common/budget"
+	commonconfig "github.com/opencost/opencost-plugins/common/config"
+	"github.com/opencost/opencost-plugins/common/costerror"
+	"github.com/opencost/opencost-plugins/common/runtime"
 	"github.com/opencost/opencost-plugins/pkg/plugins/network/networkplugin"
 	"github.com/opencost/opencost/core/pkg/model/pb"
+	ocplugin "github.com/opencost/opencost/core/pkg/plugin"
 
 	prometheusApiV1 "github.com/prometheus/client_golang/api/prometheus/v1"
 
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
+// Implementation of CustomCostSource
 type NetworkCostSource struct {
 	prometheusClient       prometheusApiV1.API
 	prometheusTimeout      time.Duration
-	k8sClient              *kubernetes.Clientset
+	k8sClient              kubernetes.Interface
 	billingPeriodStartDate int
+	budget                 budget.BudgetConfig
+	filters                networkplugin.NetworkFilters
+	// provider prices the cluster's data transfer. it is initialized once, and shared by every request
+	provider Provider
 }
 
 func (s *NetworkCostSource) GetCustomCosts(req *pb.CustomCostRequest) []*pb.CustomCostResponse {
 	// get and initialize provider based on cluster vendor
-	provider := getProvider()
-	err := provider.Init(s)
+	ctx, cancel := s.budget.Start(context.Background())
+	defer cancel()
+
+	provider := s.provider
+	err := provider.Init(ctx, s)
 	if err != nil {
 		results := []*pb.CustomCostResponse{}
 		return generateErrorResponse("failed to initialize provider", err, results)
 	}
 
 	// get region from node labels
-	region, err := s.getRegionFromNodeLabels()
+	region, err := s.getRegionFromNodeLabels(ctx)
 	if err != nil {
 		results := []*pb.CustomCostResponse{}
 		return generateErrorResponse("failed to fetch region from node labels", err, results)
 	}
 
-	return provider.GetNetworkCost(s, req, region)
+	return provider.GetNetworkCost(ctx, s, req, region)
+}
+
+func main() {
+	runtime.Serve("network", newNetworkCostSource)
 }
 
-func (s *NetworkCostSource) getRegionFromNodeLabels() (string, error) {
-	nodes, err := s.k8sClient.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+func newNetworkCostSource(configBytes []byte) (ocplugin.CustomCostSource, error) {
+	networkConfig, err := getNetworkConfig(configBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error building network config: %v", err)
+	}
+
+	prometheusClient, k8sClient, err := getNetworkCostClients(*networkConfig)
 	if err != nil {
+		return nil, fmt.Errorf("error building network cost clients: %v", err)
+	}
+
+	networkCostSrc := NetworkCostSource{
+		prometheusClient:       prometheusClient,
+		prometheusTimeout:      networkConfig.PrometheusTimeout,
+		k8sClient:              k8sClient,
+		billingPeriodStartDate: networkConfig.BillingPeriodStartDate,
+		budget:                 networkConfig.BudgetConfig,
+		filters:                networkConfig.Filters,
+		provider:               getProvider(),
+	}
+
+	return &networkCostSrc, nil
+}
+
+func getNetworkConfig(configBytes []byte) (*networkplugin.NetworkConfig, error) {
+	var result networkplugin.NetworkConfig
+	err := commonconfig.Decode(configBytes, &result)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network config: %v", err)
+	}
+
+	return &result, nil
+}
+
+func (s *NetworkCostSource) getRegionFromNodeLabels(ctx context.Context) (string, error) {
+	nodes, err := s.k8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if k8serrors.IsForbidden(err) {
+		return "", costerror.Wrap(costerror.Permission, err)
+	} else if k8serrors.IsUnauthorized(err) {
+		return "", costerror.Wrap(costerror.Auth, err)
+	} else if err != nil {
 		return "", err
 	}
 
+	// nodes the service account may not see are left out of the list, rather than failing it
+	if len(nodes.Items) == 0 {
+		return "", costerror.New(costerror.Permission, "no nodes listed to read the cluster's region from, the plugin's service account needs the list permission on nodes")
+	}
+
 	if region, ok := nodes.Items[0].Labels[networkplugin.K8S_REGION_LABEL]; ok {
 		return region, nil
 	} else {
-		return "", fmt.Errorf("label '%s' does not exist on node", networkplugin.K8S_REGION_LABEL)
+		return "", costerror.New(costerror.UnsupportedRequest, "label '%s' does not exist on node", networkplugin.K8S_REGION_LABEL)
 	}
 }
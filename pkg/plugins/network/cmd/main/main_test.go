@@ -1,14 +1,23 @@
 package main
 
 import (
+	"context"
 	"testing"
 	"time"
 
+	"github.com/opencost/opencost-plugins/common/decimal"
+	"github.com/opencost/opencost-plugins/common/filter"
 	"github.com/opencost/opencost-plugins/pkg/plugins/network/networkplugin"
 	"github.com/opencost/opencost/core/pkg/model/pb"
+	"github.com/opencost/opencost/core/pkg/opencost"
 	"github.com/opencost/opencost/core/pkg/util/timeutil"
+	prometheusApiV1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
 	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestGetCustomCosts(t *testing.T) {
@@ -37,7 +46,147 @@ func TestGetCustomCosts(t *testing.T) {
 		prometheusTimeout:      config.PrometheusTimeout,
 		k8sClient:              k8sClient,
 		billingPeriodStartDate: config.BillingPeriodStartDate,
+		provider:               getProvider(),
 	}
 
 	networkCostSource.GetCustomCosts(req)
 }
+
+func TestHourlyTieredCostsSumToMonthlyTotal(t *testing.T) {
+	priceDimensions := []networkplugin.PriceDimension{
+		getPriceDimensionFromValues("0", "10240", "0.09"),
+		getPriceDimensionFromValues("10240", "51200", "0.085"),
+		getPriceDimensionFromValues("51200", "Inf", "0.07"),
+	}
+	workload := networkplugin.Workload{Name: "api", Type: "deployment"}
+
+	// a little over 20GB an hour crosses from the first tier into the second during the month
+	const hourlyBytes = 20*1024*1024*1024 + 123457
+	const hours = 720
+
+	var hourlySum decimal.Decimal
+	var totalBilledUsageBytes int64
+	for hour := 0; hour < hours; hour++ {
+		billed, updated, err := calculateBilledUsageAcrossPriceDimensions(totalBilledUsageBytes, hourlyBytes, priceDimensions)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		totalBilledUsageBytes = updated
+
+		usage := &networkplugin.BilledUsage{}
+		for _, b := range billed {
+			usage.BilledCost = usage.BilledCost.Add(b.BilledCost)
+			usage.UsageQuantityGB = usage.UsageQuantityGB.Add(b.UsageQuantityGB)
+		}
+		cost := createAwsCustomCost(usage, "internet", workload)
+		hourlySum = hourlySum.Add(decimal.NewFromFloat32(cost.BilledCost))
+	}
+
+	monthly, _, err := calculateBilledUsageAcrossPriceDimensions(0, hours*hourlyBytes, priceDimensions)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var monthlyTotal decimal.Decimal
+	for _, b := range monthly {
+		monthlyTotal = monthlyTotal.Add(b.BilledCost)
+	}
+
+	if len(monthly) != 2 {
+		t.Errorf("expected the month to span two tiers, got %d", len(monthly))
+	}
+	if hourlySum.Round(2).Cmp(monthlyTotal.Round(2)) != 0 {
+		t.Errorf("expected hourly costs to sum to the monthly total %s, got %s", monthlyTotal.Round(2), hourlySum.Round(2))
+	}
+}
+
+func TestGetRegionFromNodeLabels(t *testing.T) {
+	src := &NetworkCostSource{k8sClient: fake.NewSimpleClientset()}
+	if _, err := src.getRegionFromNodeLabels(context.Background()); err == nil {
+		t.Errorf("expected an error when no nodes are listed")
+	}
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "node-1",
+		Labels: map[string]string{networkplugin.K8S_REGION_LABEL: "us-east-1"},
+	}}
+	src = &NetworkCostSource{k8sClient: fake.NewSimpleClientset(node)}
+	if region, err := src.getRegionFromNodeLabels(context.Background()); err != nil || region != "us-east-1" {
+		t.Errorf("expected the node's region, got %q, %v", region, err)
+	}
+}
+
+// fakePrometheus answers range queries with a fixed result per query
+type fakePrometheus struct {
+	prometheusApiV1.API
+	results map[string]model.Matrix
+}
+
+func (f fakePrometheus) QueryRange(ctx context.Context, query string, r prometheusApiV1.Range, opts ...prometheusApiV1.Option) (model.Value, prometheusApiV1.Warnings, error) {
+	return f.results[query], nil, nil
+}
+
+func TestInterZoneCostsSeparateIngressFromEgress(t *testing.T) {
+	start := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	window := opencost.NewClosedWindow(start, start.Add(timeutil.Day))
+	req := &pb.CustomCostRequest{
+		Start:      timestamppb.New(start),
+		End:        timestamppb.New(start.Add(timeutil.Day)),
+		Resolution: durationpb.New(timeutil.Day),
+	}
+	stream := func(owner string, bytes float64) *model.SampleStream {
+		return &model.SampleStream{
+			Metric: model.Metric{
+				networkplugin.PROMETHEUS_LABEL_SRC_ZONE:       "us-east-1a",
+				networkplugin.PROMETHEUS_LABEL_DST_ZONE:       "us-east-1b",
+				networkplugin.PROMETHEUS_LABEL_SRC_OWNER_NAME: model.LabelValue(owner),
+				networkplugin.PROMETHEUS_LABEL_SRC_OWNER_TYPE: "deployment",
+			},
+			Values: []model.SamplePair{{Timestamp: model.TimeFromUnix(start.Unix()), Value: model.SampleValue(bytes)}},
+		}
+	}
+	const gb = 1024 * 1024 * 1024
+	src := &NetworkCostSource{
+		prometheusClient: fakePrometheus{results: map[string]model.Matrix{
+			"increase(netobserv_workload_ingress_bytes_total[24h0m0s])": {stream("api", gb)},
+			"increase(netobserv_workload_egress_bytes_total[24h0m0s])":  {stream("web", 2*gb)},
+		}},
+		prometheusTimeout:      time.Second,
+		billingPeriodStartDate: 1,
+	}
+	priceDimensions := []networkplugin.PriceDimension{getPriceDimensionFromValues("0", "Inf", "0.01")}
+
+	costs, err := calculateInterZoneCostsForWindow(context.Background(), window, src, req, priceDimensions)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	byProviderID := map[string]*pb.CustomCost{}
+	for _, cost := range costs {
+		byProviderID[cost.ProviderId] = cost
+	}
+	if ingress := byProviderID["deployment/api/Ingress Inter Zone"]; len(costs) != 2 || ingress == nil || ingress.UsageQuantity != 1 {
+		t.Errorf("expected 1GB of ingress for api, got %v", costs)
+	}
+	if egress := byProviderID["deployment/web/Egress Inter Zone"]; egress == nil || egress.UsageQuantity != 2 {
+		t.Errorf("expected 2GB of egress for web, got %v", costs)
+	}
+}
+
+func TestExcludeCosts(t *testing.T) {
+	response := getCustomCostResponseWithMetadata(time.Date(2025, 6, 9, 0, 0, 0, 0, time.UTC), time.Date(2025, 6, 10, 0, 0, 0, 0, time.UTC))
+	response.Costs = []*pb.CustomCost{
+		{ResourceName: "Ingress Inter Zone", BilledCost: 0.25},
+		{ResourceName: "Egress Inter Zone", BilledCost: 0.5},
+		{ResourceName: "Egress Internet", BilledCost: 1.5},
+	}
+	filters := networkplugin.NetworkFilters{Resource: filter.Filter{Exclude: []string{"* inter zone"}}}
+
+	excludeCosts(&response, filters)
+
+	if len(response.Costs) != 1 || response.Costs[0].ResourceName != "Egress Internet" {
+		t.Errorf("expected only internet egress to be kept, got %v", response.Costs)
+	}
+	if response.Metadata[filter.ExcludedCostsKey] != "2" || response.Metadata[filter.ExcludedBilledCostKey] != "USD=0.75" {
+		t.Errorf("unexpected excluded totals: %v", response.Metadata)
+	}
+}
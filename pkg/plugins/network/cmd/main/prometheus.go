@@ -12,7 +12,7 @@ import (
 	"github.com/prometheus/common/model"
 )
 
-func (s *NetworkCostSource) getSumOfInterZoneDataSinceBillingPeriodStart(req *pb.CustomCostRequest, query string) (int64, error) {
+func (s *NetworkCostSource) getSumOfInterZoneDataSinceBillingPeriodStart(ctx context.Context, req *pb.CustomCostRequest, query string) (int64, error) {
 	// create a range between the start of the billing period and the start of the request query range
 	start := getBillingPeriodStartDate(req.Start.AsTime(), s.billingPeriodStartDate)
 	end := req.Start.AsTime()
@@ -24,7 +24,7 @@ func (s *NetworkCostSource) getSumOfInterZoneDataSinceBillingPeriodStart(req *pb
 	}
 
 	// query the Prometheus API for workload inter-zone bytes within the given range
-	results, err := s.queryPrometheusData(query, start, end, step)
+	results, err := s.queryPrometheusData(ctx, query, start, end, step)
 	if err != nil {
 		return 0, err
 	}
@@ -56,7 +56,7 @@ func (s *NetworkCostSource) getSumOfInterZoneDataSinceBillingPeriodStart(req *pb
 	return sum, nil
 }
 
-func (s *NetworkCostSource) getSumOfInternetDataSinceBillingPeriodStart(req *pb.CustomCostRequest) (int64, error) {
+func (s *NetworkCostSource) getSumOfInternetDataSinceBillingPeriodStart(ctx context.Context, req *pb.CustomCostRequest) (int64, error) {
 	// create a range between the start of the billing period and the start of the request query range
 	start := getBillingPeriodStartDate(req.Start.AsTime(), s.billingPeriodStartDate)
 	end := req.Start.AsTime()
@@ -68,7 +68,7 @@ func (s *NetworkCostSource) getSumOfInternetDataSinceBillingPeriodStart(req *pb.
 	}
 
 	// query the Prometheus API for cluster internet bytes within the given range
-	results, err := s.queryPrometheusData(networkplugin.QUERY_CLUSTER_EXTERNAL_EGRESS_BYTES_TOTAL, start, end, step)
+	results, err := s.queryPrometheusData(ctx, networkplugin.QUERY_CLUSTER_EXTERNAL_EGRESS_BYTES_TOTAL, start, end, step)
 	if err != nil {
 		return 0, err
 	}
@@ -86,7 +86,7 @@ func (s *NetworkCostSource) getSumOfInternetDataSinceBillingPeriodStart(req *pb.
 	return sum, nil
 }
 
-func (s *NetworkCostSource) queryPrometheusData(query string, start time.Time, end time.Time, step time.Duration) (model.Value, error) {
+func (s *NetworkCostSource) queryPrometheusData(ctx context.Context, query string, start time.Time, end time.Time, step time.Duration) (model.Value, error) {
 	queryRange := prometheusApiV1.Range{
 		Start: start,
 		End:   end,
@@ -95,7 +95,7 @@ func (s *NetworkCostSource) queryPrometheusData(query string, start time.Time, e
 
 	formattedQuery := fmt.Sprintf(query, step.String())
 
-	ctx, cancel := context.WithTimeout(context.TODO(), s.prometheusTimeout)
+	ctx, cancel := context.WithTimeout(ctx, s.prometheusTimeout)
 	defer cancel()
 
 	results, warnings, err := s.prometheusClient.QueryRange(ctx, formattedQuery, queryRange)
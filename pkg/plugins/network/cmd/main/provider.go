@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
+
 	"github.com/opencost/opencost/core/pkg/model/pb"
 )
 
 type Provider interface {
-	Init(src *NetworkCostSource) error
-	GetNetworkCost(src *NetworkCostSource, req *pb.CustomCostRequest, region string) []*pb.CustomCostResponse
+	Init(ctx context.Context, src *NetworkCostSource) error
+	// Check initializes the provider and verifies its pricing API credentials
+	Check(ctx context.Context, src *NetworkCostSource) error
+	GetNetworkCost(ctx context.Context, src *NetworkCostSource, req *pb.CustomCostRequest, region string) []*pb.CustomCostResponse
 }
 
 // TODO: implement way to get correct provider
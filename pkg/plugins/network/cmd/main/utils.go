@@ -2,10 +2,12 @@ package main
 
 import (
 	"fmt"
-	"math"
 	"os"
 	"path/filepath"
 
+	"github.com/opencost/opencost-plugins/common/costerror"
+	"github.com/opencost/opencost-plugins/common/decimal"
+	"github.com/opencost/opencost-plugins/common/httpclient"
 	"github.com/opencost/opencost-plugins/pkg/plugins/network/networkplugin"
 	"github.com/opencost/opencost/core/pkg/log"
 	"github.com/opencost/opencost/core/pkg/model/pb"
@@ -19,7 +21,8 @@ import (
 func getNetworkCostClients(config networkplugin.NetworkConfig) (prometheusApiV1.API, *kubernetes.Clientset, error) {
 	// init prometheus client
 	client, err := prometheusApi.NewClient(prometheusApi.Config{
-		Address: config.PrometheusURL,
+		Address:      config.PrometheusURL,
+		RoundTripper: &httpclient.Transport{Base: prometheusApi.DefaultRoundTripper},
 	})
 	if err != nil {
 		return nil, nil, err
@@ -47,22 +50,22 @@ func getNetworkCostClients(config networkplugin.NetworkConfig) (prometheusApiV1.
 	return prometheusApiV1Client, clientset, nil
 }
 
-func convertBytesToGB(bytes int64) float64 {
-	return float64(bytes) / math.Pow(1024, 3)
+// bytesPerGB is the size of the GiB that AWS bills data transfer in
+var bytesPerGB = decimal.NewFromInt(1024 * 1024 * 1024)
+
+func convertBytesToGB(bytes int64) decimal.Decimal {
+	return decimal.NewFromInt(bytes).Div(bytesPerGB)
 }
 
-func convertGBToBytes(gb float64) int64 {
-	return int64(math.Round(gb * math.Pow(1024, 3)))
+func convertGBToBytes(gb decimal.Decimal) int64 {
+	return gb.Mul(bytesPerGB).Round(0).Int64()
 }
 
 func generateErrorResponse(msg string, err error, results []*pb.CustomCostResponse) []*pb.CustomCostResponse {
 	errMsg := fmt.Sprintf("%s: %v", msg, err)
 
 	log.Error(errMsg)
-	errResponse := pb.CustomCostResponse{
-		Errors: []string{errMsg},
-	}
-
-	results = append(results, &errResponse)
+	// the error keeps its kind through the wrapping
+	results = append(results, costerror.Response(fmt.Errorf("%s: %w", msg, err)))
 	return results
 }
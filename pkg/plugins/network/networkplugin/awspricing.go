@@ -1,5 +1,7 @@
 package networkplugin
 
+import "github.com/opencost/opencost-plugins/common/decimal"
+
 type ProductPrice struct {
 	Product Product `json:"product"`
 	Terms   Terms   `json:"terms"`
@@ -34,6 +36,6 @@ type PricePerUnit struct {
 }
 
 type BilledUsage struct {
-	UsageQuantityGB float32
-	BilledCost      float32
+	UsageQuantityGB decimal.Decimal
+	BilledCost      decimal.Decimal
 }
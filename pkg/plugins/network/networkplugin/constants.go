@@ -9,6 +9,10 @@ const PROMETHEUS_LABEL_DST_ZONE = "DstK8S_Zone"
 const PROMETHEUS_LABEL_SRC_OWNER_NAME = "SrcK8S_OwnerName"
 const PROMETHEUS_LABEL_SRC_OWNER_TYPE = "SrcK8S_OwnerType"
 
+// COST METADATA
+const METADATA_OWNER_NAME = "owner_name"
+const METADATA_OWNER_TYPE = "owner_type"
+
 const QUERY_WORKLOAD_INGRESS_BYTES_TOTAL = "increase(netobserv_workload_ingress_bytes_total[%s])"
 const QUERY_WORKLOAD_EGRESS_BYTES_TOTAL = "increase(netobserv_workload_egress_bytes_total[%s])"
 const QUERY_CLUSTER_EXTERNAL_EGRESS_BYTES_TOTAL = "increase(netobserv_cluster_external_egress_bytes_total[%s])"
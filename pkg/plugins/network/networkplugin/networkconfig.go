@@ -1,9 +1,32 @@
 package networkplugin
 
-import "time"
+import (
+	"time"
+
+	"github.com/opencost/opencost-plugins/common/budget"
+	"github.com/opencost/opencost-plugins/common/currency"
+	"github.com/opencost/opencost-plugins/common/filter"
+	"github.com/opencost/opencost-plugins/common/labels"
+	"github.com/opencost/opencost-plugins/common/metrics"
+)
 
 type NetworkConfig struct {
-	PrometheusURL          string        `json:"prometheus_url"`
-	PrometheusTimeout      time.Duration `json:"prometheus_timeout"`
-	BillingPeriodStartDate int           `json:"billing_period_start_date"`
+	PrometheusURL string `json:"prometheus_url" required:"true"`
+	// prometheus_timeout is given as a duration string such as "30s"
+	PrometheusTimeout time.Duration `json:"prometheus_timeout" default:"30s"`
+	// billing periods start on the first of the month unless told otherwise
+	BillingPeriodStartDate int    `json:"billing_period_start_date" default:"1" min:"1" max:"31"`
+	LogLevel               string `json:"log_level" default:"info" oneof:"trace debug info warn error"`
+	// Filters leave out data transfer costs that are already allocated elsewhere
+	Filters NetworkFilters `json:"filters"`
+	budget.BudgetConfig
+	metrics.MetricsConfig
+	currency.CurrencyConfig
+	labels.LabelsConfig
+}
+
+// NetworkFilters select the data transfer costs are reported for
+type NetworkFilters struct {
+	// Resource matches the name of the data transfer billed, such as "Egress Internet"
+	Resource filter.Filter `json:"resource"`
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Check verifies the configured API key before the plugin serves any requests, by requesting
+// today's token usage and billing export once each. The billing export is only available to
+// keys of organization owners, so a key that can read usage may still fail the second probe.
+func (d *OpenAICostSource) Check(ctx context.Context) error {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	tomorrow := today.Add(24 * time.Hour)
+
+	var errs []error
+	if err := d.probe(ctx, fmt.Sprintf(openAIUsageURLFmt, today.Format(openAIAPIDateFormat))); err != nil {
+		errs = append(errs, fmt.Errorf("could not read token usage: %v", err))
+	}
+
+	if err := d.probe(ctx, fmt.Sprintf(openAIBillingURLFmt, today.Format(openAIAPIDateFormat), tomorrow.Format(openAIAPIDateFormat))); err != nil {
+		errs = append(errs, fmt.Errorf("could not read the billing export: %v", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// probe makes a single authenticated GET request, explaining any refusal in terms of the API key
+func (d *OpenAICostSource) probe(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", d.config.APIKey))
+
+	resp, err := openAIClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return fmt.Errorf("openai_api_key is invalid or has been revoked (HTTP %d)", resp.StatusCode)
+	case http.StatusForbidden:
+		return fmt.Errorf("openai_api_key lacks billing access. use a key created by an organization owner with read access to usage (HTTP %d)", resp.StatusCode)
+	default:
+		return fmt.Errorf("unexpected response: HTTP %d", resp.StatusCode)
+	}
+}
@@ -5,9 +5,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/opencost/opencost-plugins/common/costerror"
+	"github.com/opencost/opencost-plugins/common/decimal"
+	"github.com/opencost/opencost-plugins/common/filter"
 	openaiplugin "github.com/opencost/opencost-plugins/pkg/plugins/openai/openaiplugin"
 	"github.com/opencost/opencost/core/pkg/log"
 	"github.com/opencost/opencost/core/pkg/model/pb"
+	"github.com/opencost/opencost/core/pkg/opencost"
 	"github.com/opencost/opencost/core/pkg/util/timeutil"
 	"golang.org/x/time/rate"
 	"google.golang.org/protobuf/types/known/durationpb"
@@ -51,3 +55,60 @@ func TestGetCustomCosts(t *testing.T) {
 		t.Fatalf("empty response")
 	}
 }
+
+func TestGetCustomCostsRejectsHourlyResolution(t *testing.T) {
+	oaiCostSrc := OpenAICostSource{
+		rateLimiter: rate.NewLimiter(1, 5),
+		config:      &openaiplugin.OpenAIConfig{},
+	}
+
+	windowStart := time.Date(2024, 10, 9, 0, 0, 0, 0, time.UTC)
+	req := &pb.CustomCostRequest{
+		Start:      timestamppb.New(windowStart),
+		End:        timestamppb.New(windowStart.Add(2 * time.Hour)),
+		Resolution: durationpb.New(time.Hour),
+	}
+
+	resp := oaiCostSrc.GetCustomCosts(req)
+	if len(resp) != 1 {
+		t.Fatalf("expected a single response reporting the rejected request, got %d", len(resp))
+	}
+	if kinds := costerror.Kinds(resp[0]); len(kinds) != 1 || kinds[0] != costerror.UnsupportedRequest {
+		t.Errorf("expected an unsupported_request error, got %v: %v", kinds, resp[0].Errors)
+	}
+}
+
+func TestGetCustomCostsFromUsageAndBillingFilters(t *testing.T) {
+	windowStart := time.Date(2024, 10, 9, 0, 0, 0, 0, time.UTC)
+	window := opencost.NewClosedWindow(windowStart, windowStart.Add(timeutil.Day))
+	entry := func(projectID, model string, cents int64) openaiplugin.BillingData {
+		return openaiplugin.BillingData{
+			Currency:       "usd",
+			Name:           model,
+			OrganizationID: "org-1",
+			ProjectID:      projectID,
+			CostInMajor:    decimal.New(cents, -2),
+			Date:           "2024-10-09",
+		}
+	}
+	billing := &openaiplugin.OpenAIBilling{Data: []openaiplugin.BillingData{
+		entry("proj_app", "gpt-4o", 125),
+		entry("proj_research", "gpt-4o", 1050),
+		entry("proj_app", "gpt-4o-mini", 10),
+	}}
+	filters := openaiplugin.OpenAIFilters{
+		Project: filter.Filter{Exclude: []string{"proj_research"}},
+		Model:   filter.Filter{Exclude: []string{"*-mini"}},
+	}
+
+	costs, excluded, err := getCustomCostsFromUsageAndBilling(window, nil, billing, filters)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(costs) != 1 || costs[0].ResourceName != "gpt-4o" || *costs[0].ExtendedAttributes.SubAccountId != "proj_app" {
+		t.Errorf("expected only gpt-4o usage by proj_app to be kept, got %v", costs)
+	}
+	if excluded.Costs != 2 || excluded.Billed["USD"].String() != "10.6" {
+		t.Errorf("expected 2 entries totalling 10.6 USD to be excluded, got %v", excluded)
+	}
+}
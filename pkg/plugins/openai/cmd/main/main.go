@@ -6,18 +6,26 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
 	"golang.org/x/time/rate"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
-	"github.com/google/uuid"
-	"github.com/hashicorp/go-plugin"
-	commonconfig "github.com/opencost/opencost-plugins/pkg/common/config"
+	"github.com/opencost/opencost-plugins/common/accounts"
+	"github.com/opencost/opencost-plugins/common/budget"
+	"github.com/opencost/opencost-plugins/common/cache"
+	commonconfig "github.com/opencost/opencost-plugins/common/config"
+	"github.com/opencost/opencost-plugins/common/costerror"
+	"github.com/opencost/opencost-plugins/common/costid"
+	"github.com/opencost/opencost-plugins/common/currency"
+	"github.com/opencost/opencost-plugins/common/decimal"
+	"github.com/opencost/opencost-plugins/common/filter"
+	"github.com/opencost/opencost-plugins/common/finality"
+	"github.com/opencost/opencost-plugins/common/httpclient"
+	"github.com/opencost/opencost-plugins/common/metrics"
+	"github.com/opencost/opencost-plugins/common/runtime"
 	openaiplugin "github.com/opencost/opencost-plugins/pkg/plugins/openai/openaiplugin"
 	"github.com/opencost/opencost/core/pkg/log"
 	"github.com/opencost/opencost/core/pkg/model/pb"
@@ -26,24 +34,21 @@ import (
 	"github.com/opencost/opencost/core/pkg/util/timeutil"
 )
 
-// handshakeConfigs are used to just do a basic handshake between
-// a plugin and host. If the handshake fails, a user friendly error is shown.
-// This prevents users from executing bad plugins or executing a plugin
-// directory. It is a UX feature, not a security feature.
-var handshakeConfig = plugin.HandshakeConfig{
-	ProtocolVersion:  1,
-	MagicCookieKey:   "PLUGIN_NAME",
-	MagicCookieValue: "openai",
-}
-
 const openAIUsageURLFmt = "https://api.openai.com/v1/usage?date=%s"
 const openAIBillingURLFmt = "https://api.openai.com/v1/dashboard/billing/usage/export?exclude_project_costs=false&file_format=json&new_endpoint=true&project_id&start_date=%s&end_date=%s"
 const openAIAPIDateFormat = "2006-01-02"
 
+// OpenAI usage and billing data can be revised for 48 hours, after which windows are cached
+const openAIFinalityHorizon = 48 * time.Hour
+
+// openAIClient retries rate limited and failed requests, honoring OpenAI's rate limit headers
+var openAIClient = httpclient.NewClient(nil)
+
 // Implementation of CustomCostSource
 type OpenAICostSource struct {
 	rateLimiter *rate.Limiter
 	config      *openaiplugin.OpenAIConfig
+	cache       *cache.WindowCache
 }
 
 func (d *OpenAICostSource) GetCustomCosts(req *pb.CustomCostRequest) []*pb.CustomCostResponse {
@@ -52,62 +57,77 @@ func (d *OpenAICostSource) GetCustomCosts(req *pb.CustomCostRequest) []*pb.Custo
 	targets, err := opencost.GetWindows(req.Start.AsTime(), req.End.AsTime(), req.Resolution.AsDuration())
 	if err != nil {
 		log.Errorf("error getting windows: %v", err)
-		errResp := pb.CustomCostResponse{
-			Errors: []string{fmt.Sprintf("error getting windows: %v", err)},
-		}
-		results = append(results, &errResp)
+		results = append(results, costerror.Response(costerror.New(costerror.UnsupportedRequest, "error getting windows: %v", err)))
 		return results
 	}
 
 	if req.Resolution.AsDuration() != timeutil.Day {
 		log.Infof("openai plugin only supports daily resolution")
+		results = append(results, costerror.Response(costerror.New(costerror.UnsupportedRequest, "openai plugin only supports daily resolution, got %v", req.Resolution.AsDuration())))
 		return results
 	}
 
-	for _, target := range targets {
+	// every upstream call shares the request's budget
+	ctx, cancel := d.config.BudgetConfig.Start(context.Background())
+	defer cancel()
+
+	// windows are fetched concurrently. every OpenAI request waits on the shared rate limiter
+	return d.config.ExecutorConfig.Run(targets, func(target opencost.Window) (*pb.CustomCostResponse, error) {
 		// don't allow future request
 		if target.Start().After(time.Now().UTC()) {
 			log.Debugf("skipping future window %v", target)
-			continue
+			return nil, nil
 		}
 
 		log.Debugf("fetching Open AI costs for window %v", target)
-		result := d.getOpenAICostsForWindow(target)
-		results = append(results, result)
-	}
-
-	return results
+		// the API key identifies the organization. the cache hashes it before it is written to disk
+		resp := d.cache.Fetch(filter.CacheAccount(d.config.APIKey, d.config.Filters), target, func() *pb.CustomCostResponse {
+			if budget.Exhausted(ctx) {
+				ccResp := boilerplateOpenAICustomCost(target)
+				costerror.Add(&ccResp, budget.WindowError(ctx))
+				return &ccResp
+			}
+			return d.getOpenAICostsForWindow(ctx, target)
+		})
+		// billing settles within the finality horizon, the same wait before a window is cached. a window
+		// the budget ran out before stays provisional
+		finality.Mark(resp, finality.Provisional, target.End().Add(openAIFinalityHorizon), time.Now())
+		return resp, nil
+	})
 }
 
 func main() {
+	runtime.Serve("openai", newOpenAICostSource)
+}
 
-	configFile, err := commonconfig.GetConfigFilePath()
+func newOpenAICostSource(configBytes []byte) (ocplugin.CustomCostSource, error) {
+	oaiConfig, err := getOpenAIConfig(configBytes)
 	if err != nil {
-		log.Fatalf("error opening config file: %v", err)
+		return nil, fmt.Errorf("error building OpenAI config: %v", err)
 	}
 
-	oaiConfig, err := getOpenAIConfig(configFile)
+	windowCache, err := cache.New(oaiConfig.CacheConfig, "openai", openAIFinalityHorizon)
 	if err != nil {
-		log.Fatalf("error building OpenAI config: %v", err)
-	}
-	log.SetLogLevel(oaiConfig.LogLevel)
-	// rate limit to 1 request per second
-	rateLimiter := rate.NewLimiter(0.5, 1)
-	oaiCostSrc := OpenAICostSource{
-		rateLimiter: rateLimiter,
-		config:      oaiConfig,
+		return nil, fmt.Errorf("error creating OpenAI window cache: %v", err)
 	}
 
-	// pluginMap is the map of plugins we can dispense.
-	var pluginMap = map[string]plugin.Plugin{
-		"CustomCostSource": &ocplugin.CustomCostPlugin{Impl: &oaiCostSrc},
+	var orgs []accounts.Account
+	for _, account := range oaiConfig.AccountList() {
+		// each organization is queried with its own API key, as if it were configured on its own
+		accountConfig := *oaiConfig
+		accountConfig.APIKey = account.APIKey
+		accountConfig.Accounts = nil
+
+		oaiCostSrc := OpenAICostSource{
+			// rate limit to 1 request every 2 seconds, for each organization
+			rateLimiter: rate.NewLimiter(0.5, 1),
+			config:      &accountConfig,
+			cache:       windowCache,
+		}
+		orgs = append(orgs, accounts.Account{Name: account.Name, Source: &oaiCostSrc})
 	}
 
-	plugin.Serve(&plugin.ServeConfig{
-		HandshakeConfig: handshakeConfig,
-		Plugins:         pluginMap,
-		GRPCServer:      plugin.DefaultGRPCServer,
-	})
+	return accounts.Source(orgs), nil
 }
 
 func boilerplateOpenAICustomCost(win opencost.Window) pb.CustomCostResponse {
@@ -123,65 +143,91 @@ func boilerplateOpenAICustomCost(win opencost.Window) pb.CustomCostResponse {
 		Costs:      []*pb.CustomCost{},
 	}
 }
-func (d *OpenAICostSource) getOpenAICostsForWindow(window opencost.Window) *pb.CustomCostResponse {
+func (d *OpenAICostSource) getOpenAICostsForWindow(ctx context.Context, window opencost.Window) *pb.CustomCostResponse {
 	ccResp := boilerplateOpenAICustomCost(window)
 
-	oaiTokenUsages, err := d.getOpenAITokenUsages(*window.Start())
+	oaiTokenUsages, err := d.getOpenAITokenUsages(ctx, *window.Start())
 	if err != nil {
-		ccResp.Errors = append(ccResp.Errors, fmt.Sprintf("error getting OpenAI token usages: %v", err))
+		costerror.Add(&ccResp, fmt.Errorf("error getting OpenAI token usages: %w", err))
 	}
 
-	oaiBilling, err := d.getOpenAIBilling(*window.Start(), *window.End())
+	oaiBilling, err := d.getOpenAIBilling(ctx, *window.Start(), *window.End())
 	if err != nil {
-		ccResp.Errors = append(ccResp.Errors, fmt.Sprintf("error getting OpenAI billing data: %v", err))
+		costerror.Add(&ccResp, fmt.Errorf("error getting OpenAI billing data: %w", err))
 	}
 
-	customCosts, err := getCustomCostsFromUsageAndBilling(oaiTokenUsages, oaiBilling)
+	customCosts, excluded, err := getCustomCostsFromUsageAndBilling(window, oaiTokenUsages, oaiBilling, d.config.Filters)
 	if err != nil {
-		ccResp.Errors = append(ccResp.Errors, fmt.Sprintf("error converting API responses into custom costs: %v", err))
+		costerror.Add(&ccResp, costerror.New(costerror.ParseError, "error converting API responses into custom costs: %v", err))
 	}
 	ccResp.Costs = customCosts
+	excluded.Record(&ccResp)
 
 	return &ccResp
 }
 
-func getCustomCostsFromUsageAndBilling(usage *openaiplugin.OpenAIUsage, billing *openaiplugin.OpenAIBilling) ([]*pb.CustomCost, error) {
+// getCustomCostsFromUsageAndBilling converts the billing entries the filters keep into costs, and
+// totals the entries they leave out
+func getCustomCostsFromUsageAndBilling(window opencost.Window, usage *openaiplugin.OpenAIUsage, billing *openaiplugin.OpenAIBilling, filters openaiplugin.OpenAIFilters) ([]*pb.CustomCost, filter.Excluded, error) {
 	customCosts := []*pb.CustomCost{}
+	var excluded filter.Excluded
+	if billing == nil {
+		// billing data could not be fetched, which is already reported for the window
+		return customCosts, excluded, nil
+	}
 
 	tokenMap := buildTokenMap(usage)
 	for _, billingEntry := range billing.Data {
+		if !filters.Keeps(billingEntry.ProjectID, billingEntry.Name) {
+			log.Debugf("filters exclude %s usage by project %s", billingEntry.Name, billingEntry.ProjectID)
+			billedCurrency := billingEntry.Currency
+			if billedCurrency == "" {
+				billedCurrency = "USD"
+			}
+			excluded.Add(billedCurrency, billingEntry.CostInMajor)
+			continue
+		}
+
 		tokenMapKey := strings.ReplaceAll(strings.ToLower(billingEntry.Name), "-", "")
 		tokenMapKey = strings.ReplaceAll(tokenMapKey, " ", "")
 		tokenMapKey = strings.ReplaceAll(tokenMapKey, "_", "")
 
+		// FOCUS quantities can't be negative, so a model without token usage reports none
 		tokenCount, ok := tokenMap[tokenMapKey]
 		if !ok {
 			log.Debugf("no token usage found for %s", billingEntry.Name)
-			tokenCount = -1
 		}
 
 		extendedAttrs := pb.CustomCostExtendedAttributes{
 			AccountId:    &billingEntry.OrganizationID,
 			SubAccountId: &billingEntry.ProjectID,
 		}
+		if billingEntry.ProjectName != "" {
+			extendedAttrs.SubAccountName = &billingEntry.ProjectName
+		}
 		customCost := pb.CustomCost{
-			BilledCost:         float32(billingEntry.CostInMajor),
+			BilledCost:         billingEntry.CostInMajor.Float32(),
 			AccountName:        billingEntry.OrganizationName,
 			ChargeCategory:     "Usage",
 			Description:        fmt.Sprintf("OpenAI usage for model %s", billingEntry.Name),
 			ResourceName:       billingEntry.Name,
 			ResourceType:       "AI Model",
-			Id:                 uuid.New().String(),
 			ProviderId:         fmt.Sprintf("%s/%s/%s", billingEntry.OrganizationID, billingEntry.ProjectID, billingEntry.Name),
 			UsageQuantity:      float32(tokenCount),
 			UsageUnit:          "tokens - All snapshots, all projects",
 			ExtendedAttributes: &extendedAttrs,
 		}
+		// the same entry fetched again keeps its ID, so OpenCost can deduplicate it
+		customCost.Id = costid.New("openai", billingEntry.OrganizationID, *window.Start(), *window.End(), customCost.ProviderId, billingEntry.Date)
+		if billingEntry.Currency != "" {
+			// the response is reported in USD, so record the currency OpenAI actually billed in
+			customCost.Metadata = map[string]string{currency.SourceCurrencyKey: strings.ToUpper(billingEntry.Currency)}
+		}
 
 		customCosts = append(customCosts, &customCost)
 	}
 
-	return customCosts, nil
+	return customCosts, excluded, nil
 }
 
 var snapshotRe = regexp.MustCompile(`-\d{4}-\d{2}-\d{2}|-`)
@@ -203,158 +249,84 @@ func buildTokenMap(usage *openaiplugin.OpenAIUsage) map[string]int {
 	return tokenMap
 }
 
-func (d *OpenAICostSource) getOpenAIBilling(start time.Time, end time.Time) (*openaiplugin.OpenAIBilling, error) {
-	client := &http.Client{}
+func (d *OpenAICostSource) getOpenAIBilling(ctx context.Context, start time.Time, end time.Time) (*openaiplugin.OpenAIBilling, error) {
 	openAIBillingURL := fmt.Sprintf(openAIBillingURLFmt, start.Format(openAIAPIDateFormat), end.Format(openAIAPIDateFormat))
 	log.Debugf("fetching OpenAI billing data from %s", openAIBillingURL)
-	var errReq error
-	var resp *http.Response
-	for i := 0; i < 3; i++ {
-		err := d.rateLimiter.Wait(context.Background())
-		if err != nil {
-			log.Warnf("error waiting for rate limiter: %v", err)
-			return nil, fmt.Errorf("error waiting for rate limiter: %v", err)
-		}
-		var req *http.Request
-		req, errReq = http.NewRequest("GET", openAIBillingURL, nil)
-		if errReq != nil {
-			log.Warnf("error creating billing export request: %v", errReq)
-			log.Infof("retrying request after 30s")
-			time.Sleep(30 * time.Second)
-			continue
-		}
-
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", d.config.APIKey))
 
-		resp, errReq = client.Do(req)
-		if errReq != nil {
-			log.Warnf("error doing billing export request: %v", errReq)
-			log.Warnf("retrying requestafter 30s")
-			time.Sleep(30 * time.Second)
-			continue
-		}
-
-		if resp.StatusCode != http.StatusOK {
-			bodyBytes, err := io.ReadAll(resp.Body)
-			bodyString := "<empty>"
-			if err != nil {
-				log.Warnf("error reading body of non-200 response: %v", err)
-			} else {
-				bodyString = string(bodyBytes)
-			}
-
-			errReq = fmt.Errorf("received non-200 response for billing export request: %d", resp.StatusCode)
-			log.Warnf("got non-200 response for billing export request: %d, body is: %s", resp.StatusCode, bodyString)
-			log.Infof("retrying request after 30s")
-			time.Sleep(30 * time.Second)
-			continue
-		} else {
-			errReq = nil
-		}
-		// request was successful, break out of loop
-		break
-	}
-
-	if errReq != nil {
-		return nil, fmt.Errorf("error making request after retries: %v", errReq)
-	}
 	var billingData openaiplugin.OpenAIBilling
-	if err := json.NewDecoder(resp.Body).Decode(&billingData); err != nil {
-		return nil, fmt.Errorf("error decoding billing export response: %v", err)
+	if err := d.getOpenAI(ctx, openAIBillingURL, "billing export", &billingData); err != nil {
+		return nil, err
 	}
-	resp.Body.Close()
+
 	for i := range billingData.Data {
-		asFloat, err := strconv.ParseFloat(billingData.Data[i].CostInMajorStr, 64)
+		cost, err := decimal.Parse(billingData.Data[i].CostInMajorStr)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing cost: %v", err)
+			return nil, costerror.New(costerror.ParseError, "error parsing cost: %v", err)
 		}
-		billingData.Data[i].CostInMajor = asFloat
+		billingData.Data[i].CostInMajor = cost
 	}
 
 	return &billingData, nil
 }
 
-func (d *OpenAICostSource) getOpenAITokenUsages(targetTime time.Time) (*openaiplugin.OpenAIUsage, error) {
-	client := &http.Client{}
-
+func (d *OpenAICostSource) getOpenAITokenUsages(ctx context.Context, targetTime time.Time) (*openaiplugin.OpenAIUsage, error) {
 	openAIUsageURL := fmt.Sprintf(openAIUsageURLFmt, targetTime.Format(openAIAPIDateFormat))
 	log.Debugf("fetching OpenAI usage data from %s", openAIUsageURL)
-	var errReq error
-	var resp *http.Response
-	for i := 0; i < 3; i++ {
-		errReq = nil
-		err := d.rateLimiter.Wait(context.Background())
-		if err != nil {
-			log.Warnf("error waiting for rate limiter: %v", err)
-			return nil, fmt.Errorf("error waiting for rate limiter: %v", err)
-		}
-		var req *http.Request
-		req, errReq = http.NewRequest("GET", openAIUsageURL, nil)
-		if errReq != nil {
-			log.Warnf("error creating usage request: %v", errReq)
-			log.Warnf("retrying request after 30s")
-			time.Sleep(30 * time.Second)
-			continue
-		}
-
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", d.config.APIKey))
-
-		resp, errReq = client.Do(req)
-		if errReq != nil {
-			log.Warnf("error doing token request: %v", errReq)
-			log.Infof("retrying request after 30s")
-			time.Sleep(30 * time.Second)
-			continue
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			errReq = fmt.Errorf("received non-200 response for token usage request: %d", resp.StatusCode)
-			bodyBytes, err := io.ReadAll(resp.Body)
-			bodyString := "<empty>"
-			if err != nil {
-				log.Warnf("error reading body of non-200 response: %v", err)
-			} else {
-				bodyString = string(bodyBytes)
-			}
-			log.Warnf("got non-200 response for token usage request: %d, body is: %s", resp.StatusCode, bodyString)
-			log.Infof("retrying request after 30s")
-			time.Sleep(30 * time.Second)
-			continue
-		} else {
-			errReq = nil
-		}
-		// request was successful, break out of loop
-		break
-	}
-
-	if errReq != nil {
-		return nil, fmt.Errorf("error making request after retries: %v", errReq)
-	}
 
 	var usageData openaiplugin.OpenAIUsage
-	if err := json.NewDecoder(resp.Body).Decode(&usageData); err != nil {
-		return nil, fmt.Errorf("error decoding response: %v", err)
+	if err := d.getOpenAI(ctx, openAIUsageURL, "token usage", &usageData); err != nil {
+		return nil, err
 	}
 
 	return &usageData, nil
 }
 
-func getOpenAIConfig(configFilePath string) (*openaiplugin.OpenAIConfig, error) {
-	var result openaiplugin.OpenAIConfig
-	bytes, err := os.ReadFile(configFilePath)
+// getOpenAI makes an authenticated GET request to the OpenAI API and decodes the JSON response into target.
+// Rate limited and failed requests are retried by the client.
+func (d *OpenAICostSource) getOpenAI(ctx context.Context, url string, description string, target interface{}) error {
+	err := metrics.WaitRateLimit(ctx, d.rateLimiter)
 	if err != nil {
-		return nil, fmt.Errorf("error reading config file for openai config @ %s: %v", configFilePath, err)
+		log.Warnf("error waiting for rate limiter: %v", err)
+		return costerror.New(costerror.PartialData, "error waiting for rate limiter: %v", err)
 	}
-	err = json.Unmarshal(bytes, &result)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error marshaling json into openai config %v", err)
+		return fmt.Errorf("error creating %s request: %v", description, err)
 	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", d.config.APIKey))
 
-	if result.LogLevel == "" {
-		result.LogLevel = "info"
+	resp, err := openAIClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error doing %s request: %w", description, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, err := io.ReadAll(resp.Body)
+		bodyString := "<empty>"
+		if err != nil {
+			log.Warnf("error reading body of non-200 response: %v", err)
+		} else {
+			bodyString = string(bodyBytes)
+		}
+		log.Warnf("got non-200 response for %s request: %d, body is: %s", description, resp.StatusCode, bodyString)
+		return costerror.FromStatus(resp.StatusCode, "received non-200 response for %s request: %d", description, resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+		return costerror.New(costerror.ParseError, "error decoding %s response: %v", description, err)
+	}
+
+	return nil
+}
+
+func getOpenAIConfig(configBytes []byte) (*openaiplugin.OpenAIConfig, error) {
+	var result openaiplugin.OpenAIConfig
+	err := commonconfig.Decode(configBytes, &result)
+	if err != nil {
+		return nil, fmt.Errorf("invalid openai config: %v", err)
 	}
 
 	return &result, nil
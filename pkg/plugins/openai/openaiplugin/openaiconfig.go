@@ -1,6 +1,64 @@
 package openaiplugin
 
+import (
+	"github.com/opencost/opencost-plugins/common/accounts"
+	"github.com/opencost/opencost-plugins/common/budget"
+	"github.com/opencost/opencost-plugins/common/cache"
+	"github.com/opencost/opencost-plugins/common/currency"
+	"github.com/opencost/opencost-plugins/common/executor"
+	"github.com/opencost/opencost-plugins/common/filter"
+	"github.com/opencost/opencost-plugins/common/labels"
+	"github.com/opencost/opencost-plugins/common/metrics"
+)
+
 type OpenAIConfig struct {
-	APIKey   string `json:"openai_api_key"`
-	LogLevel string `json:"log_level"`
+	APIKey string `json:"openai_api_key"`
+	// Accounts lists the organizations to report costs for, each with its own API key, in place of the key above
+	Accounts []OpenAIAccount `json:"accounts"`
+	LogLevel string          `json:"log_level" default:"info" oneof:"trace debug info warn error"`
+	// Filters leave out costs that are already allocated elsewhere
+	Filters OpenAIFilters `json:"filters"`
+	cache.CacheConfig
+	budget.BudgetConfig
+	executor.ExecutorConfig
+	metrics.MetricsConfig
+	currency.CurrencyConfig
+	labels.LabelsConfig
+}
+
+// OpenAIFilters select the billed usage costs are reported for
+type OpenAIFilters struct {
+	// Project matches the ID of the project billed, such as "proj_abc123"
+	Project filter.Filter `json:"project"`
+	// Model matches the model billed, such as "gpt-4o-mini"
+	Model filter.Filter `json:"model"`
+}
+
+// Keeps reports whether the filters keep usage of a model by a project
+func (f OpenAIFilters) Keeps(projectID, model string) bool {
+	return f.Project.Keeps(projectID) && f.Model.Keeps(model)
+}
+
+// OpenAIAccount is one of several organizations reported on by a single plugin
+type OpenAIAccount struct {
+	Name   string `json:"name" required:"true"`
+	APIKey string `json:"openai_api_key" required:"true"`
+}
+
+// Validate requires either the API key of a single organization or a list of accounts.
+func (c *OpenAIConfig) Validate() []string {
+	var names []string
+	for _, account := range c.Accounts {
+		names = append(names, account.Name)
+	}
+	return accounts.ConfigProblems(names, accounts.Field{Key: "openai_api_key", Value: c.APIKey})
+}
+
+// AccountList returns the organizations to report costs for. A config with its API key at the top level
+// has a single account without a name.
+func (c *OpenAIConfig) AccountList() []OpenAIAccount {
+	if len(c.Accounts) > 0 {
+		return c.Accounts
+	}
+	return []OpenAIAccount{{APIKey: c.APIKey}}
 }
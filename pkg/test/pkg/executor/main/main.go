@@ -24,7 +24,7 @@ func main() {
 	var rootCmd = &cobra.Command{
 		Use:   "plugin-harness",
 		Short: "A test harness for opencost plugins",
-		Long:  `This program will invoke each plugin in turn, and will confirm no errors, and that the returned costs are non-zero.`,
+		Long:  `This program will invoke each plugin in turn, and will confirm no errors, that the returned costs are non-zero, and that the responses conform to FOCUS.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			log.Info("running opencost plugin integration test harness")
 			log.Info("this program will invoke each plugin in turn, and then will call a validator to confirm the results.")
@@ -73,47 +73,60 @@ func main() {
 				// invoke plugin via harness
 
 				pluginPath := cwd + "/pkg/plugins/" + plugin
-				respDaily := getResponse(pluginPath, file.Name(), windowStart, windowEnd, 24*time.Hour)
+				reqDaily := newRequest(windowStart, windowEnd, 24*time.Hour)
+				respDaily := getResponse(pluginPath, file.Name(), reqDaily)
 
 				// request usage for 3 days ago in hourly increments
 				windowStart = time.Now().AddDate(0, 0, -4).Truncate(24 * time.Hour)
 				windowEnd = time.Now().AddDate(0, 0, -3).Truncate(24 * time.Hour)
 				// invoke plugin via harness
-				respHourly := getResponse(pluginPath, file.Name(), windowStart, windowEnd, 1*time.Hour)
+				reqHourly := newRequest(windowStart, windowEnd, 1*time.Hour)
+				respHourly := getResponse(pluginPath, file.Name(), reqHourly)
 
-				// call validator if implemented
-				validator := validatorPath(pluginPath)
-				if validator != "" {
-					// write hourly cost response to a file
-					hourlyBytes, err := marshal(respHourly)
-					if err != nil {
-						log.Fatalf("error marshalling hourly response for plugin %s: %s", plugin, err)
-					}
-					hourlyFile, err := os.CreateTemp("", fmt.Sprintf("%s_hourly_response_*.pb", plugin))
-					if err != nil {
-						log.Fatalf("error creating temp file for hourly response for plugin %s: %s", plugin, err)
-					}
+				// write hourly cost response to a file
+				hourlyBytes, err := marshal(respHourly)
+				if err != nil {
+					log.Fatalf("error marshalling hourly response for plugin %s: %s", plugin, err)
+				}
+				hourlyFile, err := os.CreateTemp("", fmt.Sprintf("%s_hourly_response_*.pb", plugin))
+				if err != nil {
+					log.Fatalf("error creating temp file for hourly response for plugin %s: %s", plugin, err)
+				}
 
-					_, err = hourlyFile.Write(hourlyBytes)
-					if err != nil {
-						log.Fatalf("error writing hourly response for plugin %s: %s", plugin, err)
-					}
+				_, err = hourlyFile.Write(hourlyBytes)
+				if err != nil {
+					log.Fatalf("error writing hourly response for plugin %s: %s", plugin, err)
+				}
 
-					// write daily cost response to a file
-					dailyBytes, err := marshal(respDaily)
-					if err != nil {
-						log.Fatalf("error marshalling daily response for plugin %s: %s", plugin, err)
-					}
-					dailyFile, err := os.CreateTemp("", fmt.Sprintf("%s_daily_response_*.pb", plugin))
-					if err != nil {
-						log.Fatalf("error creating temp file for daily response for plugin %s: %s", plugin, err)
-					}
+				// write daily cost response to a file
+				dailyBytes, err := marshal(respDaily)
+				if err != nil {
+					log.Fatalf("error marshalling daily response for plugin %s: %s", plugin, err)
+				}
+				dailyFile, err := os.CreateTemp("", fmt.Sprintf("%s_daily_response_*.pb", plugin))
+				if err != nil {
+					log.Fatalf("error creating temp file for daily response for plugin %s: %s", plugin, err)
+				}
 
-					_, err = dailyFile.Write(dailyBytes)
+				_, err = dailyFile.Write(dailyBytes)
+				if err != nil {
+					log.Fatalf("error writing daily response for plugin %s: %s", plugin, err)
+				}
+
+				// every plugin must return FOCUS conformant responses, whether or not it has a validator
+				for _, check := range []struct {
+					req  *pb.CustomCostRequest
+					path string
+				}{{reqDaily, dailyFile.Name()}, {reqHourly, hourlyFile.Name()}} {
+					err = invokeFocusCheck(cwd, check.req, check.path)
 					if err != nil {
-						log.Fatalf("error writing daily response for plugin %s: %s", plugin, err)
+						validationErrors = multierror.Append(validationErrors, fmt.Errorf("error testing plugin %s: %w", plugin, err))
 					}
+				}
 
+				// call validator if implemented
+				validator := validatorPath(pluginPath)
+				if validator != "" {
 					err = invokeValidator(validator, hourlyFile.Name(), dailyFile.Name())
 					if err != nil {
 						validationErrors = multierror.Append(validationErrors, fmt.Errorf("error testing plugin %s: %w", plugin, err))
@@ -157,6 +170,24 @@ func invokeValidator(validatorPath, hourlyPath, dailyPath string) error {
 	return nil
 }
 
+// invokeFocusCheck runs the shared FOCUS conformance checker over a saved response
+func invokeFocusCheck(cwd string, req *pb.CustomCostRequest, responsePath string) error {
+	cmd := exec.Command("go", "run", cwd+"/pkg/common/focus/cmd/main",
+		"--start", req.Start.AsTime().Format(time.RFC3339),
+		"--end", req.End.AsTime().Format(time.RFC3339),
+		"--resolution", req.Resolution.AsDuration().String(),
+		responsePath)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Errorf("error running FOCUS check: %s\nOutput: %s", err, output)
+		return fmt.Errorf("response is not FOCUS conformant: %s, output: %s", err, output)
+	}
+
+	fmt.Printf("FOCUS check output:\n%s\n", output)
+	return nil
+}
+
 func marshal(protoResps []*pb.CustomCostResponse) ([]byte, error) {
 	raw := make([]json.RawMessage, len(protoResps))
 	for i, p := range protoResps {
@@ -178,15 +209,18 @@ func validatorPath(plugin string) string {
 	return path
 }
 
-func getResponse(pluginPath, pathToConfigFile string, windowStart, windowEnd time.Time, step time.Duration) []*pb.CustomCostResponse {
-
-	// invoke plugin via harness
-	pluginFile := pluginPath + "/cmd/main/main.go"
-
-	req := pb.CustomCostRequest{
+func newRequest(windowStart, windowEnd time.Time, step time.Duration) *pb.CustomCostRequest {
+	return &pb.CustomCostRequest{
 		Start:      timestamppb.New(windowStart),
 		End:        timestamppb.New(windowEnd),
 		Resolution: durationpb.New(step),
 	}
-	return harness.InvokePlugin(pathToConfigFile, pluginFile, &req)
+}
+
+func getResponse(pluginPath, pathToConfigFile string, req *pb.CustomCostRequest) []*pb.CustomCostResponse {
+
+	// invoke plugin via harness
+	pluginFile := pluginPath + "/cmd/main/main.go"
+
+	return harness.InvokePlugin(pathToConfigFile, pluginFile, req)
 }